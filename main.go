@@ -19,7 +19,7 @@ func main() {
 	delegateSize := 5
 	//pos, slashing, or reputation
 	blockchainType := "pos"
-	//network_partition, balance
+	//network_partition, balance, sybil, adaptive, selfish_proposing, double_spend, replay, light_client
 	attack := "network_partition"
 	pos.Run(runType, numValidators, numUsers, numMal, committeeSize, delegateSize, blockchainType, attack)
 }
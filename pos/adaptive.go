@@ -0,0 +1,131 @@
+package pos
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Number of honest validators the adaptive adversary may corrupt over the whole run
+var corruptionBudget = 10
+
+// Number of validators corrupted each time a committee or delegate set is revealed
+var corruptionRate = 1
+
+// Time slots between the adversary choosing a target and the target turning malicious
+var corruptionDelay = 0
+
+type pendingCorruption struct {
+	validator *Validator
+	round     int
+}
+
+var pendingCorruptions = make([]pendingCorruption, 0)
+
+var corruptionsScheduled = 0
+var corruptionsApplied = 0
+
+// Corruptions that took effect while the target still held its committee or delegate seat
+var corruptionsEffective = 0
+
+var adaptiveRounds = 0
+var adaptiveCapturedRounds = 0
+var adaptiveHonestBlocksRejected = 0
+var adaptiveMaliciousBlocksAccepted = 0
+
+func loadAdaptiveConfig() {
+	corruptionBudget = envInt("CORRUPTION_BUDGET", corruptionBudget)
+	corruptionRate = envInt("CORRUPTION_RATE", corruptionRate)
+	corruptionDelay = envInt("CORRUPTION_DELAY", corruptionDelay)
+}
+
+// adaptiveCorrupt picks honest members of a freshly revealed committee and schedules their corruption
+func adaptiveCorrupt(revealed []*Validator) {
+	targets := make([]*Validator, 0)
+	for _, validator := range revealed {
+		if validator != nil && !validator.IsMalicious && !isCorruptionPending(validator) {
+			targets = append(targets, validator)
+		}
+	}
+	//go after the targets most likely to be chosen as proposer
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].Stake > targets[j].Stake
+	})
+	for i := 0; i < corruptionRate && i < len(targets); i++ {
+		if corruptionsScheduled >= corruptionBudget {
+			break
+		}
+		pendingCorruptions = append(pendingCorruptions, pendingCorruption{
+			validator: targets[i],
+			round:     roundCount + corruptionDelay,
+		})
+		corruptionsScheduled++
+	}
+}
+
+func isCorruptionPending(validator *Validator) bool {
+	for _, pending := range pendingCorruptions {
+		if pending.validator == validator {
+			return true
+		}
+	}
+	return false
+}
+
+// applyPendingCorruptions flips every target whose delay has passed to malicious
+func applyPendingCorruptions(seated []*Validator) {
+	remaining := make([]pendingCorruption, 0)
+	for _, pending := range pendingCorruptions {
+		if pending.round > roundCount {
+			remaining = append(remaining, pending)
+			continue
+		}
+		pending.validator.IsMalicious = true
+		malValidators = append(malValidators, pending.validator)
+		corruptionsApplied++
+		for _, validator := range seated {
+			if validator == pending.validator {
+				corruptionsEffective++
+				break
+			}
+		}
+		fmt.Printf("Adaptive adversary corrupted validator %s\n", pending.validator.Address[:3])
+	}
+	pendingCorruptions = remaining
+}
+
+// adaptiveIsBlockValid makes malicious voters approve adversary blocks and reject honest ones
+func adaptiveIsBlockValid(newBlock Block, isValid bool, malValidator bool) bool {
+	if malValidator {
+		return newBlock.IsMalicious
+	}
+	return isValid
+}
+
+func recordAdaptiveRound(committee []*Validator, newBlock Block, isValid bool) {
+	adaptiveRounds++
+	malMembers := 0
+	for _, validator := range committee {
+		if validator.IsMalicious {
+			malMembers++
+		}
+	}
+	//the malicious members can get a block accepted on their own
+	if len(committee) > 0 && hasQuorum(malMembers, len(committee)) {
+		adaptiveCapturedRounds++
+	}
+	if !newBlock.IsMalicious && !isValid {
+		adaptiveHonestBlocksRejected++
+	}
+	if newBlock.IsMalicious && isValid {
+		adaptiveMaliciousBlocksAccepted++
+	}
+}
+
+func printAdaptiveEvaluation() {
+	fmt.Printf("Corruptions scheduled: %d/%d, applied: %d, while seated: %d\n", corruptionsScheduled, corruptionBudget, corruptionsApplied, corruptionsEffective)
+	if adaptiveRounds > 0 {
+		fmt.Printf("Rounds with captured committee: %d/%d\n", adaptiveCapturedRounds, adaptiveRounds)
+	}
+	fmt.Printf("Honest blocks rejected: %d\n", adaptiveHonestBlocksRejected)
+	fmt.Printf("Malicious blocks accepted: %d\n", adaptiveMaliciousBlocksAccepted)
+}
@@ -0,0 +1,82 @@
+package pos
+
+import "testing"
+
+// resetAdaptiveForTest clears the adversary's state and restores it when the test ends
+func resetAdaptiveForTest(t *testing.T) {
+	t.Helper()
+	previousBudget, previousRate, previousDelay := corruptionBudget, corruptionRate, corruptionDelay
+	previousPending, previousMal, previousRound := pendingCorruptions, malValidators, roundCount
+	previousScheduled, previousApplied, previousEffective := corruptionsScheduled, corruptionsApplied, corruptionsEffective
+	previousRounds, previousCaptured := adaptiveRounds, adaptiveCapturedRounds
+	previousRejected, previousAccepted := adaptiveHonestBlocksRejected, adaptiveMaliciousBlocksAccepted
+	t.Cleanup(func() {
+		corruptionBudget, corruptionRate, corruptionDelay = previousBudget, previousRate, previousDelay
+		pendingCorruptions, malValidators, roundCount = previousPending, previousMal, previousRound
+		corruptionsScheduled, corruptionsApplied, corruptionsEffective = previousScheduled, previousApplied, previousEffective
+		adaptiveRounds, adaptiveCapturedRounds = previousRounds, previousCaptured
+		adaptiveHonestBlocksRejected, adaptiveMaliciousBlocksAccepted = previousRejected, previousAccepted
+	})
+	pendingCorruptions = make([]pendingCorruption, 0)
+	malValidators = make([]*Validator, 0)
+	corruptionsScheduled, corruptionsApplied, corruptionsEffective = 0, 0, 0
+	adaptiveRounds, adaptiveCapturedRounds = 0, 0
+	adaptiveHonestBlocksRejected, adaptiveMaliciousBlocksAccepted = 0, 0
+}
+
+func TestAdaptiveCorruptionSchedule(t *testing.T) {
+	resetAdaptiveForTest(t)
+	corruptionBudget, corruptionRate, corruptionDelay = 2, 1, 2
+	roundCount = 10
+	small := &Validator{Address: "small", Stake: 10}
+	large := &Validator{Address: "large", Stake: 100}
+	other := &Validator{Address: "other", Stake: 50}
+
+	//the largest stake is targeted first, and a pending target is not chosen again
+	adaptiveCorrupt([]*Validator{small, large})
+	adaptiveCorrupt([]*Validator{large, other})
+	adaptiveCorrupt([]*Validator{small})
+	if corruptionsScheduled != 2 || len(pendingCorruptions) != 2 {
+		t.Fatalf("scheduled %d corruptions, want the budget of 2", corruptionsScheduled)
+	}
+	if pendingCorruptions[0].validator != large || pendingCorruptions[1].validator != other {
+		t.Fatal("corruptions not scheduled in stake order")
+	}
+
+	applyPendingCorruptions([]*Validator{large})
+	if corruptionsApplied != 0 || large.IsMalicious {
+		t.Fatal("corruption applied before its delay passed")
+	}
+	roundCount = 12
+	applyPendingCorruptions([]*Validator{large})
+	if !large.IsMalicious || !other.IsMalicious || small.IsMalicious {
+		t.Fatal("targets not corrupted once the delay passed")
+	}
+	if corruptionsApplied != 2 || corruptionsEffective != 1 || len(malValidators) != 2 {
+		t.Fatalf("got %d applied and %d while seated, want 2 and 1", corruptionsApplied, corruptionsEffective)
+	}
+	if len(pendingCorruptions) != 0 {
+		t.Fatalf("%d corruptions still pending", len(pendingCorruptions))
+	}
+}
+
+func TestRecordAdaptiveRoundCountsCapturedCommittees(t *testing.T) {
+	resetAdaptiveForTest(t)
+	committee := []*Validator{{Address: "a"}, {Address: "b"}, {Address: "c"}, {Address: "d"}}
+	honestBlock := Block{}
+	maliciousBlock := Block{IsMalicious: true}
+
+	committee[0].IsMalicious = true
+	recordAdaptiveRound(committee, honestBlock, true)
+	//the committee is captured once the malicious members alone reach the acceptance threshold
+	committee[1].IsMalicious = true
+	recordAdaptiveRound(committee, honestBlock, false)
+	recordAdaptiveRound(committee, maliciousBlock, true)
+
+	if adaptiveRounds != 3 || adaptiveCapturedRounds != 2 {
+		t.Fatalf("got %d captured rounds of %d, want 2 of 3", adaptiveCapturedRounds, adaptiveRounds)
+	}
+	if adaptiveHonestBlocksRejected != 1 || adaptiveMaliciousBlocksAccepted != 1 {
+		t.Fatalf("got %d honest rejected and %d malicious accepted, want 1 and 1", adaptiveHonestBlocksRejected, adaptiveMaliciousBlocksAccepted)
+	}
+}
@@ -0,0 +1,477 @@
+package pos
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The HTTP API serves read-only JSON views of the running simulation: the certified chain, each
+// validator's chain and mempool, the committee of the current round, user balances and the
+// evaluation counters. Chains, stakes, reputations and the committee are served as of the last
+// completed round, from a view the simulation publishes after every round.
+
+// Port the HTTP API listens on, no API is served when empty
+var httpPort = ""
+
+// apiView is the state of the simulation at the end of a round
+type apiView struct {
+	round        int
+	certified    []Block
+	proposer     string
+	committee    []string
+	delegates    []string
+	validators   []validatorView
+	forked       bool
+	forks        int
+	checkpoints  int
+	tokensIssued float64
+}
+
+// validatorView is a validator and its chain at the end of a round
+type validatorView struct {
+	apiValidator
+	chain []Block
+}
+
+var apiLock = &sync.Mutex{}
+
+// View the handlers serve, replaced by publishAPIView under apiLock
+var publishedView = &apiView{}
+
+type apiTransaction struct {
+	ID       int     `json:"id"`
+	Sender   string  `json:"sender"`
+	Receiver string  `json:"receiver"`
+	Amount   float64 `json:"amount"`
+	Fee      float64 `json:"fee"`
+	Nonce    int     `json:"nonce"`
+}
+
+type apiBlock struct {
+	Index        int              `json:"index"`
+	Hash         string           `json:"hash"`
+	PrevHash     string           `json:"prevHash"`
+	Timestamp    string           `json:"timestamp"`
+	Proposer     string           `json:"proposer"`
+	StateRoot    string           `json:"stateRoot"`
+	TxRoot       string           `json:"txRoot"`
+	BaseFee      float64          `json:"baseFee"`
+	Malicious    bool             `json:"malicious"`
+	Signers      int              `json:"signers"`
+	Transactions []apiTransaction `json:"transactions"`
+}
+
+type apiValidator struct {
+	Name        string  `json:"name"`
+	Address     string  `json:"address"`
+	Stake       float64 `json:"stake"`
+	Reputation  float64 `json:"reputation"`
+	Malicious   bool    `json:"malicious"`
+	Crashed     bool    `json:"crashed"`
+	ChainLength int     `json:"chainLength"`
+	Head        string  `json:"head"`
+	Pending     int     `json:"pending"`
+}
+
+type apiUser struct {
+	Name    string  `json:"name"`
+	Address string  `json:"address"`
+	Balance float64 `json:"balance"`
+	Nonce   int     `json:"nonce"`
+}
+
+type apiCommittee struct {
+	Round     int      `json:"round"`
+	Proposer  string   `json:"proposer"`
+	Committee []string `json:"committee"`
+	Delegates []string `json:"delegates"`
+}
+
+type apiStats struct {
+	Round             int     `json:"round"`
+	BlockchainType    string  `json:"blockchainType"`
+	Attack            string  `json:"attack"`
+	Seconds           float64 `json:"seconds"`
+	SimulatedSeconds  float64 `json:"simulatedSeconds"`
+	Blocks            int     `json:"blocks"`
+	MaliciousBlocks   int     `json:"maliciousBlocks"`
+	Transactions      int     `json:"transactions"`
+	Validators        int     `json:"validators"`
+	Users             int     `json:"users"`
+	Forked            bool    `json:"forked"`
+	Forks             int     `json:"forks"`
+	Checkpoints       int     `json:"checkpoints"`
+	TokensIssued      float64 `json:"tokensIssued"`
+	Pending           int     `json:"pending"`
+	MessagesSent      int     `json:"messagesSent"`
+	MessagesDropped   int     `json:"messagesDropped"`
+	SyncsStarted      int     `json:"syncsStarted"`
+	SyncsCompleted    int     `json:"syncsCompleted"`
+	ValidatorsCrashed int     `json:"validatorsCrashed"`
+	PartitionDropped  int     `json:"partitionDropped"`
+	MessagesCorrupted int     `json:"messagesCorrupted"`
+	MessagesReordered int     `json:"messagesReordered"`
+}
+
+func loadAPIConfig() {
+	httpPort = envString("HTTP_PORT", httpPort)
+}
+
+// startAPI serves the HTTP API in the background
+func startAPI() {
+	if httpPort == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/chain", serveChain)
+	mux.HandleFunc("/validators", serveValidators)
+	mux.HandleFunc("/validators/", serveValidator)
+	mux.HandleFunc("/mempool/", serveMempool)
+	mux.HandleFunc("/committee", serveCommittee)
+	mux.HandleFunc("/users", serveUsers)
+	mux.HandleFunc("/stats", serveStats)
+	mux.HandleFunc("/events", serveEvents)
+	mux.HandleFunc("/metrics", serveMetrics)
+	server := &http.Server{Addr: ":" + httpPort, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			log.Println("HTTP API stopped:", err)
+		}
+	}()
+	log.Println("HTTP API Listening on port :", httpPort)
+}
+
+func writeJSON(w http.ResponseWriter, value interface{}) {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(data, '\n'))
+}
+
+// allowGet rejects requests other than GET, returning false when it did
+func allowGet(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+func userName(user *User) string {
+	if user == nil {
+		return ""
+	}
+	return user.Name
+}
+
+func toAPITransaction(transaction Transaction) apiTransaction {
+	return apiTransaction{
+		ID:       transaction.ID,
+		Sender:   userName(transaction.Sender),
+		Receiver: userName(transaction.Receiver),
+		Amount:   transaction.Amount,
+		Fee:      transaction.Reward,
+		Nonce:    transaction.Nonce,
+	}
+}
+
+func toAPIBlock(block Block) apiBlock {
+	exported := apiBlock{
+		Index:        block.Index,
+		Hash:         block.Hash,
+		PrevHash:     block.PrevHash,
+		Timestamp:    block.Timestamp,
+		Proposer:     block.Validator,
+		StateRoot:    block.StateRoot,
+		TxRoot:       block.TxRoot,
+		BaseFee:      block.BaseFee,
+		Malicious:    block.IsMalicious,
+		Signers:      len(block.Certificate.Votes),
+		Transactions: make([]apiTransaction, 0, len(block.Transactions)),
+	}
+	for _, transaction := range block.Transactions {
+		exported.Transactions = append(exported.Transactions, toAPITransaction(transaction))
+	}
+	return exported
+}
+
+// toAPIChain converts a chain, keeping only the blocks from index from onwards
+func toAPIChain(chain []Block, from int) []apiBlock {
+	blocks := make([]apiBlock, 0, len(chain))
+	for _, block := range chain {
+		if block.Index >= from {
+			blocks = append(blocks, toAPIBlock(block))
+		}
+	}
+	return blocks
+}
+
+// fromIndex reads the optional from query parameter, the first block index to return
+func fromIndex(r *http.Request) (int, error) {
+	value := r.URL.Query().Get("from")
+	if value == "" {
+		return 0, nil
+	}
+	from, err := strconv.Atoi(value)
+	if err != nil || from < 0 {
+		return 0, fmt.Errorf("from=%s is not a block index", value)
+	}
+	return from, nil
+}
+
+// publishAPIView records the state of the round that just ended for the handlers. It runs on the
+// goroutine that plays the rounds, which is the one that changes stakes and reputations
+func publishAPIView() {
+	if httpPort == "" {
+		return
+	}
+	validatorsSliceLock.Lock()
+	view := &apiView{
+		round:        roundCount,
+		certified:    CertifiedBlockchain,
+		proposer:     validatorName(proposer),
+		committee:    validatorNames(validationCommittee),
+		delegates:    validatorNames(delegates),
+		validators:   make([]validatorView, 0, len(validators)),
+		forked:       forked,
+		forks:        forkCount,
+		checkpoints:  runConsensusCounter,
+		tokensIssued: totalIssued,
+	}
+	validatorsCopy := make([]*Validator, len(validators))
+	copy(validatorsCopy, validators)
+	validatorsSliceLock.Unlock()
+
+	for _, validator := range validatorsCopy {
+		chain := chainSnapshot(validator)
+		view.validators = append(view.validators, validatorView{
+			apiValidator: apiValidator{
+				Name:        validator.name,
+				Address:     validator.Address,
+				Stake:       validator.Stake,
+				Reputation:  validator.reputation,
+				Malicious:   validator.IsMalicious,
+				Crashed:     crashed(validator),
+				ChainLength: len(chain),
+				Head:        chain[len(chain)-1].Hash,
+				Pending:     validator.pool.size(),
+			},
+			chain: chain,
+		})
+	}
+	apiLock.Lock()
+	publishedView = view
+	apiLock.Unlock()
+}
+
+// currentView returns the view of the last completed round
+func currentView() *apiView {
+	apiLock.Lock()
+	defer apiLock.Unlock()
+	return publishedView
+}
+
+// findValidator looks a validator up by name or address
+func findValidator(id string) *Validator {
+	validatorsSliceLock.Lock()
+	defer validatorsSliceLock.Unlock()
+	for _, validator := range validators {
+		if validator.name == id || validator.Address == id {
+			return validator
+		}
+	}
+	return nil
+}
+
+// findValidatorView looks a validator up by name or address in a view
+func findValidatorView(view *apiView, id string) *validatorView {
+	for i := range view.validators {
+		if view.validators[i].Name == id || view.validators[i].Address == id {
+			return &view.validators[i]
+		}
+	}
+	return nil
+}
+
+func validatorName(validator *Validator) string {
+	if validator == nil {
+		return ""
+	}
+	return validator.name
+}
+
+func validatorNames(members []*Validator) []string {
+	names := make([]string, 0, len(members))
+	for _, member := range members {
+		names = append(names, validatorName(member))
+	}
+	return names
+}
+
+// serveChain returns the certified chain, GET /chain?from=<index>
+func serveChain(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	from, err := fromIndex(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, toAPIChain(currentView().certified, from))
+}
+
+// serveValidators lists the validators that joined, GET /validators
+func serveValidators(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	view := currentView()
+	list := make([]apiValidator, 0, len(view.validators))
+	for _, validator := range view.validators {
+		list = append(list, validator.apiValidator)
+	}
+	writeJSON(w, list)
+}
+
+// serveValidator returns a validator's chain, GET /validators/<name or address>/chain?from=<index>
+func serveValidator(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	fields := strings.Split(strings.TrimPrefix(r.URL.Path, "/validators/"), "/")
+	if len(fields) != 2 || fields[1] != "chain" {
+		http.NotFound(w, r)
+		return
+	}
+	validator := findValidatorView(currentView(), fields[0])
+	if validator == nil {
+		http.Error(w, "no validator "+fields[0], http.StatusNotFound)
+		return
+	}
+	from, err := fromIndex(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, toAPIChain(validator.chain, from))
+}
+
+// serveMempool returns a validator's pending transactions by fee, GET /mempool/<name or address>
+func serveMempool(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/mempool/")
+	validator := findValidator(id)
+	if validator == nil {
+		http.Error(w, "no validator "+id, http.StatusNotFound)
+		return
+	}
+	validator.pool.lock.Lock()
+	pending := make([]apiTransaction, 0, len(validator.pool.pending))
+	for _, transaction := range validator.pool.pending {
+		pending = append(pending, toAPITransaction(transaction))
+	}
+	validator.pool.lock.Unlock()
+	sort.Slice(pending, func(i, j int) bool {
+		if pending[i].Fee != pending[j].Fee {
+			return pending[i].Fee > pending[j].Fee
+		}
+		return pending[i].ID < pending[j].ID
+	})
+	writeJSON(w, pending)
+}
+
+// serveCommittee returns the proposer, validation committee and delegates of the current round, GET /committee
+func serveCommittee(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	view := currentView()
+	writeJSON(w, apiCommittee{
+		Round:     view.round,
+		Proposer:  view.proposer,
+		Committee: view.committee,
+		Delegates: view.delegates,
+	})
+}
+
+// serveUsers returns every user's balance, GET /users
+func serveUsers(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	usersSliceLock.Lock()
+	list := make([]apiUser, 0, len(users))
+	for _, user := range users {
+		user.userLock.Lock()
+		list = append(list, apiUser{Name: user.Name, Address: user.Address, Balance: user.Balance, Nonce: user.nonce})
+		user.userLock.Unlock()
+	}
+	usersSliceLock.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	writeJSON(w, list)
+}
+
+// serveStats returns the counters of the evaluation, GET /stats
+func serveStats(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	view := currentView()
+	stats := apiStats{
+		Round:            view.round,
+		BlockchainType:   blockchainType,
+		Attack:           currAttack,
+		Seconds:          time.Since(startTime).Seconds(),
+		SimulatedSeconds: clock.now().Seconds(),
+		Forked:           view.forked,
+		Forks:            view.forks,
+		Checkpoints:      view.checkpoints,
+		TokensIssued:     view.tokensIssued,
+		Validators:       len(view.validators),
+	}
+	for _, validator := range view.validators {
+		stats.Pending += validator.Pending
+	}
+	stats.Blocks = len(view.certified)
+	for _, block := range view.certified {
+		if block.IsMalicious {
+			stats.MaliciousBlocks++
+		}
+		stats.Transactions += len(block.Transactions)
+	}
+	usersSliceLock.Lock()
+	stats.Users = len(users)
+	usersSliceLock.Unlock()
+
+	simNetwork.lock.Lock()
+	stats.MessagesSent = simNetwork.sent
+	stats.MessagesDropped = simNetwork.dropped
+	simNetwork.lock.Unlock()
+	syncs.lock.Lock()
+	stats.SyncsStarted = syncs.started
+	stats.SyncsCompleted = syncs.completed
+	syncs.lock.Unlock()
+	faultLock.Lock()
+	stats.ValidatorsCrashed = crashCount
+	stats.MessagesCorrupted = corruptedMessages
+	stats.MessagesReordered = reorderedMessages
+	faultLock.Unlock()
+	partitionLock.Lock()
+	stats.PartitionDropped = partitionDropped
+	partitionLock.Unlock()
+	writeJSON(w, stats)
+}
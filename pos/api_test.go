@@ -0,0 +1,68 @@
+package pos
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// getJSON calls a handler and decodes its JSON response into value
+func getJSON(t *testing.T, handler http.HandlerFunc, path string, value interface{}) {
+	t.Helper()
+	recorder := httptest.NewRecorder()
+	handler(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET %s: status %d", path, recorder.Code)
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), value); err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+}
+
+func TestAPIServesPublishedView(t *testing.T) {
+	previousPort, previousView := httpPort, publishedView
+	previousValidators, previousChain, previousForks := validators, CertifiedBlockchain, forkCount
+	t.Cleanup(func() {
+		httpPort, publishedView = previousPort, previousView
+		validators, CertifiedBlockchain, forkCount = previousValidators, previousChain, previousForks
+	})
+	httpPort = "0"
+
+	genesis := newTestGenesis()
+	head := newTestBlock(genesis, "proposer", nil)
+	validator := newTestValidator(t, []Block{genesis, head})
+	validator.name, validator.Address, validator.Stake = "validator0", "address0", 40
+	validator.pool = newMempool()
+	validators = []*Validator{validator}
+	CertifiedBlockchain = []Block{genesis}
+	forkCount = 2
+	publishAPIView()
+
+	//changes after the round ended are not served until the next view is published
+	validator.Stake = 8
+	forkCount = 3
+	appendBlock(validator, newTestBlock(head, "proposer", nil), true)
+
+	stats := apiStats{}
+	getJSON(t, serveStats, "/stats", &stats)
+	if stats.Forks != 2 || stats.Blocks != 1 || stats.Validators != 1 {
+		t.Fatalf("got forks %d, blocks %d, validators %d, want 2, 1, 1", stats.Forks, stats.Blocks, stats.Validators)
+	}
+	list := []apiValidator{}
+	getJSON(t, serveValidators, "/validators", &list)
+	if len(list) != 1 || list[0].Stake != 40 || list[0].ChainLength != 2 {
+		t.Fatalf("got %+v, want the validator as published", list)
+	}
+	chain := []apiBlock{}
+	getJSON(t, serveValidator, "/validators/validator0/chain?from=1", &chain)
+	if len(chain) != 1 || chain[0].Hash != head.Hash {
+		t.Fatalf("got %d blocks from index 1, want the published head", len(chain))
+	}
+
+	publishAPIView()
+	getJSON(t, serveStats, "/stats", &stats)
+	if stats.Forks != 3 {
+		t.Fatalf("got %d forks after publishing again, want 3", stats.Forks)
+	}
+}
@@ -13,7 +13,25 @@ type Block struct {
 	Hash         string
 	PrevHash     string
 	Validator    string
-	IsMalicious  bool
+	StateRoot    string
+	TxRoot       string
+	BaseFee      float64
+	//not covered by the hash, both sign it
+	ProposerSignature string
+	Certificate       CommitCertificate
+	IsMalicious       bool
+}
+
+// BlockHeader is the part of a block light clients follow, committing to transactions through TxRoot
+type BlockHeader struct {
+	Index     int
+	Timestamp string
+	PrevHash  string
+	Validator string
+	StateRoot string
+	TxRoot    string
+	BaseFee   float64
+	Hash      string
 }
 
 // SHA256 hasing
@@ -25,11 +43,56 @@ func calculateHash(s string) string {
 	return hex.EncodeToString(hashed)
 }
 
-// calculateBlockHash returns the hash of all block information
+// calculateBlockHash returns the header hash with the transaction root recomputed from the block's transactions
 func calculateBlockHash(block Block) string {
-	record := fmt.Sprintf("%d%s%s", block.Index, block.Timestamp, block.PrevHash)
-	for _, transaction := range block.Transactions {
-		record += fmt.Sprintf("%d%p%p%s%f", transaction.ID, transaction.Sender, transaction.Receiver, transaction.Signature, transaction.Reward)
+	header := block.header()
+	header.TxRoot = transactionRoot(block.Transactions)
+	return calculateHeaderHash(header)
+}
+
+// calculateHeaderHash returns the hash of the canonical header encoding
+func calculateHeaderHash(header BlockHeader) string {
+	hashed := sha256.Sum256(headerHashData(header))
+	return hex.EncodeToString(hashed[:])
+}
+
+func (block Block) header() BlockHeader {
+	return BlockHeader{
+		Index:     block.Index,
+		Timestamp: block.Timestamp,
+		PrevHash:  block.PrevHash,
+		Validator: block.Validator,
+		StateRoot: block.StateRoot,
+		TxRoot:    block.TxRoot,
+		BaseFee:   block.BaseFee,
+		Hash:      block.Hash,
+	}
+}
+
+// transactionRoot is the Merkle root over the canonical encodings of a block's transactions
+func transactionRoot(transactions []Transaction) string {
+	return merkleRoot(transactionLeaves(transactions))
+}
+
+func transactionLeaves(transactions []Transaction) [][]byte {
+	leaves := make([][]byte, len(transactions))
+	for i, transaction := range transactions {
+		leaves[i] = encodeTransaction(transaction)
+	}
+	return leaves
+}
+
+// transactionInclusionProof proves that the transaction with the given ID is committed to by the block's TxRoot
+func transactionInclusionProof(block Block, id int) (Transaction, merkleProof, error) {
+	for i, transaction := range block.Transactions {
+		if transaction.ID == id {
+			return transaction, merkleProofFor(transactionLeaves(block.Transactions), i), nil
+		}
 	}
-	return calculateHash(record)
+	return Transaction{}, merkleProof{}, fmt.Errorf("transaction %d is not in block %d", id, block.Index)
+}
+
+// verifyTransactionInclusion checks an inclusion proof against a header without needing the block body
+func verifyTransactionInclusion(header BlockHeader, transaction Transaction, proof merkleProof) bool {
+	return verifyMerkleProof(header.TxRoot, encodeTransaction(transaction), proof)
 }
@@ -0,0 +1,179 @@
+package pos
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Blocks are signed by their proposer and carry a commit certificate with the signature of every
+// committee member that voted for them. Signatures are kept individually; the standard library has
+// no BLS implementation, so certificates are not aggregated.
+
+// CommitVote is one committee member's signature over a block hash
+type CommitVote struct {
+	Validator string
+	Signature string
+}
+
+// CommitCertificate records the committee votes that got a block added to the chain
+// The server draws committees and signs each member list with the hash of the block it voted on,
+// so a certificate cannot swap in a smaller or different set of validators
+type CommitCertificate struct {
+	Committee          []string
+	CommitteeSignature string
+	Votes              []CommitVote
+}
+
+// Key the server signs committee member lists with, kept in the keystore so a resumed simulation
+// can still verify the certificates of its stored blocks
+var beaconKeys keyPair
+
+// Number of votes for a block whose signature did not verify when the server received them
+var voteSignaturesRejected = 0
+
+var voteSignaturesLock = &sync.Mutex{}
+
+func loadBeaconKey() {
+	keys, err := loadOrGenerateKey("beacon")
+	if err != nil {
+		log.Fatal(err)
+	}
+	beaconKeys = keys
+}
+
+const (
+	halfQuorum   = "half"
+	strictQuorum = "strict"
+)
+
+// Votes a block needs: half of its committee (rounded down), or a strict majority
+var quorum = halfQuorum
+
+func loadCertificateConfig() {
+	quorum = envString("QUORUM", quorum)
+	if quorum != halfQuorum && quorum != strictQuorum {
+		fmt.Printf("Unknown QUORUM %s, using %s\n", quorum, halfQuorum)
+		quorum = halfQuorum
+	}
+}
+
+// hasQuorum is the acceptance rule for blocks, used both when the committee votes and when a certificate is verified
+func hasQuorum(votes int, committeeSize int) bool {
+	if quorum == strictQuorum {
+		return votes > committeeSize/2
+	}
+	return votes >= committeeSize/2
+}
+
+// newCommitCertificate binds the committee that voted on a block to its hash and stores their votes
+func newCommitCertificate(hash string, committee []*Validator, votes []CommitVote) CommitCertificate {
+	members := make([]string, len(committee))
+	for i, validator := range committee {
+		members[i] = validator.Address
+	}
+	signature, err := beaconKeys.sign(committeeSigningData(hash, members))
+	if err != nil {
+		fmt.Println("Could not sign committee:", err)
+	}
+	return CommitCertificate{Committee: members, CommitteeSignature: signature, Votes: votes}
+}
+
+// signBlock signs the block hash with the proposer's key
+func signBlock(block *Block, proposer *Validator) {
+	signature, err := proposer.keys.sign(proposerSigningData(block.Hash))
+	if err != nil {
+		fmt.Println("Could not sign block:", err)
+	}
+	block.ProposerSignature = signature
+}
+
+// signCommitVote is a committee member's vote for a block hash
+func signCommitVote(validator *Validator, hash string) string {
+	signature, err := validator.keys.sign(commitVoteData(hash))
+	if err != nil {
+		fmt.Println("Could not sign vote:", err)
+	}
+	return signature
+}
+
+func verifyValidatorSignature(address string, message []byte, signature string) bool {
+	validator := validatorByAddress(address)
+	if validator == nil {
+		return false
+	}
+	return verifySignature(validator.keys.scheme(), validator.PublicKey, message, signature)
+}
+
+// checkVoteSignatures verifies the signatures of a committee member's vote when the server receives it
+// A vote for a block whose signature does not verify is counted as a vote against the block
+func checkVoteSignatures(validator *Validator, msg interface{}) interface{} {
+	switch vote := msg.(type) {
+	case ValidationStatusMessage:
+		if vote.isValid && !verifyVote(validator, vote.hash, vote.signature) {
+			vote.isValid = false
+		}
+		return vote
+	case ValidationShortAttackStatusMessage:
+		if vote.isValid && !verifyVote(validator, vote.hash, vote.signature) {
+			vote.isValid = false
+		}
+		if vote.isValidTwo && !verifyVote(validator, vote.hashTwo, vote.signatureTwo) {
+			vote.isValidTwo = false
+		}
+		return vote
+	}
+	return msg
+}
+
+func verifyVote(validator *Validator, hash string, signature string) bool {
+	if verifyValidatorSignature(validator.Address, commitVoteData(hash), signature) {
+		return true
+	}
+	fmt.Printf("Vote of %s has an invalid signature\n", validator.Address[:3])
+	voteSignaturesLock.Lock()
+	voteSignaturesRejected++
+	voteSignaturesLock.Unlock()
+	return false
+}
+
+// verifyCertificate checks the proposer signature on a block hash, the server's signature on the committee
+// and that a quorum of that committee signed the hash, counting each member once
+func verifyCertificate(hash string, proposerAddress string, proposerSignature string, certificate CommitCertificate) error {
+	if !verifyValidatorSignature(proposerAddress, proposerSigningData(hash), proposerSignature) {
+		return errors.New("proposer signature is invalid")
+	}
+	if len(certificate.Committee) == 0 {
+		return errors.New("certificate has no committee")
+	}
+	if beaconKeys == nil || !verifySignature(beaconKeys.scheme(), beaconKeys.publicKey(), committeeSigningData(hash, certificate.Committee), certificate.CommitteeSignature) {
+		return errors.New("committee signature is invalid")
+	}
+	members := make(map[string]bool)
+	for _, member := range certificate.Committee {
+		members[member] = true
+	}
+	voted := make(map[string]bool)
+	for _, vote := range certificate.Votes {
+		if !members[vote.Validator] {
+			return fmt.Errorf("vote of %s is not from a committee member", vote.Validator)
+		}
+		if voted[vote.Validator] {
+			continue
+		}
+		if !verifyValidatorSignature(vote.Validator, commitVoteData(hash), vote.Signature) {
+			return fmt.Errorf("vote signature of %s is invalid", vote.Validator)
+		}
+		voted[vote.Validator] = true
+	}
+	if !hasQuorum(len(voted), len(members)) {
+		return fmt.Errorf("certificate has %d of %d committee votes", len(voted), len(members))
+	}
+	return nil
+}
+
+// verifyBlockCertificate checks the signatures stored with a verified block
+func verifyBlockCertificate(block Block) error {
+	return verifyCertificate(block.Hash, block.Validator, block.ProposerSignature, block.Certificate)
+}
@@ -0,0 +1,110 @@
+package pos
+
+import "testing"
+
+// newTestCommittee registers validators with fresh keys and a server committee key
+func newTestCommittee(t *testing.T, size int) []*Validator {
+	t.Helper()
+	keys, err := generateKeyPair(ed25519Scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previousKeys, previousValidators := beaconKeys, validators
+	beaconKeys = keys
+	committee := make([]*Validator, size)
+	for i := range committee {
+		keys, err := generateKeyPair(ed25519Scheme)
+		if err != nil {
+			t.Fatal(err)
+		}
+		committee[i] = &Validator{Address: addressFromPublicKey(keys.publicKey()), PublicKey: keys.publicKey(), keys: keys}
+	}
+	validators = append([]*Validator{}, committee...)
+	t.Cleanup(func() { beaconKeys, validators = previousKeys, previousValidators })
+	return committee
+}
+
+func testVotes(hash string, voters []*Validator) []CommitVote {
+	votes := make([]CommitVote, len(voters))
+	for i, voter := range voters {
+		votes[i] = CommitVote{Validator: voter.Address, Signature: signCommitVote(voter, hash)}
+	}
+	return votes
+}
+
+func TestVerifyCertificateQuorum(t *testing.T) {
+	committee := newTestCommittee(t, 4)
+	block := newTestBlock(newTestGenesis(), committee[0].Address, nil)
+	signBlock(&block, committee[0])
+
+	block.Certificate = newCommitCertificate(block.Hash, committee, testVotes(block.Hash, committee[:1]))
+	if err := verifyBlockCertificate(block); err == nil {
+		t.Fatal("certificate with a quarter of the committee verified")
+	}
+	//half of the committee is enough, as in the committee vote
+	block.Certificate = newCommitCertificate(block.Hash, committee, testVotes(block.Hash, committee[:2]))
+	if err := verifyBlockCertificate(block); err != nil {
+		t.Fatal(err)
+	}
+	//repeating a vote does not count it twice
+	block.Certificate = newCommitCertificate(block.Hash, committee, testVotes(block.Hash, []*Validator{committee[0], committee[0]}))
+	if err := verifyBlockCertificate(block); err == nil {
+		t.Fatal("certificate with a repeated vote verified")
+	}
+}
+
+func TestStrictQuorum(t *testing.T) {
+	previousQuorum := quorum
+	t.Cleanup(func() { quorum = previousQuorum })
+	committee := newTestCommittee(t, 4)
+	block := newTestBlock(newTestGenesis(), committee[0].Address, nil)
+	signBlock(&block, committee[0])
+
+	quorum = strictQuorum
+	if hasQuorum(2, 4) || !hasQuorum(3, 4) || !hasQuorum(2, 3) {
+		t.Fatal("strict quorum is not a strict majority")
+	}
+	//half of the committee is not a strict majority
+	block.Certificate = newCommitCertificate(block.Hash, committee, testVotes(block.Hash, committee[:2]))
+	if err := verifyBlockCertificate(block); err == nil {
+		t.Fatal("certificate with half of the committee verified under a strict quorum")
+	}
+	block.Certificate = newCommitCertificate(block.Hash, committee, testVotes(block.Hash, committee[:3]))
+	if err := verifyBlockCertificate(block); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyCertificateRejectsForgedCommittee(t *testing.T) {
+	registered := newTestCommittee(t, 5)
+	committee, outsider := registered[:4], registered[4]
+	block := newTestBlock(newTestGenesis(), committee[0].Address, nil)
+	signBlock(&block, committee[0])
+
+	//shrinking the committee to the validators that voted breaks the server's signature
+	certificate := newCommitCertificate(block.Hash, committee, testVotes(block.Hash, committee[:1]))
+	certificate.Committee = certificate.Committee[:1]
+	block.Certificate = certificate
+	if err := verifyBlockCertificate(block); err == nil {
+		t.Fatal("certificate with a shrunk committee verified")
+	}
+
+	//registered validators outside the committee cannot vote
+	block.Certificate = newCommitCertificate(block.Hash, committee[:1], testVotes(block.Hash, []*Validator{committee[0], outsider}))
+	if err := verifyBlockCertificate(block); err == nil {
+		t.Fatal("certificate with a vote from outside the committee verified")
+	}
+}
+
+func TestCheckVoteSignatures(t *testing.T) {
+	committee := newTestCommittee(t, 2)
+	hash := newTestGenesis().Hash
+	forged := ValidationStatusMessage{hash: hash, isValid: true, signature: signCommitVote(committee[1], hash)}
+	if vote := checkVoteSignatures(committee[0], forged).(ValidationStatusMessage); vote.isValid {
+		t.Fatal("vote signed by another validator was counted")
+	}
+	signed := ValidationStatusMessage{hash: hash, isValid: true, signature: signCommitVote(committee[0], hash)}
+	if vote := checkVoteSignatures(committee[0], signed).(ValidationStatusMessage); !vote.isValid {
+		t.Fatal("correctly signed vote was not counted")
+	}
+}
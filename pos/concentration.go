@@ -0,0 +1,155 @@
+package pos
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// concentration measures how evenly stake or reputation is spread over the validators
+type concentration struct {
+	round          int
+	gini           float64
+	nakamotoThird  int
+	nakamotoHalf   int
+	herfindahl     float64
+	maliciousShare float64
+}
+
+// Concentration of stake and reputation after every round
+var stakeConcentration = make([]concentration, 0)
+var reputationConcentration = make([]concentration, 0)
+
+// measureConcentration computes the concentration of values, where malicious[i] marks the holder of values[i]
+func measureConcentration(values []float64, malicious []bool) concentration {
+	c := concentration{}
+	total := 0.0
+	maliciousTotal := 0.0
+	for i, value := range values {
+		total += value
+		if malicious[i] {
+			maliciousTotal += value
+		}
+	}
+	if len(values) == 0 || total <= 0 {
+		return c
+	}
+	c.maliciousShare = maliciousTotal / total
+
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	//Gini coefficient over the values in ascending order
+	weighted := 0.0
+	for i, value := range sorted {
+		weighted += float64(i+1) * value
+	}
+	n := float64(len(sorted))
+	c.gini = 2*weighted/(n*total) - (n+1)/n
+
+	for _, value := range sorted {
+		share := value / total
+		c.herfindahl += share * share
+	}
+
+	//Nakamoto coefficients count the largest holders needed to control more than 1/3 and 1/2
+	held := 0.0
+	for i := len(sorted) - 1; i >= 0; i-- {
+		held += sorted[i]
+		count := len(sorted) - i
+		if c.nakamotoThird == 0 && held > total/3 {
+			c.nakamotoThird = count
+		}
+		if c.nakamotoHalf == 0 && held > total/2 {
+			c.nakamotoHalf = count
+			break
+		}
+	}
+	return c
+}
+
+// recordConcentration measures the stake and reputation distributions at the end of a round
+func recordConcentration() {
+	validatorsSliceLock.Lock()
+	stakes := make([]float64, 0, len(validators))
+	reputations := make([]float64, 0, len(validators))
+	malicious := make([]bool, 0, len(validators))
+	for _, validator := range validators {
+		stakes = append(stakes, validator.Stake)
+		reputations = append(reputations, validator.reputation)
+		malicious = append(malicious, validator.IsMalicious)
+	}
+	validatorsSliceLock.Unlock()
+	if len(stakes) == 0 {
+		return
+	}
+
+	stake := measureConcentration(stakes, malicious)
+	reputation := measureConcentration(reputations, malicious)
+	stake.round, reputation.round = roundCount+1, roundCount+1
+	stakeConcentration = append(stakeConcentration, stake)
+	reputationConcentration = append(reputationConcentration, reputation)
+}
+
+// concentrationRecords lays out the stake and reputation series as CSV rows, one per round and distribution
+func concentrationRecords() [][]string {
+	records := [][]string{{"round", "distribution", "gini", "herfindahl", "nakamoto_third", "nakamoto_half", "malicious_share"}}
+	for _, series := range []struct {
+		name    string
+		history []concentration
+	}{{"stake", stakeConcentration}, {"reputation", reputationConcentration}} {
+		for _, c := range series.history {
+			records = append(records, []string{
+				strconv.Itoa(c.round),
+				series.name,
+				strconv.FormatFloat(c.gini, 'f', 6, 64),
+				strconv.FormatFloat(c.herfindahl, 'f', 6, 64),
+				strconv.Itoa(c.nakamotoThird),
+				strconv.Itoa(c.nakamotoHalf),
+				strconv.FormatFloat(c.maliciousShare, 'f', 6, 64),
+			})
+		}
+	}
+	return records
+}
+
+// exportConcentration writes the per round concentration series to concentration.csv in the export directory
+func exportConcentration() {
+	if exportDir == "" {
+		return
+	}
+	if err := os.MkdirAll(exportDir, 0755); err != nil {
+		fmt.Println("Could not export concentration:", err)
+		return
+	}
+	file, err := os.Create(filepath.Join(exportDir, "concentration.csv"))
+	if err != nil {
+		fmt.Println("Could not export concentration:", err)
+		return
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	if err := writer.WriteAll(concentrationRecords()); err != nil {
+		fmt.Println("Could not export concentration:", err)
+	}
+}
+
+func printConcentration(name string, history []concentration) {
+	if len(history) == 0 {
+		return
+	}
+	first := history[0]
+	current := history[len(history)-1]
+	fmt.Printf("%s Gini: %f (first round: %f), Herfindahl: %f (first round: %f)\n", name, current.gini, first.gini, current.herfindahl, first.herfindahl)
+	fmt.Printf("%s Nakamoto coefficient 1/3: %d (first round: %d), 1/2: %d (first round: %d)\n", name, current.nakamotoThird, first.nakamotoThird, current.nakamotoHalf, first.nakamotoHalf)
+	fmt.Printf("%s malicious share: %f (first round: %f)\n", name, current.maliciousShare, first.maliciousShare)
+}
+
+func printConcentrationEvaluation() {
+	printConcentration("Stake", stakeConcentration)
+	printConcentration("Reputation", reputationConcentration)
+}
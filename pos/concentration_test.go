@@ -0,0 +1,48 @@
+package pos
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMeasureConcentration(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		values    []float64
+		malicious []bool
+		want      concentration
+	}{
+		{"equal", []float64{1, 1, 1, 1}, []bool{true, false, false, false},
+			concentration{gini: 0, herfindahl: 0.25, nakamotoThird: 2, nakamotoHalf: 3, maliciousShare: 0.25}},
+		{"one holder", []float64{0, 0, 0, 8}, []bool{false, false, false, true},
+			concentration{gini: 0.75, herfindahl: 1, nakamotoThird: 1, nakamotoHalf: 1, maliciousShare: 1}},
+		{"skewed", []float64{1, 2, 3, 4}, []bool{false, false, true, true},
+			concentration{gini: 0.25, herfindahl: 0.3, nakamotoThird: 1, nakamotoHalf: 2, maliciousShare: 0.7}},
+		{"empty", []float64{}, []bool{}, concentration{}},
+	} {
+		got := measureConcentration(test.values, test.malicious)
+		if math.Abs(got.gini-test.want.gini) > 1e-9 || math.Abs(got.herfindahl-test.want.herfindahl) > 1e-9 ||
+			math.Abs(got.maliciousShare-test.want.maliciousShare) > 1e-9 ||
+			got.nakamotoThird != test.want.nakamotoThird || got.nakamotoHalf != test.want.nakamotoHalf {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestConcentrationRecords(t *testing.T) {
+	previousStake, previousReputation := stakeConcentration, reputationConcentration
+	t.Cleanup(func() { stakeConcentration, reputationConcentration = previousStake, previousReputation })
+	stakeConcentration = []concentration{{round: 1, gini: 0.5, nakamotoHalf: 2}, {round: 2, gini: 0.25}}
+	reputationConcentration = []concentration{{round: 1, herfindahl: 0.125}}
+
+	records := concentrationRecords()
+	if len(records) != 4 {
+		t.Fatalf("got %d rows, want a header and 3 rounds", len(records))
+	}
+	if records[1][0] != "1" || records[1][1] != "stake" || records[1][2] != "0.500000" || records[1][5] != "2" {
+		t.Fatalf("got stake row %v", records[1])
+	}
+	if records[3][1] != "reputation" || records[3][3] != "0.125000" {
+		t.Fatalf("got reputation row %v", records[3])
+	}
+}
@@ -0,0 +1,44 @@
+package pos
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// envInt reads an integer setting from the environment, falling back to def
+func envInt(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		fmt.Printf("%s=%s is not an integer, using %d\n", name, value, def)
+		return def
+	}
+	return parsed
+}
+
+// envFloat reads a float setting from the environment, falling back to def
+func envFloat(name string, def float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		fmt.Printf("%s=%s is not a number, using %f\n", name, value, def)
+		return def
+	}
+	return parsed
+}
+
+// envString reads a string setting from the environment, falling back to def
+func envString(name string, def string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	return value
+}
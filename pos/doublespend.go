@@ -0,0 +1,237 @@
+package pos
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Confirmation depths the merchant may wait for before accepting a payment
+var doubleSpendConfirmations = []int{1, 2, 3, 6}
+
+// Number of colluding users that attempt double spends
+var doubleSpendUsers = 1
+
+// Transactions a colluding user sends between two double spend attempts
+var doubleSpendInterval = 5
+
+// Fraction of the attacker's balance spent twice
+var doubleSpendFraction = 0.8
+
+// Consensus checkpoints after which an attempt with neither side confirmed is dropped
+var doubleSpendTimeout = 4
+
+type doubleSpendAttempt struct {
+	merchantTransaction Transaction
+	conflictTransaction Transaction
+	accepted            map[int]bool
+	checkpoints         int
+}
+
+var doubleSpendAttempts = make([]*doubleSpendAttempt, 0)
+
+var doubleSpendLock = &sync.Mutex{}
+
+var doubleSpendStarted = 0
+var doubleSpendSettled = 0
+var doubleSpendExpired = 0
+var doubleSpendBothConfirmed = 0
+var doubleSpendMerchantAccepted = make(map[int]int)
+var doubleSpendSuccesses = make(map[int]int)
+
+func loadDoubleSpendConfig() {
+	doubleSpendUsers = envInt("DOUBLE_SPEND_USERS", doubleSpendUsers)
+	doubleSpendInterval = envInt("DOUBLE_SPEND_INTERVAL", doubleSpendInterval)
+	doubleSpendFraction = envFloat("DOUBLE_SPEND_FRACTION", doubleSpendFraction)
+	doubleSpendTimeout = envInt("DOUBLE_SPEND_TIMEOUT", doubleSpendTimeout)
+	confirmations := envString("DOUBLE_SPEND_CONFIRMATIONS", "")
+	if confirmations != "" {
+		parsed := make([]int, 0)
+		for _, field := range strings.Split(confirmations, ",") {
+			k, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil || k <= 0 {
+				fmt.Printf("DOUBLE_SPEND_CONFIRMATIONS entry %s is not a positive integer\n", field)
+				continue
+			}
+			parsed = append(parsed, k)
+		}
+		doubleSpendConfirmations = parsed
+	}
+}
+
+// attemptDoubleSpend pays a merchant in one partition and spends the same funds again in the other
+func attemptDoubleSpend(attacker *User) {
+	usersSliceLock.Lock()
+	candidates := make([]*User, 0)
+	for _, user := range users {
+		if user != attacker {
+			candidates = append(candidates, user)
+		}
+	}
+	usersSliceLock.Unlock()
+	if len(candidates) == 0 {
+		return
+	}
+	merchant := candidates[rand.Intn(len(candidates))]
+	colluder := attacker
+	for _, user := range candidates {
+		if user != merchant {
+			colluder = user
+			break
+		}
+	}
+
+	attacker.userLock.Lock()
+	reward := 1.0
+	amount := attacker.Balance*doubleSpendFraction - reward
+	attacker.userLock.Unlock()
+	if amount <= 0 {
+		return
+	}
+	var coins []coin
+	if ledgerMode == utxoLedgerMode {
+		coins = selectCoins(walletCoins(attacker), amount+reward)
+		if coins == nil {
+			return
+		}
+	}
+
+	transactionIDLock.Lock()
+	merchantID := transactionID
+	conflictID := transactionID + 1
+	transactionID += 2
+	transactionIDLock.Unlock()
+
+	var merchantTransaction, conflictTransaction Transaction
+	if ledgerMode == utxoLedgerMode {
+		//both spends use the same coins, so at most one of them can be valid on any chain
+		merchantTransaction = generateUTXOTransaction(merchantID, attacker, merchant, amount, reward, coins)
+		conflictTransaction = generateUTXOTransaction(conflictID, attacker, colluder, amount, reward, coins)
+	} else {
+		merchantTransaction = generateTransaction(merchantID, attacker, merchant, amount, reward)
+		//both spends share a nonce, so at most one of them can be valid on any chain
+		conflictTransaction = generateTransactionWithNonce(conflictID, attacker, colluder, amount, reward, merchantTransaction.Nonce)
+	}
+
+	doubleSpendLock.Lock()
+	doubleSpendAttempts = append(doubleSpendAttempts, &doubleSpendAttempt{
+		merchantTransaction: merchantTransaction,
+		conflictTransaction: conflictTransaction,
+		accepted:            make(map[int]bool),
+	})
+	doubleSpendStarted++
+	doubleSpendLock.Unlock()
+
+	attemptString := fmt.Sprintf("Double spending: transaction %d to %s, transaction %d to %s\n", merchantID, merchant.Name, conflictID, colluder.Name)
+	io.WriteString(attacker.conn, attemptString)
+
+	broadcastTransaction(merchantTransaction, ForkedBlockchain[0])
+	broadcastTransaction(conflictTransaction, ForkedBlockchain[1])
+}
+
+// broadcastTransaction sends a transaction to a set of validators
+func broadcastTransaction(transaction Transaction, recipients []*Validator) {
+	for _, validator := range recipients {
+		if validator == nil {
+			continue
+		}
+		msg := NewTransactionMessage{
+			transaction: transaction,
+		}
+		sendTransaction(transaction.Sender.Name, validator, msg)
+	}
+}
+
+// merchantView is the honest validator in the merchant's partition whose chain the merchant trusts
+func merchantView() *Validator {
+	for _, validator := range ForkedBlockchain[0] {
+		if validator != nil && !validator.IsMalicious {
+			return validator
+		}
+	}
+	return nil
+}
+
+// confirmations returns how many blocks bury a transaction in a chain, or 0 if it is not there
+func confirmations(chain []Block, id int) int {
+	for i, block := range chain {
+		for _, transaction := range block.Transactions {
+			if transaction.ID == id {
+				return len(chain) - i
+			}
+		}
+	}
+	return 0
+}
+
+// observeDoubleSpends lets the merchant accept payments that reached each confirmation depth
+func observeDoubleSpends() {
+	view := merchantView()
+	if view == nil {
+		return
+	}
+	doubleSpendLock.Lock()
+	defer doubleSpendLock.Unlock()
+	if ledgerMode == utxoLedgerMode {
+		recordConflictingOutputs()
+	}
+	for _, attempt := range doubleSpendAttempts {
+		depth := confirmations(view.Blockchain, attempt.merchantTransaction.ID)
+		for _, k := range doubleSpendConfirmations {
+			if depth >= k && !attempt.accepted[k] {
+				attempt.accepted[k] = true
+				doubleSpendMerchantAccepted[k]++
+			}
+		}
+	}
+}
+
+// settleDoubleSpends checks pending attempts against the certified chain after a consensus checkpoint
+func settleDoubleSpends() {
+	doubleSpendLock.Lock()
+	defer doubleSpendLock.Unlock()
+	pending := make([]*doubleSpendAttempt, 0)
+	for _, attempt := range doubleSpendAttempts {
+		merchantConfirmed := confirmations(CertifiedBlockchain, attempt.merchantTransaction.ID) > 0
+		conflictConfirmed := confirmations(CertifiedBlockchain, attempt.conflictTransaction.ID) > 0
+		attempt.checkpoints++
+		switch {
+		case merchantConfirmed && conflictConfirmed:
+			doubleSpendBothConfirmed++
+			doubleSpendSettled++
+		case merchantConfirmed:
+			doubleSpendSettled++
+		case conflictConfirmed:
+			//merchant was paid on a branch that lost
+			for k := range attempt.accepted {
+				doubleSpendSuccesses[k]++
+			}
+			doubleSpendSettled++
+		case attempt.checkpoints >= doubleSpendTimeout:
+			doubleSpendExpired++
+		default:
+			pending = append(pending, attempt)
+		}
+	}
+	doubleSpendAttempts = pending
+}
+
+func printDoubleSpendEvaluation() {
+	doubleSpendLock.Lock()
+	defer doubleSpendLock.Unlock()
+	fmt.Printf("Double spend attempts: %d, settled: %d, expired: %d, pending: %d\n", doubleSpendStarted, doubleSpendSettled, doubleSpendExpired, len(doubleSpendAttempts))
+	fmt.Printf("Both conflicting transactions confirmed: %d\n", doubleSpendBothConfirmed)
+	if ledgerMode == utxoLedgerMode {
+		fmt.Printf("Outputs spent by conflicting transactions on different forks: %d\n", len(conflictingOutPoints))
+	}
+	for _, k := range doubleSpendConfirmations {
+		rate := 0.0
+		if doubleSpendSettled > 0 {
+			rate = float64(doubleSpendSuccesses[k]) / float64(doubleSpendSettled)
+		}
+		fmt.Printf("k=%d: merchant accepted %d, successful double spends %d (rate %f)\n", k, doubleSpendMerchantAccepted[k], doubleSpendSuccesses[k], rate)
+	}
+}
@@ -0,0 +1,105 @@
+package pos
+
+import "testing"
+
+// resetDoubleSpendForTest clears the attempts and their counters and restores them when the test ends
+func resetDoubleSpendForTest(t *testing.T) {
+	t.Helper()
+	previousAttempts, previousConfirmations, previousTimeout := doubleSpendAttempts, doubleSpendConfirmations, doubleSpendTimeout
+	previousForked, previousCertified := ForkedBlockchain, CertifiedBlockchain
+	previousAccepted, previousSuccesses := doubleSpendMerchantAccepted, doubleSpendSuccesses
+	previousCounts := []int{doubleSpendStarted, doubleSpendSettled, doubleSpendExpired, doubleSpendBothConfirmed}
+	t.Cleanup(func() {
+		doubleSpendAttempts, doubleSpendConfirmations, doubleSpendTimeout = previousAttempts, previousConfirmations, previousTimeout
+		ForkedBlockchain, CertifiedBlockchain = previousForked, previousCertified
+		doubleSpendMerchantAccepted, doubleSpendSuccesses = previousAccepted, previousSuccesses
+		doubleSpendStarted, doubleSpendSettled = previousCounts[0], previousCounts[1]
+		doubleSpendExpired, doubleSpendBothConfirmed = previousCounts[2], previousCounts[3]
+	})
+	doubleSpendAttempts = make([]*doubleSpendAttempt, 0)
+	doubleSpendMerchantAccepted, doubleSpendSuccesses = make(map[int]int), make(map[int]int)
+	doubleSpendStarted, doubleSpendSettled, doubleSpendExpired, doubleSpendBothConfirmed = 0, 0, 0, 0
+}
+
+// newDoubleSpendAttempt records an attempt spending the same funds in transactions merchant and conflict
+func newDoubleSpendAttempt(merchant int, conflict int) *doubleSpendAttempt {
+	attempt := &doubleSpendAttempt{
+		merchantTransaction: Transaction{ID: merchant},
+		conflictTransaction: Transaction{ID: conflict},
+		accepted:            make(map[int]bool),
+	}
+	doubleSpendAttempts = append(doubleSpendAttempts, attempt)
+	doubleSpendStarted++
+	return attempt
+}
+
+// chainWithTransaction builds a chain of length blocks with the transaction in the block at index at
+func chainWithTransaction(length int, at int, id int) []Block {
+	chain := make([]Block, length)
+	for i := range chain {
+		chain[i] = Block{Index: i}
+	}
+	chain[at].Transactions = []Transaction{{ID: id}}
+	return chain
+}
+
+func TestConfirmations(t *testing.T) {
+	chain := chainWithTransaction(5, 2, 7)
+	if depth := confirmations(chain, 7); depth != 3 {
+		t.Fatalf("got %d confirmations, want 3", depth)
+	}
+	if depth := confirmations(chain[:3], 7); depth != 1 {
+		t.Fatalf("got %d confirmations in the head block, want 1", depth)
+	}
+	if depth := confirmations(chain, 8); depth != 0 {
+		t.Fatalf("got %d confirmations for a missing transaction, want 0", depth)
+	}
+}
+
+func TestObserveDoubleSpendsAcceptsAtEachDepth(t *testing.T) {
+	resetDoubleSpendForTest(t)
+	doubleSpendConfirmations = []int{1, 2, 6}
+	malicious := &Validator{IsMalicious: true, Blockchain: chainWithTransaction(10, 1, 1)}
+	merchant := &Validator{Blockchain: chainWithTransaction(4, 2, 1)}
+	//the merchant trusts the first honest validator of its partition
+	ForkedBlockchain = [][]*Validator{{nil, malicious, merchant}, {}}
+	attempt := newDoubleSpendAttempt(1, 2)
+
+	observeDoubleSpends()
+	observeDoubleSpends()
+	if !attempt.accepted[1] || !attempt.accepted[2] || attempt.accepted[6] {
+		t.Fatalf("got accepted depths %v, want 1 and 2", attempt.accepted)
+	}
+	if doubleSpendMerchantAccepted[1] != 1 || doubleSpendMerchantAccepted[2] != 1 || doubleSpendMerchantAccepted[6] != 0 {
+		t.Fatalf("got merchant acceptances %v, each depth counted once", doubleSpendMerchantAccepted)
+	}
+}
+
+func TestSettleDoubleSpends(t *testing.T) {
+	resetDoubleSpendForTest(t)
+	doubleSpendTimeout = 2
+	paid := newDoubleSpendAttempt(1, 2)
+	paid.accepted[1] = true
+	doubleSpent := newDoubleSpendAttempt(3, 4)
+	doubleSpent.accepted[1], doubleSpent.accepted[3] = true, true
+	newDoubleSpendAttempt(5, 6)
+	pending := newDoubleSpendAttempt(7, 8)
+	pending.checkpoints = -5
+
+	CertifiedBlockchain = []Block{{Index: 0}, {Index: 1, Transactions: []Transaction{{ID: 1}, {ID: 4}}}}
+	settleDoubleSpends()
+	if doubleSpendSettled != 2 || doubleSpendBothConfirmed != 0 {
+		t.Fatalf("got %d settled, want 2", doubleSpendSettled)
+	}
+	if doubleSpendSuccesses[1] != 1 || doubleSpendSuccesses[3] != 1 {
+		t.Fatalf("got successes %v, want the merchant's accepted depths of the losing payment", doubleSpendSuccesses)
+	}
+	if len(doubleSpendAttempts) != 2 {
+		t.Fatalf("%d attempts pending after the first checkpoint, want 2", len(doubleSpendAttempts))
+	}
+
+	settleDoubleSpends()
+	if doubleSpendExpired != 1 || len(doubleSpendAttempts) != 1 || doubleSpendAttempts[0] != pending {
+		t.Fatalf("got %d expired, want the attempt that reached the timeout", doubleSpendExpired)
+	}
+}
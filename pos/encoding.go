@@ -0,0 +1,392 @@
+package pos
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Canonical binary encoding of transactions and blocks used for hashing, signing and storage.
+// Integers are big endian, strings are prefixed with their uint32 length and amounts are
+// fixed point with eight decimal places, so the bytes never depend on memory layout.
+
+const transactionEncodingVersion = 1
+
+const blockEncodingVersion = 1
+
+// Number of fixed point units in one token
+const amountScale = 100000000
+
+// Domain tags keep a signed transaction payload from ever being read as a block header
+const (
+	transactionSigningTag = 0x01
+	transactionTag        = 0x02
+	blockHeaderTag        = 0x03
+	blockTag              = 0x04
+	proposerSigningTag    = 0x05
+	commitVoteTag         = 0x06
+	committeeSigningTag   = 0x07
+)
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) writeUint8(v uint8) {
+	e.buf.WriteByte(v)
+}
+
+func (e *encoder) writeUint64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	e.buf.Write(b[:])
+}
+
+func (e *encoder) writeInt64(v int64) {
+	e.writeUint64(uint64(v))
+}
+
+func (e *encoder) writeBool(v bool) {
+	if v {
+		e.writeUint8(1)
+	} else {
+		e.writeUint8(0)
+	}
+}
+
+func (e *encoder) writeString(s string) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(len(s)))
+	e.buf.Write(b[:])
+	e.buf.WriteString(s)
+}
+
+func (e *encoder) writeAmount(amount float64) {
+	e.writeInt64(toFixedPoint(amount))
+}
+
+type decoder struct {
+	data []byte
+	err  error
+}
+
+var errShortEncoding = errors.New("encoding is truncated")
+
+func (d *decoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if len(d.data) < n {
+		d.err = errShortEncoding
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) readUint8() uint8 {
+	b := d.take(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (d *decoder) readUint64() uint64 {
+	b := d.take(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+func (d *decoder) readInt64() int64 {
+	return int64(d.readUint64())
+}
+
+func (d *decoder) readBool() bool {
+	return d.readUint8() == 1
+}
+
+func (d *decoder) readString() string {
+	b := d.take(4)
+	if b == nil {
+		return ""
+	}
+	return string(d.take(int(binary.BigEndian.Uint32(b))))
+}
+
+func (d *decoder) readAmount() float64 {
+	return fromFixedPoint(d.readInt64())
+}
+
+func (d *decoder) expect(tag uint8, version uint8) {
+	gotTag := d.readUint8()
+	gotVersion := d.readUint8()
+	if d.err != nil {
+		return
+	}
+	if gotTag != tag {
+		d.err = fmt.Errorf("unexpected encoding tag %d, want %d", gotTag, tag)
+	} else if gotVersion != version {
+		d.err = fmt.Errorf("unsupported encoding version %d, want %d", gotVersion, version)
+	}
+}
+
+func toFixedPoint(amount float64) int64 {
+	return int64(math.Round(amount * amountScale))
+}
+
+func fromFixedPoint(units int64) float64 {
+	return float64(units) / amountScale
+}
+
+// addressFromPublicKey derives an account address from the encoded public key
+func addressFromPublicKey(publicKey []byte) string {
+	hashed := sha256.Sum256(publicKey)
+	return hex.EncodeToString(hashed[:])
+}
+
+func userAddress(user *User) string {
+	if user == nil {
+		return ""
+	}
+	return user.Address
+}
+
+// transactionSigningData is the transaction content covered by the sender's signature
+// The ID is a local label, so replay protection relies on the sender's nonce instead
+func transactionSigningData(t Transaction) []byte {
+	e := &encoder{}
+	e.writeUint8(transactionSigningTag)
+	e.writeUint8(transactionEncodingVersion)
+	writeTransactionBody(e, t)
+	return e.buf.Bytes()
+}
+
+func writeTransactionBody(e *encoder, t Transaction) {
+	e.writeString(userAddress(t.Sender))
+	e.writeString(userAddress(t.Receiver))
+	e.writeAmount(t.Amount)
+	e.writeAmount(t.Reward)
+	e.writeUint64(uint64(t.Nonce))
+	e.writeUint64(uint64(len(t.Inputs)))
+	for _, input := range t.Inputs {
+		e.writeString(input.Hash)
+		e.writeUint64(uint64(input.Index))
+	}
+	e.writeUint64(uint64(len(t.Outputs)))
+	for _, output := range t.Outputs {
+		e.writeString(output.Address)
+		e.writeAmount(output.Amount)
+	}
+}
+
+func writeTransaction(e *encoder, t Transaction) {
+	e.writeUint8(transactionTag)
+	e.writeUint8(transactionEncodingVersion)
+	e.writeInt64(int64(t.ID))
+	writeTransactionBody(e, t)
+	e.writeString(t.Signature)
+}
+
+// encodeTransaction returns the full transaction encoding, signature included
+func encodeTransaction(t Transaction) []byte {
+	e := &encoder{}
+	writeTransaction(e, t)
+	return e.buf.Bytes()
+}
+
+func readTransaction(d *decoder) Transaction {
+	d.expect(transactionTag, transactionEncodingVersion)
+	t := Transaction{}
+	t.ID = int(d.readInt64())
+	senderAddress := d.readString()
+	receiverAddress := d.readString()
+	t.Amount = d.readAmount()
+	t.Reward = d.readAmount()
+	t.Nonce = int(d.readUint64())
+	inputs := d.readUint64()
+	if d.err == nil && inputs > uint64(len(d.data)) {
+		d.err = errShortEncoding
+	}
+	for i := uint64(0); i < inputs && d.err == nil; i++ {
+		input := OutPoint{}
+		input.Hash = d.readString()
+		input.Index = int(d.readUint64())
+		t.Inputs = append(t.Inputs, input)
+	}
+	outputs := d.readUint64()
+	if d.err == nil && outputs > uint64(len(d.data)) {
+		d.err = errShortEncoding
+	}
+	for i := uint64(0); i < outputs && d.err == nil; i++ {
+		output := TxOutput{}
+		output.Address = d.readString()
+		output.Amount = d.readAmount()
+		t.Outputs = append(t.Outputs, output)
+	}
+	t.Signature = d.readString()
+	if d.err != nil {
+		return t
+	}
+	t.Sender = userByAddress(senderAddress)
+	t.Receiver = userByAddress(receiverAddress)
+	if t.Sender == nil || t.Receiver == nil {
+		d.err = fmt.Errorf("transaction %d references an unknown user", t.ID)
+	}
+	return t
+}
+
+// decodeTransaction parses a full transaction encoding, resolving addresses to known users
+func decodeTransaction(data []byte) (Transaction, error) {
+	d := &decoder{data: data}
+	t := readTransaction(d)
+	if d.err == nil && len(d.data) > 0 {
+		d.err = errors.New("trailing bytes after transaction")
+	}
+	return t, d.err
+}
+
+func writeBlockHeader(e *encoder, h BlockHeader) {
+	e.writeUint8(blockHeaderTag)
+	e.writeUint8(blockEncodingVersion)
+	e.writeUint64(uint64(h.Index))
+	e.writeString(h.Timestamp)
+	e.writeString(h.PrevHash)
+	e.writeString(h.Validator)
+	e.writeString(h.StateRoot)
+	e.writeString(h.TxRoot)
+	e.writeAmount(h.BaseFee)
+}
+
+// headerHashData is the header content covered by the block hash
+func headerHashData(h BlockHeader) []byte {
+	e := &encoder{}
+	writeBlockHeader(e, h)
+	return e.buf.Bytes()
+}
+
+// proposerSigningData is what the proposer signs, binding it to the block hash
+func proposerSigningData(hash string) []byte {
+	e := &encoder{}
+	e.writeUint8(proposerSigningTag)
+	e.writeUint8(blockEncodingVersion)
+	e.writeString(hash)
+	return e.buf.Bytes()
+}
+
+// commitVoteData is what a committee member signs when voting for a block
+func commitVoteData(hash string) []byte {
+	e := &encoder{}
+	e.writeUint8(commitVoteTag)
+	e.writeUint8(blockEncodingVersion)
+	e.writeString(hash)
+	return e.buf.Bytes()
+}
+
+// committeeSigningData is what the server signs to bind a committee to the block it voted on
+func committeeSigningData(hash string, committee []string) []byte {
+	e := &encoder{}
+	e.writeUint8(committeeSigningTag)
+	e.writeUint8(blockEncodingVersion)
+	e.writeString(hash)
+	e.writeUint64(uint64(len(committee)))
+	for _, member := range committee {
+		e.writeString(member)
+	}
+	return e.buf.Bytes()
+}
+
+// encodeBlock returns the storage encoding of a block, including its hash and simulation flags
+func encodeBlock(b Block) []byte {
+	e := &encoder{}
+	e.writeUint8(blockTag)
+	e.writeUint8(blockEncodingVersion)
+	writeBlockHeader(e, b.header())
+	e.writeUint64(uint64(len(b.Transactions)))
+	for _, transaction := range b.Transactions {
+		writeTransaction(e, transaction)
+	}
+	e.writeString(b.Hash)
+	e.writeString(b.ProposerSignature)
+	e.writeUint64(uint64(len(b.Certificate.Committee)))
+	for _, member := range b.Certificate.Committee {
+		e.writeString(member)
+	}
+	e.writeString(b.Certificate.CommitteeSignature)
+	e.writeUint64(uint64(len(b.Certificate.Votes)))
+	for _, vote := range b.Certificate.Votes {
+		e.writeString(vote.Validator)
+		e.writeString(vote.Signature)
+	}
+	e.writeBool(b.IsMalicious)
+	return e.buf.Bytes()
+}
+
+// decodeBlock parses a block stored with encodeBlock
+func decodeBlock(data []byte) (Block, error) {
+	d := &decoder{data: data}
+	d.expect(blockTag, blockEncodingVersion)
+	d.expect(blockHeaderTag, blockEncodingVersion)
+	b := Block{}
+	b.Index = int(d.readUint64())
+	b.Timestamp = d.readString()
+	b.PrevHash = d.readString()
+	b.Validator = d.readString()
+	b.StateRoot = d.readString()
+	b.TxRoot = d.readString()
+	b.BaseFee = d.readAmount()
+	count := d.readUint64()
+	if d.err == nil && count > uint64(len(d.data)) {
+		d.err = errShortEncoding
+	}
+	b.Transactions = make([]Transaction, 0)
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		b.Transactions = append(b.Transactions, readTransaction(d))
+	}
+	b.Hash = d.readString()
+	b.ProposerSignature = d.readString()
+	members := d.readUint64()
+	if d.err == nil && members > uint64(len(d.data)) {
+		d.err = errShortEncoding
+	}
+	b.Certificate.Committee = make([]string, 0)
+	for i := uint64(0); i < members && d.err == nil; i++ {
+		b.Certificate.Committee = append(b.Certificate.Committee, d.readString())
+	}
+	b.Certificate.CommitteeSignature = d.readString()
+	votes := d.readUint64()
+	if d.err == nil && votes > uint64(len(d.data)) {
+		d.err = errShortEncoding
+	}
+	b.Certificate.Votes = make([]CommitVote, 0)
+	for i := uint64(0); i < votes && d.err == nil; i++ {
+		vote := CommitVote{}
+		vote.Validator = d.readString()
+		vote.Signature = d.readString()
+		b.Certificate.Votes = append(b.Certificate.Votes, vote)
+	}
+	b.IsMalicious = d.readBool()
+	if d.err == nil && len(d.data) > 0 {
+		d.err = errors.New("trailing bytes after block")
+	}
+	return b, d.err
+}
+
+// userByAddress finds a connected user from an encoded address
+func userByAddress(address string) *User {
+	usersSliceLock.Lock()
+	defer usersSliceLock.Unlock()
+	for _, user := range users {
+		if user.Address == address {
+			return user
+		}
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package pos
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+)
+
+// Golden vectors for the canonical encoding.
+// Changing the encoding means bumping its version and regenerating these values.
+
+type encodingVector struct {
+	name     string
+	encoded  []byte
+	expected string
+}
+
+func goldenTransaction() Transaction {
+	alice := &User{Name: "alice", Address: addressFromPublicKey([]byte("alice"))}
+	bob := &User{Name: "bob", Address: addressFromPublicKey([]byte("bob"))}
+	return Transaction{
+		ID:        7,
+		Sender:    alice,
+		Receiver:  bob,
+		Signature: "0a0b0c",
+		Amount:    12.5,
+		Reward:    0.25,
+		Nonce:     3,
+	}
+}
+
+func goldenUTXOTransaction() Transaction {
+	transaction := goldenTransaction()
+	transaction.Inputs = []OutPoint{genesisOutPoint(transaction.Sender.Address)}
+	transaction.Outputs = []TxOutput{{Address: transaction.Receiver.Address, Amount: 12.5}, {Address: transaction.Sender.Address, Amount: 87.25}}
+	return transaction
+}
+
+func goldenBlock() Block {
+	block := Block{
+		Index:        1,
+		Timestamp:    "2023-05-01 12:00:00 +0000 UTC",
+		Transactions: []Transaction{goldenTransaction()},
+		PrevHash:     "00",
+		Validator:    addressFromPublicKey([]byte("validator")),
+		StateRoot:    merkleRoot([][]byte{[]byte("account")}),
+		IsMalicious:  false,
+	}
+	block.TxRoot = transactionRoot(block.Transactions)
+	block.Hash = calculateBlockHash(block)
+
+	//ed25519 signatures are deterministic, so a fixed seed gives fixed bytes
+	seed := sha256.Sum256([]byte("validator"))
+	key := ed25519.NewKeyFromSeed(seed[:])
+	block.ProposerSignature = hex.EncodeToString(ed25519.Sign(key, proposerSigningData(block.Hash)))
+	block.Certificate = CommitCertificate{
+		Committee:          []string{block.Validator},
+		CommitteeSignature: hex.EncodeToString(ed25519.Sign(key, committeeSigningData(block.Hash, []string{block.Validator}))),
+		Votes:              []CommitVote{{Validator: block.Validator, Signature: hex.EncodeToString(ed25519.Sign(key, commitVoteData(block.Hash)))}},
+	}
+	return block
+}
+
+func encodingVectors() []encodingVector {
+	transaction := goldenTransaction()
+	block := goldenBlock()
+	blockHash, _ := hex.DecodeString(block.Hash)
+	txRoot, _ := hex.DecodeString(block.TxRoot)
+	proofLeaves := [][]byte{[]byte("a"), []byte("b"), []byte("c")}
+	proof := merkleProofFor(proofLeaves, 2)
+	proofData := make([]byte, 0)
+	for _, step := range proof.Steps {
+		sibling, _ := hex.DecodeString(step.Sibling)
+		proofData = append(proofData, sibling...)
+	}
+	return []encodingVector{
+		{"transaction signing data", transactionSigningData(transaction),
+			"010100000040326264383036633937663065303061663161316663333332386661373633613932363937323363386462386661633466393361663731646231383664366539300000004038316236333764386663643263366461363335396536393633313133613131373064653739356534623732356238346431653062346366643965633538636539000000004a817c8000000000017d7840000000000000000300000000000000000000000000000000"},
+		{"transaction", encodeTransaction(transaction),
+			"0201000000000000000700000040326264383036633937663065303061663161316663333332386661373633613932363937323363386462386661633466393361663731646231383664366539300000004038316236333764386663643263366461363335396536393633313133613131373064653739356534623732356238346431653062346366643965633538636539000000004a817c8000000000017d784000000000000000030000000000000000000000000000000000000006306130623063"},
+		{"utxo transaction", encodeTransaction(goldenUTXOTransaction()),
+			"0201000000000000000700000040326264383036633937663065303061663161316663333332386661373633613932363937323363386462386661633466393361663731646231383664366539300000004038316236333764386663643263366461363335396536393633313133613131373064653739356534623732356238346431653062346366643965633538636539000000004a817c8000000000017d7840000000000000000300000000000000010000004066383839633938323563353539616534396531376435616531613163626631323939633637383236636661373736636365653864353437346665393535623439000000000000000000000000000000020000004038316236333764386663643263366461363335396536393633313133613131373064653739356534623732356238346431653062346366643965633538636539000000004a817c80000000403262643830366339376630653030616631613166633333323866613736336139323639373233633864623866616334663933616637316462313836643665393000000002080cef4000000006306130623063"},
+		{"block hash", blockHash,
+			"755073b7559d5241e23cb97414f433f0a88e6fb5df08c361a669882c31d1b601"},
+		{"transaction root", txRoot,
+			"55c1b3f05917988a0027abf1c9a412f30b9e13d1d9c2b5e18836ddddad321c2a"},
+		{"merkle proof", proofData,
+			"b137985ff484fb600db93107c77b0365c80d78f5b429ded0fd97361d077999eb"},
+		{"block", encodeBlock(block),
+			"0401030100000000000000010000001d323032332d30352d30312031323a30303a3030202b3030303020555443000000023030000000406638326166333231363062633533313132636131313861626266353766613666656434376562393032393161316431643932663433386165326564373465663600000040623831363230323062336235656165316434663831373231383365363565353333353866623265343332306464373661653461656162316538373466656234320000004035356331623366303539313739383861303032376162663163396134313266333062396531336431643963326235653138383336646464646164333231633261000000000000000000000000000000010201000000000000000700000040326264383036633937663065303061663161316663333332386661373633613932363937323363386462386661633466393361663731646231383664366539300000004038316236333764386663643263366461363335396536393633313133613131373064653739356534623732356238346431653062346366643965633538636539000000004a817c8000000000017d7840000000000000000300000000000000000000000000000000000000063061306230630000004037353530373362373535396435323431653233636239373431346634333366306138386536666235646630386333363161363639383832633331643162363031000000806530643238613236303061626232303533316231333238376664396336303037366238643130613363373563303432643261356138613563636266393661633065303737323835316538353635376537323235656534356164653265653162316264313333613536666435653736366139366435636331343133623533323039000000000000000100000040663832616633323136306263353331313263613131386162626635376661366665643437656239303239316131643164393266343338616532656437346566360000008064366439306630616432323130326364333439303664633863306138303837373265666332643131313530366463346536363532616564633835316633393738306666313437623839383763653638333333376633633265643138666665623961653731353533383337346436346664653631616531383561626439336130610000000000000001000000406638326166333231363062633533313132636131313861626266353766613666656434376562393032393161316431643932663433386165326564373465663600000080396531303235336234656362303933333235323336613730663932316437383034646433636434346366643538333336366164626439613761626663646537343662326264393666373334323463623631613064393736393761643231336636393537326466646136306530633463383230343537316137333937393865303600"},
+	}
+}
+
+func TestEncodingVectors(t *testing.T) {
+	for _, vector := range encodingVectors() {
+		t.Run(vector.name, func(t *testing.T) {
+			expected, err := hex.DecodeString(vector.expected)
+			if err != nil {
+				t.Fatalf("golden vector is not hex: %v", err)
+			}
+			if !bytes.Equal(vector.encoded, expected) {
+				t.Fatalf("got %x", vector.encoded)
+			}
+		})
+	}
+}
+
+func TestGoldenBlockInclusionProof(t *testing.T) {
+	block := goldenBlock()
+	transaction, proof, err := transactionInclusionProof(block, goldenTransaction().ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verifyTransactionInclusion(block.header(), transaction, proof) {
+		t.Fatal("golden block inclusion proof does not verify")
+	}
+}
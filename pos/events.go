@@ -0,0 +1,296 @@
+package pos
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Everything the server decides in a slot is published on an event bus as a structured event.
+// Subscribers get their own buffered channel; a subscriber that does not keep up misses events
+// rather than holding up the simulation. The HTTP API streams the bus as Server-Sent Events.
+
+// EventType names what happened
+type EventType string
+
+const (
+	SlotStarted     EventType = "slot_started"
+	CommitteeChosen EventType = "committee_chosen"
+	ProposerChosen  EventType = "proposer_chosen"
+	BlockProposed   EventType = "block_proposed"
+	VoteCast        EventType = "vote_cast"
+	BlockAccepted   EventType = "block_accepted"
+	BlockRejected   EventType = "block_rejected"
+	Slash           EventType = "slash"
+	ForkDetected    EventType = "fork_detected"
+	ConsensusReorg  EventType = "consensus_reorg"
+)
+
+// Event is published on the bus, only the fields that apply to its type are set
+type Event struct {
+	Seq  int       `json:"seq"`
+	Type EventType `json:"type"`
+	//round the event happened in and simulated seconds since the start
+	Round int     `json:"round"`
+	Time  float64 `json:"time"`
+	//validator the event is about, e.g. the proposer, voter or slashed validator
+	Validator   string   `json:"validator,omitempty"`
+	Members     []string `json:"members,omitempty"`
+	Block       string   `json:"block,omitempty"`
+	Index       int      `json:"index,omitempty"`
+	Conflicting string   `json:"conflicting,omitempty"`
+	Valid       *bool    `json:"valid,omitempty"`
+	Votes       int      `json:"votes,omitempty"`
+	Committee   int      `json:"committee,omitempty"`
+	Orphaned    int      `json:"orphaned,omitempty"`
+	Reason      string   `json:"reason,omitempty"`
+}
+
+// Events buffered for each subscriber before it misses events
+var eventBuffer = 256
+
+// Recent events kept so a reconnecting subscriber can pick up where it left off
+var eventHistory = 1000
+
+// subscription receives the events of the given types, or all events when types is empty
+type subscription struct {
+	events chan Event
+	types  map[EventType]bool
+}
+
+type eventBus struct {
+	lock        sync.Mutex
+	nextSeq     int
+	subscribers map[*subscription]bool
+	recent      []Event
+	//events published by type, slashes by reason and blocks dropped in reorgs
+	counts     map[EventType]int
+	slashes    map[string]int
+	orphaned   int
+	published  int
+	subscribed int
+	dropped    int
+}
+
+var events = &eventBus{
+	subscribers: make(map[*subscription]bool),
+	counts:      make(map[EventType]int),
+	slashes:     make(map[string]int),
+}
+
+func loadEventConfig() {
+	eventBuffer = envInt("EVENT_BUFFER", eventBuffer)
+	eventHistory = envInt("EVENT_HISTORY", eventHistory)
+}
+
+// subscribe returns a subscription to the given event types, all of them when none are given
+func (bus *eventBus) subscribe(types ...EventType) *subscription {
+	sub := &subscription{events: make(chan Event, eventBuffer), types: make(map[EventType]bool)}
+	for _, eventType := range types {
+		sub.types[eventType] = true
+	}
+	bus.lock.Lock()
+	bus.subscribers[sub] = true
+	bus.subscribed++
+	bus.lock.Unlock()
+	return sub
+}
+
+func (bus *eventBus) unsubscribe(sub *subscription) {
+	bus.lock.Lock()
+	delete(bus.subscribers, sub)
+	bus.lock.Unlock()
+}
+
+func (sub *subscription) wants(event Event) bool {
+	return len(sub.types) == 0 || sub.types[event.Type]
+}
+
+// since returns the kept events after seq that the subscription wants
+func (bus *eventBus) since(seq int, sub *subscription) []Event {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	missed := make([]Event, 0)
+	for _, event := range bus.recent {
+		if event.Seq > seq && sub.wants(event) {
+			missed = append(missed, event)
+		}
+	}
+	return missed
+}
+
+// publish stamps an event with its sequence number, round and time and hands it to every subscriber
+func (bus *eventBus) publish(event Event) {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	bus.nextSeq++
+	event.Seq = bus.nextSeq
+	event.Round = roundCount
+	event.Time = clock.now().Seconds()
+	bus.published++
+	bus.counts[event.Type]++
+	if event.Type == Slash {
+		bus.slashes[event.Reason]++
+	}
+	bus.orphaned += event.Orphaned
+	if eventHistory > 0 {
+		if len(bus.recent) >= eventHistory {
+			bus.recent = bus.recent[1:]
+		}
+		bus.recent = append(bus.recent, event)
+	}
+	for sub := range bus.subscribers {
+		if !sub.wants(event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			bus.dropped++
+		}
+	}
+}
+
+func publishEvent(event Event) {
+	events.publish(event)
+}
+
+func publishCommittee(members []*Validator, reason string) {
+	publishEvent(Event{Type: CommitteeChosen, Members: validatorNames(members), Reason: reason})
+}
+
+func publishProposal(proposer *Validator, block Block, conflicting Block) {
+	publishEvent(Event{Type: BlockProposed, Validator: validatorName(proposer), Block: block.Hash, Index: block.Index, Conflicting: conflicting.Hash})
+}
+
+// publishVote publishes a committee member's vote on the block, and on the conflicting block of a
+// partition attack when it voted on both
+func publishVote(validator *Validator, msg interface{}, block Block, blockTwo Block) {
+	vote := func(block Block, isValid bool) {
+		publishEvent(Event{Type: VoteCast, Validator: validatorName(validator), Block: block.Hash, Index: block.Index, Valid: &isValid})
+	}
+	switch msg := msg.(type) {
+	case ValidationStatusMessage:
+		vote(block, msg.isValid)
+	case ValidationShortAttackStatusMessage:
+		vote(block, msg.isValid)
+		vote(blockTwo, msg.isValidTwo)
+	}
+}
+
+// publishDecision publishes whether the committee accepted or rejected a block
+func publishDecision(accepted bool, proposer *Validator, block Block, votes int, committee []*Validator) {
+	eventType := BlockRejected
+	if accepted {
+		eventType = BlockAccepted
+	}
+	publishEvent(Event{Type: eventType, Validator: validatorName(proposer), Block: block.Hash, Index: block.Index, Votes: votes, Committee: len(committee)})
+}
+
+func publishSlash(validator *Validator, reason string) {
+	if validator == nil {
+		return
+	}
+	publishEvent(Event{Type: Slash, Validator: validatorName(validator), Reason: reason})
+}
+
+func publishFork(proposer *Validator, block Block, conflicting Block) {
+	publishEvent(Event{Type: ForkDetected, Validator: validatorName(proposer), Block: block.Hash, Index: block.Index, Conflicting: conflicting.Hash})
+}
+
+// publishReorg publishes a validator switching to the consensus chain, if it dropped any blocks
+func publishReorg(validator *Validator, orphaned int) {
+	if orphaned == 0 {
+		return
+	}
+	head := validator.Blockchain[len(validator.Blockchain)-1]
+	publishEvent(Event{Type: ConsensusReorg, Validator: validatorName(validator), Block: head.Hash, Index: head.Index, Orphaned: orphaned})
+}
+
+// serveEvents streams events as Server-Sent Events, GET /events?types=<type>,<type>
+// A client reconnecting with Last-Event-ID first gets the kept events it missed
+func serveEvents(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	types := make([]EventType, 0)
+	for _, field := range strings.Split(r.URL.Query().Get("types"), ",") {
+		if strings.TrimSpace(field) != "" {
+			types = append(types, EventType(strings.TrimSpace(field)))
+		}
+	}
+	sub := events.subscribe(types...)
+	defer events.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	write := func(event Event) error {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.Seq, event.Type, data)
+		return err
+	}
+	last := 0
+	if id, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil {
+		for _, event := range events.since(id, sub) {
+			if write(event) != nil {
+				return
+			}
+			last = event.Seq
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-sub.events:
+			//already sent from the kept events
+			if event.Seq <= last {
+				continue
+			}
+			if write(event) != nil {
+				return
+			}
+			flusher.Flush()
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func printEventEvaluation() {
+	events.lock.Lock()
+	defer events.lock.Unlock()
+	if events.subscribed == 0 {
+		return
+	}
+	fmt.Printf("Events published: %d, subscribers: %d, events missed by slow subscribers: %d\n", events.published, len(events.subscribers), events.dropped)
+}
+
+// count returns how many events of a type were published
+func (bus *eventBus) count(eventType EventType) int {
+	bus.lock.Lock()
+	defer bus.lock.Unlock()
+	return bus.counts[eventType]
+}
@@ -0,0 +1,74 @@
+package pos
+
+import "testing"
+
+// newTestBus returns an empty bus buffering buffer events per subscriber and keeping history of them
+func newTestBus(t *testing.T, buffer int, history int) *eventBus {
+	t.Helper()
+	previousBuffer, previousHistory := eventBuffer, eventHistory
+	t.Cleanup(func() { eventBuffer, eventHistory = previousBuffer, previousHistory })
+	eventBuffer, eventHistory = buffer, history
+	return &eventBus{
+		subscribers: make(map[*subscription]bool),
+		counts:      make(map[EventType]int),
+		slashes:     make(map[string]int),
+	}
+}
+
+func TestSubscribeFiltersEventTypes(t *testing.T) {
+	bus := newTestBus(t, 8, 8)
+	all := bus.subscribe()
+	slashes := bus.subscribe(Slash)
+	bus.publish(Event{Type: SlotStarted})
+	bus.publish(Event{Type: Slash, Reason: "voted against the majority"})
+
+	if len(all.events) != 2 || len(slashes.events) != 1 {
+		t.Fatalf("got %d and %d events, want 2 for all types and 1 slash", len(all.events), len(slashes.events))
+	}
+	if event := <-slashes.events; event.Type != Slash || event.Seq != 2 {
+		t.Fatalf("got %+v, want the slash with sequence number 2", event)
+	}
+	if bus.count(Slash) != 1 || bus.slashes["voted against the majority"] != 1 {
+		t.Fatal("slash was not counted by reason")
+	}
+
+	bus.unsubscribe(slashes)
+	bus.publish(Event{Type: Slash})
+	if len(slashes.events) != 0 {
+		t.Fatal("event delivered after unsubscribing")
+	}
+}
+
+func TestSlowSubscriberMissesEvents(t *testing.T) {
+	bus := newTestBus(t, 2, 8)
+	slow := bus.subscribe()
+	fast := bus.subscribe()
+	for i := 0; i < 3; i++ {
+		bus.publish(Event{Type: SlotStarted})
+		<-fast.events
+	}
+	//publishing never blocks on a full subscriber, the event is dropped for it
+	if len(slow.events) != 2 || bus.dropped != 1 || bus.published != 3 {
+		t.Fatalf("got %d buffered, %d dropped of %d published, want 2, 1 and 3", len(slow.events), bus.dropped, bus.published)
+	}
+}
+
+func TestSinceReplaysKeptEvents(t *testing.T) {
+	bus := newTestBus(t, 8, 3)
+	votes := bus.subscribe(VoteCast)
+	for _, eventType := range []EventType{VoteCast, SlotStarted, VoteCast, VoteCast, SlotStarted} {
+		bus.publish(Event{Type: eventType})
+	}
+
+	//only the last 3 events are kept, and only the wanted types are replayed
+	missed := bus.since(0, votes)
+	if len(missed) != 2 || missed[0].Seq != 3 || missed[1].Seq != 4 {
+		t.Fatalf("got %d replayed events, want the votes with sequence numbers 3 and 4", len(missed))
+	}
+	if missed := bus.since(3, votes); len(missed) != 1 || missed[0].Seq != 4 {
+		t.Fatalf("got %d events after 3, want the vote with sequence number 4", len(missed))
+	}
+	if missed := bus.since(5, bus.subscribe()); len(missed) != 0 {
+		t.Fatalf("got %d events after the last one, want none", len(missed))
+	}
+}
@@ -0,0 +1,270 @@
+package pos
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Directory the block tree is exported to at every evaluation, nothing is exported when empty
+var exportDir = ""
+
+// treeBlock is a block seen on any chain during the run, kept after it is orphaned
+type treeBlock struct {
+	block Block
+	//simulated round the block was first seen in
+	round int
+}
+
+var blockTree = make(map[string]*treeBlock)
+
+var blockTreeLock = &sync.Mutex{}
+
+// exportedBlock is a block of the exported tree with what the validators made of it
+type exportedBlock struct {
+	Hash              string   `json:"hash"`
+	PrevHash          string   `json:"prevHash"`
+	Index             int      `json:"index"`
+	Round             int      `json:"round"`
+	Proposer          string   `json:"proposer"`
+	ProposerMalicious bool     `json:"proposerMalicious"`
+	Malicious         bool     `json:"malicious"`
+	Transactions      []int    `json:"transactions"`
+	Certified         bool     `json:"certified"`
+	BalanceFork       bool     `json:"balanceFork"`
+	Private           bool     `json:"private"`
+	Orphaned          bool     `json:"orphaned"`
+	Holders           int      `json:"holders"`
+	Heads             []string `json:"heads"`
+	Partitions        []int    `json:"partitions,omitempty"`
+}
+
+type exportedTree struct {
+	Round          int             `json:"round"`
+	BlockchainType string          `json:"blockchainType"`
+	Attack         string          `json:"attack"`
+	Blocks         []exportedBlock `json:"blocks"`
+}
+
+func loadExportConfig() {
+	exportDir = envString("EXPORT_DIR", exportDir)
+}
+
+// observeBlockTree remembers every block currently on a chain, so forks stay in the tree after
+// the validators have dropped them
+func observeBlockTree() {
+	if exportDir == "" {
+		return
+	}
+	validatorsSliceLock.Lock()
+	chains := [][]Block{CertifiedBlockchain, balanceAttackFork, privateChain}
+	validatorsCopy := make([]*Validator, len(validators))
+	copy(validatorsCopy, validators)
+	validatorsSliceLock.Unlock()
+	for _, validator := range validatorsCopy {
+		chains = append(chains, chainSnapshot(validator))
+	}
+
+	for _, chain := range chains {
+		rememberBlocks(chain)
+	}
+}
+
+// rememberBlocks adds blocks to the tree, e.g. the branch a validator drops in a reorg
+func rememberBlocks(chain []Block) {
+	if exportDir == "" {
+		return
+	}
+	blockTreeLock.Lock()
+	defer blockTreeLock.Unlock()
+	for _, block := range chain {
+		if _, ok := blockTree[block.Hash]; !ok {
+			blockTree[block.Hash] = &treeBlock{block: block, round: roundCount}
+		}
+	}
+}
+
+// buildExportedTree annotates every block seen so far with its proposer, whether it is certified
+// or orphaned, and which validators hold it or have it as their head
+func buildExportedTree() exportedTree {
+	observeBlockTree()
+
+	validatorsSliceLock.Lock()
+	validatorsCopy := make([]*Validator, len(validators))
+	copy(validatorsCopy, validators)
+	certifiedChain := CertifiedBlockchain
+	validatorsSliceLock.Unlock()
+
+	malicious := make(map[string]bool)
+	holders := make(map[string]int)
+	heads := make(map[string][]string)
+	chains := make(map[*Validator][]Block)
+	for _, validator := range validatorsCopy {
+		chain := chainSnapshot(validator)
+		chains[validator] = chain
+		malicious[validator.Address] = validator.IsMalicious
+		for _, block := range chain {
+			holders[block.Hash]++
+		}
+		head := chain[len(chain)-1].Hash
+		heads[head] = append(heads[head], validator.Address)
+	}
+	//the side of the partition attack each block is held on
+	partitions := make(map[string]map[int]bool)
+	if partitionAttack() {
+		for side, group := range ForkedBlockchain {
+			for _, validator := range group {
+				if validator == nil {
+					continue
+				}
+				for _, block := range chains[validator] {
+					if partitions[block.Hash] == nil {
+						partitions[block.Hash] = make(map[int]bool)
+					}
+					partitions[block.Hash][side] = true
+				}
+			}
+		}
+	}
+	inChain := func(chain []Block) map[string]bool {
+		hashes := make(map[string]bool)
+		for _, block := range chain {
+			hashes[block.Hash] = true
+		}
+		return hashes
+	}
+	certified := inChain(certifiedChain)
+	balanceFork := inChain(balanceAttackFork)
+	private := inChain(privateChain)
+
+	tree := exportedTree{Round: roundCount, BlockchainType: blockchainType, Attack: currAttack}
+	blockTreeLock.Lock()
+	for hash, node := range blockTree {
+		block := node.block
+		exported := exportedBlock{
+			Hash:              hash,
+			PrevHash:          block.PrevHash,
+			Index:             block.Index,
+			Round:             node.round,
+			Proposer:          block.Validator,
+			ProposerMalicious: malicious[block.Validator],
+			Malicious:         block.IsMalicious,
+			Transactions:      make([]int, 0, len(block.Transactions)),
+			Certified:         certified[hash],
+			BalanceFork:       balanceFork[hash],
+			Private:           private[hash],
+			Holders:           holders[hash],
+			Heads:             append([]string{}, heads[hash]...),
+		}
+		exported.Orphaned = !exported.Certified && !exported.BalanceFork && !exported.Private && exported.Holders == 0
+		for _, transaction := range block.Transactions {
+			exported.Transactions = append(exported.Transactions, transaction.ID)
+		}
+		for side := range partitions[hash] {
+			exported.Partitions = append(exported.Partitions, side)
+		}
+		sort.Ints(exported.Partitions)
+		sort.Strings(exported.Heads)
+		tree.Blocks = append(tree.Blocks, exported)
+	}
+	blockTreeLock.Unlock()
+	sort.Slice(tree.Blocks, func(i, j int) bool {
+		if tree.Blocks[i].Index != tree.Blocks[j].Index {
+			return tree.Blocks[i].Index < tree.Blocks[j].Index
+		}
+		return tree.Blocks[i].Hash < tree.Blocks[j].Hash
+	})
+	return tree
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 8 {
+		return hash[:8]
+	}
+	return hash
+}
+
+// treeDOT renders the block tree as a Graphviz digraph with an edge from every block to its parent
+func treeDOT(tree exportedTree) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph blocktree {\n")
+	fmt.Fprintf(&b, "\tlabel=\"%s, attack %s, round %d\";\n", tree.BlockchainType, tree.Attack, tree.Round)
+	fmt.Fprintf(&b, "\trankdir=LR;\n\tnode [shape=box, fontname=monospace];\n")
+	known := make(map[string]bool)
+	for _, block := range tree.Blocks {
+		known[block.Hash] = true
+	}
+	for _, block := range tree.Blocks {
+		label := fmt.Sprintf("#%d %s\\nproposer %s", block.Index, shortHash(block.Hash), shortHash(block.Proposer))
+		if block.ProposerMalicious {
+			label += " (malicious)"
+		}
+		label += fmt.Sprintf("\\n%d txs, held by %d", len(block.Transactions), block.Holders)
+		if len(block.Heads) > 0 {
+			label += fmt.Sprintf("\\nhead of %d", len(block.Heads))
+		}
+		if len(block.Partitions) > 0 {
+			label += fmt.Sprintf("\\npartition %v", block.Partitions)
+		}
+		color := "white"
+		switch {
+		case block.Malicious || block.ProposerMalicious:
+			color = "salmon"
+		case block.Certified:
+			color = "lightblue"
+		case block.BalanceFork:
+			color = "khaki"
+		}
+		style := "filled"
+		if block.Orphaned {
+			style = "filled,dashed"
+		} else if block.Private {
+			style = "filled,dotted"
+		}
+		attributes := fmt.Sprintf("label=\"%s\", fillcolor=%s, style=\"%s\"", label, color, style)
+		if block.Certified {
+			attributes += ", penwidth=2"
+		}
+		if block.Orphaned {
+			attributes += ", fontcolor=gray40"
+		}
+		fmt.Fprintf(&b, "\t\"%s\" [%s];\n", block.Hash, attributes)
+	}
+	for _, block := range tree.Blocks {
+		if known[block.PrevHash] {
+			fmt.Fprintf(&b, "\t\"%s\" -> \"%s\";\n", block.Hash, block.PrevHash)
+		}
+	}
+	fmt.Fprintf(&b, "}\n")
+	return b.String()
+}
+
+// exportBlockTree writes the block tree to blocktree.dot and blocktree.json in the export directory
+func exportBlockTree() {
+	if exportDir == "" {
+		return
+	}
+	tree := buildExportedTree()
+	if err := os.MkdirAll(exportDir, 0755); err != nil {
+		fmt.Println("Could not export block tree:", err)
+		return
+	}
+	data, err := json.MarshalIndent(tree, "", "  ")
+	if err != nil {
+		fmt.Println("Could not export block tree:", err)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(exportDir, "blocktree.json"), data, 0644); err != nil {
+		fmt.Println("Could not export block tree:", err)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(exportDir, "blocktree.dot"), []byte(treeDOT(tree)), 0644); err != nil {
+		fmt.Println("Could not export block tree:", err)
+		return
+	}
+	fmt.Printf("Block tree of %d blocks exported to %s\n", len(tree.Blocks), exportDir)
+}
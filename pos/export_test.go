@@ -0,0 +1,80 @@
+package pos
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExportBlockTree(t *testing.T) {
+	previousDir, previousTree, previousValidators := exportDir, blockTree, validators
+	previousChain, previousRound := CertifiedBlockchain, roundCount
+	t.Cleanup(func() {
+		exportDir, blockTree, validators = previousDir, previousTree, previousValidators
+		CertifiedBlockchain, roundCount = previousChain, previousRound
+	})
+	exportDir = t.TempDir()
+	blockTree = make(map[string]*treeBlock)
+	roundCount = 3
+
+	alice := newTestUser(t, "alice", 100)
+	bob := newTestUser(t, "bob", 100)
+	genesis := newTestGenesis()
+	certified := newTestBlock(genesis, "honest", nil)
+	fork := newTestBlock(genesis, "malicious", []Transaction{newTestTransaction(7, alice, bob, 1, 1, 0)})
+	dropped := newTestBlock(certified, "honest", nil)
+	honest := newTestValidator(t, []Block{genesis, certified})
+	honest.Address = "honest"
+	malicious := newTestValidator(t, []Block{genesis, fork})
+	malicious.Address, malicious.IsMalicious = "malicious", true
+	validators = []*Validator{honest, malicious}
+	CertifiedBlockchain = []Block{genesis, certified}
+	//a block a validator dropped in a reorg stays in the tree
+	rememberBlocks([]Block{dropped})
+	exportBlockTree()
+
+	data, err := os.ReadFile(filepath.Join(exportDir, "blocktree.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree := exportedTree{}
+	if err := json.Unmarshal(data, &tree); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Round != 3 || len(tree.Blocks) != 4 {
+		t.Fatalf("got %d blocks in round %d, want 4 in round 3", len(tree.Blocks), tree.Round)
+	}
+	blocks := make(map[string]exportedBlock)
+	for _, block := range tree.Blocks {
+		blocks[block.Hash] = block
+	}
+	if block := blocks[genesis.Hash]; !block.Certified || block.Holders != 2 || len(block.Heads) != 0 {
+		t.Fatalf("got genesis %+v, want certified and held by both validators", block)
+	}
+	if block := blocks[certified.Hash]; !block.Certified || block.Orphaned || len(block.Heads) != 1 || block.Heads[0] != "honest" {
+		t.Fatalf("got certified block %+v, want the honest validator's head", block)
+	}
+	if block := blocks[fork.Hash]; block.Certified || block.Orphaned || !block.ProposerMalicious || len(block.Transactions) != 1 {
+		t.Fatalf("got fork block %+v, want an uncertified malicious head", block)
+	}
+	if block := blocks[dropped.Hash]; !block.Orphaned || block.Holders != 0 {
+		t.Fatalf("got dropped block %+v, want it orphaned", block)
+	}
+
+	dot, err := os.ReadFile(filepath.Join(exportDir, "blocktree.dot"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"\"" + certified.Hash + "\" -> \"" + genesis.Hash + "\";",
+		"\"" + dropped.Hash + "\" -> \"" + certified.Hash + "\";",
+		"fillcolor=salmon",
+		"style=\"filled,dashed\"",
+	} {
+		if !strings.Contains(string(dot), line) {
+			t.Fatalf("DOT output does not contain %s", line)
+		}
+	}
+}
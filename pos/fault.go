@@ -0,0 +1,342 @@
+package pos
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Faults a FAULT_SCHEDULE event can inject
+const (
+	crashFault   = "crash"
+	restartFault = "restart"
+	corruptFault = "corrupt"
+	reorderFault = "reorder"
+)
+
+// faultEvent crashes or restarts the validators at the given join order indices, or sets the
+// probability that a message is corrupted or reordered, at a simulated time
+type faultEvent struct {
+	at      time.Duration
+	fault   string
+	targets []int
+	rate    float64
+}
+
+// restartRecord follows a restarted validator until it has caught up with the validators that stayed up
+type restartRecord struct {
+	address   string
+	at        time.Duration
+	behind    int
+	recovered bool
+	recovery  time.Duration
+}
+
+// Parsed FAULT_SCHEDULE, in time order
+var faultSchedule = make([]faultEvent, 0)
+
+// Whether FAULT_SCHEDULE had any events, so messages go through the simulated network
+var faultEnabled = false
+
+// Extra delay in milliseconds, drawn uniformly up to this bound, that holds back a reordered message
+var reorderWindow = 200.0
+
+var faultLock = &sync.Mutex{}
+
+// Simulated time each crashed validator went down, keyed by address
+var crashedValidators = make(map[string]time.Duration)
+
+var corruptRate = 0.0
+var reorderRate = 0.0
+
+var crashCount = 0
+var restarts = make([]*restartRecord, 0)
+var crashDropped = 0
+var crashVotesMissed = 0
+var corruptedMessages = 0
+var reorderedMessages = 0
+
+// loadFaultConfig parses FAULT_SCHEDULE, e.g. "10:crash:0,3;30:restart:0;20:corrupt:0.05;40:corrupt:0"
+// crashes the first and fourth validator to join after 10 simulated seconds, restarts the first after 30
+// and corrupts 5% of all messages between 20 and 40 seconds
+func loadFaultConfig() {
+	reorderWindow = envFloat("FAULT_REORDER_WINDOW", reorderWindow)
+	schedule := envString("FAULT_SCHEDULE", "")
+	if schedule == "" {
+		return
+	}
+	for _, entry := range strings.Split(schedule, ";") {
+		fields := strings.Split(strings.TrimSpace(entry), ":")
+		if len(fields) != 3 {
+			fmt.Printf("FAULT_SCHEDULE entry %s is not time:fault:argument\n", entry)
+			continue
+		}
+		seconds, err := strconv.ParseFloat(strings.TrimSpace(fields[0]), 64)
+		if err != nil || seconds < 0 {
+			fmt.Printf("FAULT_SCHEDULE time %s is not a number of seconds\n", fields[0])
+			continue
+		}
+		event := faultEvent{at: time.Duration(seconds * float64(time.Second)), fault: strings.TrimSpace(fields[1])}
+		switch event.fault {
+		case crashFault, restartFault:
+			for _, field := range strings.Split(fields[2], ",") {
+				index, err := strconv.Atoi(strings.TrimSpace(field))
+				if err != nil || index < 0 {
+					fmt.Printf("FAULT_SCHEDULE validator %s is not a join order index\n", field)
+					continue
+				}
+				event.targets = append(event.targets, index)
+			}
+			if len(event.targets) == 0 {
+				continue
+			}
+		case corruptFault, reorderFault:
+			event.rate, err = strconv.ParseFloat(strings.TrimSpace(fields[2]), 64)
+			if err != nil || event.rate < 0 || event.rate > 1 {
+				fmt.Printf("FAULT_SCHEDULE rate %s is not between 0 and 1\n", fields[2])
+				continue
+			}
+		default:
+			fmt.Printf("Unknown fault %s in FAULT_SCHEDULE\n", event.fault)
+			continue
+		}
+		faultSchedule = append(faultSchedule, event)
+	}
+	faultEnabled = len(faultSchedule) > 0
+	for i := 1; i < len(faultSchedule); i++ {
+		for j := i; j > 0 && faultSchedule[j].at < faultSchedule[j-1].at; j-- {
+			faultSchedule[j], faultSchedule[j-1] = faultSchedule[j-1], faultSchedule[j]
+		}
+	}
+}
+
+func faultScheduled() bool {
+	return faultEnabled
+}
+
+// applyFaultSchedule injects the faults that are due and notes restarted validators that have caught up
+func applyFaultSchedule() {
+	if !faultEnabled {
+		return
+	}
+	validatorsSliceLock.Lock()
+	members := make([]*Validator, len(validators))
+	copy(members, validators)
+	validatorsSliceLock.Unlock()
+
+	faultLock.Lock()
+	defer faultLock.Unlock()
+	now := clock.now()
+	for len(faultSchedule) > 0 && faultSchedule[0].at <= now {
+		event := faultSchedule[0]
+		faultSchedule = faultSchedule[1:]
+		switch event.fault {
+		case crashFault:
+			for _, index := range event.targets {
+				if index >= len(members) {
+					fmt.Printf("Cannot crash validator %d, only %d validators joined\n", index, len(members))
+					continue
+				}
+				if _, ok := crashedValidators[members[index].Address]; ok {
+					continue
+				}
+				crashedValidators[members[index].Address] = now
+				crashCount++
+				fmt.Printf("Validator %d crashed\n", index)
+			}
+		case restartFault:
+			for _, index := range event.targets {
+				if index >= len(members) {
+					continue
+				}
+				validator := members[index]
+				if _, ok := crashedValidators[validator.Address]; !ok {
+					continue
+				}
+				delete(crashedValidators, validator.Address)
+				//the validator comes back with the chain it had when it crashed
+				behind := 0
+				if head, own := longestLiveChainLocked(members, validator), chainSnapshot(validator); len(head) > len(own) {
+					behind = len(head) - len(own)
+				}
+				restarts = append(restarts, &restartRecord{address: validator.Address, at: now, behind: behind})
+				fmt.Printf("Validator %d restarted %d blocks behind\n", index, behind)
+			}
+		case corruptFault:
+			corruptRate = event.rate
+		case reorderFault:
+			reorderRate = event.rate
+		}
+	}
+
+	for _, restart := range restarts {
+		if restart.recovered {
+			continue
+		}
+		for _, validator := range members {
+			if validator.Address != restart.address {
+				continue
+			}
+			head := longestLiveChainLocked(members, validator)
+			if head == nil || chainHead(validator).Hash == head[len(head)-1].Hash {
+				restart.recovered = true
+				restart.recovery = now - restart.at
+			}
+		}
+	}
+}
+
+// longestLiveChainLocked returns a copy of the longest chain among the validators other than self that are up,
+// or nil if none is
+func longestLiveChainLocked(members []*Validator, self *Validator) []Block {
+	var longest []Block
+	for _, validator := range members {
+		if _, down := crashedValidators[validator.Address]; down || validator == self {
+			continue
+		}
+		if chain := chainSnapshot(validator); longest == nil || len(chain) > len(longest) {
+			longest = chain
+		}
+	}
+	return longest
+}
+
+// faultReachable reports whether neither end of a link has crashed
+func faultReachable(from string, to string) bool {
+	faultLock.Lock()
+	defer faultLock.Unlock()
+	_, fromCrashed := crashedValidators[from]
+	_, toCrashed := crashedValidators[to]
+	if fromCrashed || toCrashed {
+		crashDropped++
+		return false
+	}
+	return true
+}
+
+// crashed reports whether a validator is down
+func crashed(validator *Validator) bool {
+	faultLock.Lock()
+	defer faultLock.Unlock()
+	_, ok := crashedValidators[validator.Address]
+	return ok
+}
+
+// droppedWhileDown reports whether a validator is down, counting the message it was handed as lost.
+// A crashed validator keeps draining its channels so senders do not block, but acts on nothing until it restarts
+func droppedWhileDown(validator *Validator) bool {
+	faultLock.Lock()
+	defer faultLock.Unlock()
+	if _, ok := crashedValidators[validator.Address]; !ok {
+		return false
+	}
+	crashDropped++
+	return true
+}
+
+// recordMissedVote counts a missing vote against crash faults when its validator is down
+func recordMissedVote(validator *Validator) {
+	faultLock.Lock()
+	defer faultLock.Unlock()
+	if _, ok := crashedValidators[validator.Address]; ok {
+		crashVotesMissed++
+	}
+}
+
+// injectMessageFaults returns the message as it arrives, possibly corrupted, and how long it is held back
+func injectMessageFaults(msg interface{}) (interface{}, time.Duration) {
+	faultLock.Lock()
+	defer faultLock.Unlock()
+	delay := time.Duration(0)
+	if reorderRate > 0 && rand.Float64() < reorderRate {
+		reorderedMessages++
+		delay = milliseconds(rand.Float64() * reorderWindow)
+	}
+	if corruptRate > 0 && rand.Float64() < corruptRate {
+		corruptedMessages++
+		msg = corruptMessage(msg)
+	}
+	return msg, delay
+}
+
+// corrupt flips the first character of a hex string, e.g. a hash or signature
+func corrupt(value string) string {
+	if value == "" {
+		return "0"
+	}
+	if value[0] == '0' {
+		return "1" + value[1:]
+	}
+	return "0" + value[1:]
+}
+
+// corruptBlock damages a block so that its hash no longer matches and its proposer signature fails
+func corruptBlock(block Block) Block {
+	block.Timestamp += "?"
+	block.ProposerSignature = corrupt(block.ProposerSignature)
+	return block
+}
+
+// corruptMessage returns a copy of a message with a field damaged in transit, which its receiver
+// detects as an invalid block, vote or transaction
+func corruptMessage(msg interface{}) interface{} {
+	switch msg := msg.(type) {
+	case ValidateBlockMessage:
+		msg.newBlock = corruptBlock(msg.newBlock)
+		return msg
+	case ValidateShortAttackBlockMessage:
+		msg.newBlock = corruptBlock(msg.newBlock)
+		msg.newBlockTwo = corruptBlock(msg.newBlockTwo)
+		return msg
+	case VerifiedBlockMessage:
+		msg.newBlock = corruptBlock(msg.newBlock)
+		return msg
+	case VerifiedShortAttackBlockMessage:
+		msg.newBlock = corruptBlock(msg.newBlock)
+		return msg
+	case VerifiedShortAttackBlockTwoMessage:
+		msg.newBlockTwo = corruptBlock(msg.newBlockTwo)
+		return msg
+	case ValidationStatusMessage:
+		msg.signature = corrupt(msg.signature)
+		return msg
+	case ValidationShortAttackStatusMessage:
+		msg.signature = corrupt(msg.signature)
+		msg.signatureTwo = corrupt(msg.signatureTwo)
+		return msg
+	case NewTransactionMessage:
+		msg.transaction.Signature = corrupt(msg.transaction.Signature)
+		return msg
+	}
+	return msg
+}
+
+func printFaultEvaluation() {
+	if !faultEnabled {
+		return
+	}
+	faultLock.Lock()
+	defer faultLock.Unlock()
+	fmt.Printf("Validators crashed: %d, down now: %d, restarted: %d\n", crashCount, len(crashedValidators), len(restarts))
+	fmt.Printf("Messages lost to crashed validators: %d, votes missing from crashed validators: %d\n", crashDropped, crashVotesMissed)
+	fmt.Printf("Messages corrupted: %d, reordered: %d\n", corruptedMessages, reorderedMessages)
+	recovered, behind := 0, 0
+	totalRecovery := 0.0
+	for _, restart := range restarts {
+		behind += restart.behind
+		if restart.recovered {
+			recovered++
+			totalRecovery += restart.recovery.Seconds()
+		}
+	}
+	if len(restarts) > 0 {
+		averageRecovery := 0.0
+		if recovered > 0 {
+			averageRecovery = totalRecovery / float64(recovered)
+		}
+		fmt.Printf("Restarted validators caught up: %d of %d, average blocks behind at restart: %f, average time to catch up: %f seconds\n", recovered, len(restarts), float64(behind)/float64(len(restarts)), averageRecovery)
+	}
+}
@@ -0,0 +1,130 @@
+package pos
+
+import (
+	"testing"
+	"time"
+)
+
+// crashForTest marks the validators as down until the test ends
+func crashForTest(t *testing.T, down ...*Validator) {
+	t.Helper()
+	faultLock.Lock()
+	previous := crashedValidators
+	crashedValidators = make(map[string]time.Duration)
+	for _, validator := range down {
+		crashedValidators[validator.Address] = 0
+	}
+	faultLock.Unlock()
+	t.Cleanup(func() {
+		faultLock.Lock()
+		crashedValidators = previous
+		faultLock.Unlock()
+	})
+}
+
+func TestCrashedValidatorsAreNotChosen(t *testing.T) {
+	previousValidators, previousCommittee := validators, validationCommittee
+	t.Cleanup(func() { validators, validationCommittee = previousValidators, previousCommittee })
+	up := &Validator{Address: "up", Stake: 1}
+	down := &Validator{Address: "down", Stake: 1000}
+	alsoUp := &Validator{Address: "alsoUp", Stake: 1}
+	validators = []*Validator{up, down, alsoUp}
+	crashForTest(t, down)
+
+	for i := 0; i < 50; i++ {
+		committee := chooseValidationCommittee(validators, len(validators))
+		if len(committee) != 2 {
+			t.Fatalf("got a committee of %d, want the 2 running validators", len(committee))
+		}
+		for _, member := range committee {
+			if member == down {
+				t.Fatal("crashed validator drawn into the committee")
+			}
+		}
+		validationCommittee = validators
+		if chooseBlockProposer() == down {
+			t.Fatal("crashed validator chosen as proposer")
+		}
+	}
+
+	crashForTest(t, validators...)
+	if proposer := chooseBlockProposer(); proposer != nil {
+		t.Fatalf("got proposer %s with every validator down", proposer.Address)
+	}
+}
+
+func TestLongestChainConsensusSkipsCrashedValidators(t *testing.T) {
+	previousValidators, previousChain, previousIssuance := validators, CertifiedBlockchain, blockIssuance
+	t.Cleanup(func() {
+		validators, CertifiedBlockchain, blockIssuance = previousValidators, previousChain, previousIssuance
+	})
+	blockIssuance = make(map[string]issuedReward)
+
+	genesis := newTestGenesis()
+	certified := newTestBlock(genesis, "up", nil)
+	stale := newTestBlock(genesis, "down", nil)
+	staleNext := newTestBlock(stale, "down", nil)
+	up := newTestValidator(t, []Block{genesis, certified})
+	up.Address = "up"
+	behind := newTestValidator(t, []Block{genesis})
+	behind.Address = "behind"
+	//the crashed validator holds the longest chain, but it is out of date
+	down := newTestValidator(t, []Block{genesis, stale, staleNext})
+	down.Address = "down"
+	for _, validator := range []*Validator{up, behind, down} {
+		validator.pool = newMempool()
+	}
+	validators = []*Validator{up, behind, down}
+	crashForTest(t, down)
+
+	longestChainConsensus()
+	if len(CertifiedBlockchain) != 2 || CertifiedBlockchain[1].Hash != certified.Hash {
+		t.Fatalf("certified a chain of %d blocks, want the running validator's", len(CertifiedBlockchain))
+	}
+	if len(behind.Blockchain) != 2 {
+		t.Fatalf("running validator holds %d blocks after consensus, want 2", len(behind.Blockchain))
+	}
+	if len(down.Blockchain) != 3 || down.Blockchain[1].Hash != stale.Hash {
+		t.Fatal("consensus changed the chain of a crashed validator")
+	}
+}
+
+func TestDroppedWhileDown(t *testing.T) {
+	up := &Validator{Address: "up"}
+	down := &Validator{Address: "down"}
+	crashForTest(t, down)
+	previousDropped := crashDropped
+	t.Cleanup(func() { crashDropped = previousDropped })
+
+	if droppedWhileDown(up) {
+		t.Fatal("running validator dropped a message")
+	}
+	if !droppedWhileDown(down) {
+		t.Fatal("crashed validator acted on a message")
+	}
+	if crashDropped != previousDropped+1 {
+		t.Fatalf("got %d dropped messages, want %d", crashDropped, previousDropped+1)
+	}
+}
+
+func TestLongestLiveChainSkipsCrashedValidators(t *testing.T) {
+	genesis := newTestGenesis()
+	first := newTestBlock(genesis, "up", nil)
+	up := newTestValidator(t, []Block{genesis, first})
+	self := newTestValidator(t, []Block{genesis, first, newTestBlock(first, "self", nil)})
+	down := newTestValidator(t, []Block{genesis, first, newTestBlock(first, "down", nil), newTestBlock(first, "down", nil)})
+	up.Address, self.Address, down.Address = "up", "self", "down"
+	crashForTest(t, down)
+
+	faultLock.Lock()
+	longest := longestLiveChainLocked([]*Validator{up, self, down}, self)
+	faultLock.Unlock()
+	if len(longest) != 2 || longest[1].Hash != first.Hash {
+		t.Fatalf("got a chain of %d blocks, want the running peer's", len(longest))
+	}
+	//the result is a copy, so later appends to the peer's chain do not change it
+	longest[1] = genesis
+	if up.Blockchain[1].Hash != first.Hash {
+		t.Fatal("longest live chain shares its blocks with the validator's chain")
+	}
+}
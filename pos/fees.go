@@ -0,0 +1,234 @@
+package pos
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Fee models a proposer can order its mempool with
+const (
+	priorityFeeModel = "priority"
+	eip1559FeeModel  = "eip1559"
+)
+
+// Maximum number of transactions in a block
+var blockCapacity = 5
+
+var feeModel = priorityFeeModel
+
+// Base fee of the first block under the eip1559 fee model
+var initialBaseFee = 1.0
+
+// The base fee moves by at most 1/baseFeeChangeDenominator per block, as in EIP-1559
+const baseFeeChangeDenominator = 8
+
+var errFeeBelowBaseFee = errors.New("transaction fee is below the base fee")
+
+// Simulated time each transaction was signed at, kept until it is certified or outlives the mempool TTL
+var transactionSubmitted = make(map[int]time.Duration)
+
+// Simulated seconds from signing to certification, by fee level
+var inclusionDelays = make(map[int][]float64)
+
+// Height of the certified chain already searched for included transactions
+var inclusionHeight = 1
+
+var transactionSubmittedLock = &sync.Mutex{}
+
+func loadFeeConfig() {
+	blockCapacity = envInt("BLOCK_CAPACITY", blockCapacity)
+	if blockCapacity < 1 {
+		fmt.Println("BLOCK_CAPACITY must be at least 1, using 1")
+		blockCapacity = 1
+	}
+	feeModel = envString("FEE_MODEL", feeModel)
+	if feeModel != priorityFeeModel && feeModel != eip1559FeeModel {
+		fmt.Printf("Unknown FEE_MODEL %s, using %s\n", feeModel, priorityFeeModel)
+		feeModel = priorityFeeModel
+	}
+	initialBaseFee = envFloat("INITIAL_BASE_FEE", initialBaseFee)
+}
+
+// nextBaseFee is the base fee of the block built on parent
+// It rises when parent was more than half full and falls when it was less, by at most 12.5%
+func nextBaseFee(parent Block) float64 {
+	if feeModel != eip1559FeeModel {
+		return 0
+	}
+	if parent.BaseFee == 0 {
+		return initialBaseFee
+	}
+	target := int64(blockCapacity / 2)
+	if target < 1 {
+		target = 1
+	}
+	base := toFixedPoint(parent.BaseFee)
+	//an odd capacity lets a full block exceed twice the target, the change is capped at 12.5% anyway
+	delta := int64(len(parent.Transactions)) - target
+	if delta > target {
+		delta = target
+	}
+	if delta < -target {
+		delta = -target
+	}
+	next := base + base*delta/(target*baseFeeChangeDenominator)
+	if next < 1 {
+		next = 1
+	}
+	return fromFixedPoint(next)
+}
+
+// proposerFee is the part of a transaction's fee the proposer earns, the rest is burned
+func proposerFee(block Block, transaction Transaction) float64 {
+	return fromFixedPoint(toFixedPoint(transaction.Reward) - toFixedPoint(block.BaseFee))
+}
+
+// orderByFee sorts candidates by the tip above the base fee, highest first,
+// while keeping each sender's transactions in nonce order
+func orderByFee(candidates []Transaction, baseFee float64) []Transaction {
+	bySender := make(map[string][]Transaction)
+	for _, transaction := range candidates {
+		address := userAddress(transaction.Sender)
+		bySender[address] = append(bySender[address], transaction)
+	}
+	for _, queue := range bySender {
+		sort.Slice(queue, func(i, j int) bool {
+			if queue[i].Nonce != queue[j].Nonce {
+				return queue[i].Nonce < queue[j].Nonce
+			}
+			return queue[i].ID < queue[j].ID
+		})
+	}
+
+	tip := func(transaction Transaction) int64 {
+		return toFixedPoint(transaction.Reward) - toFixedPoint(baseFee)
+	}
+	ordered := make([]Transaction, 0, len(candidates))
+	for len(ordered) < len(candidates) {
+		best := ""
+		for address, queue := range bySender {
+			if len(queue) == 0 {
+				continue
+			}
+			if best == "" {
+				best = address
+				continue
+			}
+			head, bestHead := queue[0], bySender[best][0]
+			if tip(head) > tip(bestHead) || (tip(head) == tip(bestHead) && head.ID < bestHead.ID) {
+				best = address
+			}
+		}
+		ordered = append(ordered, bySender[best][0])
+		bySender[best] = bySender[best][1:]
+	}
+	return ordered
+}
+
+func recordTransactionSubmitted(id int) {
+	transactionSubmittedLock.Lock()
+	transactionSubmitted[id] = clock.now()
+	transactionSubmittedLock.Unlock()
+}
+
+// recordInclusions measures the inclusion delay of the transactions certified since the last round
+// Submissions older than the mempool TTL are dropped, since no mempool keeps the transaction any more
+func recordInclusions() {
+	transactionSubmittedLock.Lock()
+	defer transactionSubmittedLock.Unlock()
+	now := clock.now()
+	if inclusionHeight > len(CertifiedBlockchain) {
+		inclusionHeight = len(CertifiedBlockchain)
+	}
+	for _, block := range CertifiedBlockchain[inclusionHeight:] {
+		for _, transaction := range block.Transactions {
+			submitted, ok := transactionSubmitted[transaction.ID]
+			if !ok {
+				continue
+			}
+			level := feeLevel(transaction.Reward)
+			inclusionDelays[level] = append(inclusionDelays[level], (now - submitted).Seconds())
+			delete(transactionSubmitted, transaction.ID)
+		}
+	}
+	inclusionHeight = len(CertifiedBlockchain)
+	cutoff := now - time.Duration(mempoolTTL)*time.Second
+	for id, submitted := range transactionSubmitted {
+		if submitted < cutoff {
+			delete(transactionSubmitted, id)
+		}
+	}
+}
+
+// feeLevel buckets a fee into whole tokens, with everything from 4 up in one bucket
+func feeLevel(fee float64) int {
+	level := int(fee)
+	if level > 4 {
+		level = 4
+	}
+	if level < 0 {
+		level = 0
+	}
+	return level
+}
+
+func printFeeEvaluation() {
+	revenue := make(map[string]float64)
+	burned := 0.0
+	for _, block := range CertifiedBlockchain[1:] {
+		for _, transaction := range block.Transactions {
+			revenue[block.Validator] += proposerFee(block, transaction)
+			burned += block.BaseFee
+		}
+	}
+	transactionSubmittedLock.Lock()
+	delays := make(map[int][]float64)
+	for level, levelDelays := range inclusionDelays {
+		delays[level] = append([]float64{}, levelDelays...)
+	}
+	transactionSubmittedLock.Unlock()
+
+	addresses := make([]string, 0, len(revenue))
+	for address := range revenue {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		return revenue[addresses[i]] > revenue[addresses[j]]
+	})
+	honestRevenue := 0.0
+	maliciousRevenue := 0.0
+	fmt.Printf("Fee model: %s, block capacity: %d\n", feeModel, blockCapacity)
+	fmt.Println("Fee revenue per validator:")
+	for _, address := range addresses {
+		validator := validatorByAddress(address)
+		label := "honest"
+		if validator != nil && validator.IsMalicious {
+			label = "malicious"
+			maliciousRevenue += revenue[address]
+		} else {
+			honestRevenue += revenue[address]
+		}
+		fmt.Printf("  %s (%s): %f\n", address[:3], label, revenue[address])
+	}
+	fmt.Printf("Fee revenue honest: %f, malicious: %f, burned: %f\n", honestRevenue, maliciousRevenue, burned)
+	if feeModel == eip1559FeeModel {
+		fmt.Printf("Current base fee: %f\n", CertifiedBlockchain[len(CertifiedBlockchain)-1].BaseFee)
+	}
+	for level := 0; level <= 4; level++ {
+		if len(delays[level]) == 0 {
+			continue
+		}
+		total := 0.0
+		for _, delay := range delays[level] {
+			total += delay
+		}
+		bucket := fmt.Sprintf("%d-%d", level, level+1)
+		if level == 4 {
+			bucket = "4+"
+		}
+		fmt.Printf("Inclusion delay for fee %s: %f seconds over %d transactions\n", bucket, total/float64(len(delays[level])), len(delays[level]))
+	}
+}
@@ -0,0 +1,44 @@
+package pos
+
+import "testing"
+
+func TestNextBaseFeeChangesByAtMostAnEighth(t *testing.T) {
+	previousModel, previousCapacity := feeModel, blockCapacity
+	t.Cleanup(func() { feeModel, blockCapacity = previousModel, previousCapacity })
+	feeModel = eip1559FeeModel
+
+	for _, test := range []struct {
+		capacity int
+		used     int
+		want     float64
+	}{
+		{5, 5, 1.125},
+		{5, 4, 1.125},
+		{5, 3, 1.0625},
+		{5, 2, 1},
+		{5, 0, 0.875},
+		{4, 4, 1.125},
+		{1, 1, 1},
+		{1, 0, 0.875},
+	} {
+		blockCapacity = test.capacity
+		parent := Block{BaseFee: 1, Transactions: make([]Transaction, test.used)}
+		if got := nextBaseFee(parent); got != test.want {
+			t.Errorf("capacity %d, %d transactions: got base fee %v, want %v", test.capacity, test.used, got, test.want)
+		}
+	}
+}
+
+func TestNextBaseFeeStartsAtInitialBaseFee(t *testing.T) {
+	previousModel := feeModel
+	t.Cleanup(func() { feeModel = previousModel })
+
+	feeModel = priorityFeeModel
+	if got := nextBaseFee(Block{BaseFee: 1}); got != 0 {
+		t.Fatalf("priority fee model: got base fee %v, want 0", got)
+	}
+	feeModel = eip1559FeeModel
+	if got := nextBaseFee(Block{}); got != initialBaseFee {
+		t.Fatalf("genesis parent: got base fee %v, want %v", got, initialBaseFee)
+	}
+}
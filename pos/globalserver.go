@@ -56,6 +56,9 @@ var currAttack = ""
 
 var forked bool
 
+// Number of forks created by a proposer getting conflicting blocks accepted
+var forkCount = 0
+
 var forkedCounter = 0
 
 var ForkedBlockchain = make([][]*Validator, 2)
@@ -66,6 +69,9 @@ var delegateCounter = 0
 
 var roundCount = 0
 
+// Broadcast at the end of every round, its lock guards writes to roundCount
+var roundEnded = sync.NewCond(&sync.Mutex{})
+
 var startTime = time.Now()
 
 var blockchainType string
@@ -75,6 +81,7 @@ func Run(runType string, numValidators int, numUsers int, numMal int, comSize in
 	if err != nil {
 		log.Fatal(err)
 	}
+	loadKeyConfig()
 	startTime = time.Now()
 	committeeSize = comSize
 	delegateSize = delSize
@@ -82,6 +89,38 @@ func Run(runType string, numValidators int, numUsers int, numMal int, comSize in
 	blockchainType = blkChainType
 
 	currAttack = attack
+	if attack == "sybil" {
+		loadSybilConfig()
+	}
+	if attack == "adaptive" {
+		loadAdaptiveConfig()
+	}
+	if attack == "selfish_proposing" {
+		loadSelfishConfig()
+	}
+	if attack == "double_spend" {
+		loadDoubleSpendConfig()
+	}
+	if attack == "replay" {
+		loadReplayConfig()
+	}
+	loadLightClientConfig()
+	loadCertificateConfig()
+	loadFeeConfig()
+	loadMempoolConfig()
+	loadLedgerConfig()
+	loadRewardConfig()
+	loadNetworkConfig()
+	loadGossipConfig()
+	loadPartitionConfig()
+	loadFaultConfig()
+	loadSyncConfig()
+	loadStoreConfig()
+	loadExportConfig()
+	loadAPIConfig()
+	loadEventConfig()
+	loadMetricsConfig()
+	seedRandom(seed)
 	for i := range ForkedBlockchain {
 		ForkedBlockchain[i] = make([]*Validator, numValidators/2)
 	}
@@ -91,8 +130,19 @@ func Run(runType string, numValidators int, numUsers int, numMal int, comSize in
 	genesisBlock := Block{}
 	genesisBlock = Block{Index: 0, Timestamp: t.String(), Transactions: []Transaction{}, Hash: calculateBlockHash(genesisBlock), PrevHash: "", Validator: ""}
 	CertifiedBlockchain = append(CertifiedBlockchain, genesisBlock)
+	if err := resumeSimulation(); err != nil {
+		log.Fatal(err)
+	}
+	//after resuming, so the committee key comes from the keystore the snapshot was written with
+	loadBeaconKey()
+	if err := openStore(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Seed: %d\n", seed)
+	startLightClients()
+	startNetwork()
 
-	if attack == "balance" {
+	if attack == "balance" && len(balanceAttackFork) == 0 {
 		// create initial fork
 		t := time.Now()
 		genesisBlockFork := Block{}
@@ -109,6 +159,7 @@ func Run(runType string, numValidators int, numUsers int, numMal int, comSize in
 	}
 	log.Println("TCP Server Listening on port :", tcpPort)
 	defer server.Close()
+	startAPI()
 
 	//Advances time slots, choosing new proposers that add blocks to the chain and new validation committees
 	//Standard proof of stake
@@ -117,7 +168,13 @@ func Run(runType string, numValidators int, numUsers int, numMal int, comSize in
 			go func() {
 				for {
 					balanceNextTimeSlot()
-					roundCount++
+					recordConcentration()
+					recordInclusions()
+					endRound()
+					observeRound()
+					persistRound()
+					publishAPIView()
+					observeBlockTree()
 					if roundCount%10 == 0 {
 						printEvaluation()
 					}
@@ -127,7 +184,13 @@ func Run(runType string, numValidators int, numUsers int, numMal int, comSize in
 			go func() {
 				for {
 					nextTimeSlot()
-					roundCount++
+					recordConcentration()
+					recordInclusions()
+					endRound()
+					observeRound()
+					persistRound()
+					publishAPIView()
+					observeBlockTree()
 					if roundCount%10 == 0 {
 						printEvaluation()
 					}
@@ -139,7 +202,13 @@ func Run(runType string, numValidators int, numUsers int, numMal int, comSize in
 			go func() {
 				for {
 					balanceReputationNextTimeSlot()
-					roundCount++
+					recordConcentration()
+					recordInclusions()
+					endRound()
+					observeRound()
+					persistRound()
+					publishAPIView()
+					observeBlockTree()
 					if roundCount%10 == 0 {
 						printEvaluation()
 					}
@@ -149,7 +218,13 @@ func Run(runType string, numValidators int, numUsers int, numMal int, comSize in
 			go func() {
 				for {
 					nextReputationTimeSlot()
-					roundCount++
+					recordConcentration()
+					recordInclusions()
+					endRound()
+					observeRound()
+					persistRound()
+					publishAPIView()
+					observeBlockTree()
 					if roundCount%10 == 0 {
 						printEvaluation()
 					}
@@ -181,9 +256,13 @@ func Run(runType string, numValidators int, numUsers int, numMal int, comSize in
 					malString = "y"
 					numMal--
 				}
-				go handleConnection(conn, runType, "v", malString, false)
+				go handleConnection(conn, runType, "v", malString, "", false, false)
 				numValidators--
 			}
+			numMalUsers := 0
+			if attack == "double_spend" {
+				numMalUsers = doubleSpendUsers
+			}
 			for numUsers > 0 {
 				conn, err := net.Dial("tcp", ":9000")
 				if err != nil {
@@ -193,9 +272,17 @@ func Run(runType string, numValidators int, numUsers int, numMal int, comSize in
 				if err != nil {
 					log.Fatal(err)
 				}
-				go handleConnection(conn, runType, "u", "", false)
+				malString := "n"
+				if numMalUsers > 0 {
+					malString = "y"
+					numMalUsers--
+				}
+				go handleConnection(conn, runType, "u", malString, "", false, false)
 				numUsers--
 			}
+			if attack == "sybil" {
+				runSybilAdversary(runType, server.Addr().String())
+			}
 		}
 	}()
 	//Accepts connections joining the network
@@ -204,11 +291,24 @@ func Run(runType string, numValidators int, numUsers int, numMal int, comSize in
 		if err != nil {
 			log.Fatal(err)
 		}
-		go handleConnection(conn, runType, "", "", false)
+		go handleConnection(conn, runType, "", "", "", false, false)
 	}
 
 }
 
+// endRound counts the round that just ended and wakes anyone waiting for it
+func endRound() {
+	roundEnded.L.Lock()
+	roundCount++
+	roundEnded.Broadcast()
+	roundEnded.L.Unlock()
+}
+
+// partitionAttack reports whether malicious proposers fork the partitioned network in this run
+func partitionAttack() bool {
+	return currAttack == "network_partition" || currAttack == "double_spend" || currAttack == "replay"
+}
+
 func createBalanceAttackConnections(numValidators int, numMal int, runType string, numUsers int) {
 	// split views of validators if balance attack
 	viewForkedChain := false
@@ -244,7 +344,7 @@ func createBalanceAttackConnections(numValidators int, numMal int, runType strin
 			honestValidatorsSplit++
 		}
 
-		go handleConnection(conn, runType, "v", malString, viewForkedChain)
+		go handleConnection(conn, runType, "v", malString, "", false, viewForkedChain)
 		numValidators--
 	}
 	for numUsers > 0 {
@@ -256,7 +356,7 @@ func createBalanceAttackConnections(numValidators int, numMal int, runType strin
 		if err != nil {
 			log.Fatal(err)
 		}
-		go handleConnection(conn, runType, "u", "", viewForkedChain)
+		go handleConnection(conn, runType, "u", "", "", false, viewForkedChain)
 		numUsers--
 	}
 }
@@ -265,14 +365,21 @@ func chooseValidationCommittee(validators []*Validator, committeeSize int) []*Va
 	//make a slice of stakes for weighted dsitribution
 	validatorsSliceLock.Lock()
 	stakeWeights := make([]float64, len(validators))
+	running := 0
 	for i, validator := range validators {
+		//crashed validators cannot be drawn
+		if crashed(validator) {
+			continue
+		}
 		stakeWeights[i] = validator.Stake
+		running++
 	}
 	validatorsSliceLock.Unlock()
 
 	validationCommittee := make([]*Validator, 0)
 	weightedDist := sampleuv.NewWeighted(stakeWeights, nil)
-	for i := 0; i < committeeSize; i++ {
+	//sampling past the last validator can walk off the weight heap on rounding error
+	for i := 0; i < committeeSize && i < running; i++ {
 		index, isOk := weightedDist.Take()
 		if isOk {
 			validationCommittee = append(validationCommittee, validators[index])
@@ -291,6 +398,10 @@ func chooseDelegates(validators []*Validator, delegateSize int) []*Validator {
 	validatorsSliceLock.Lock()
 	for _, validator := range validators {
 		validatorMap[validator.Address] = validator
+		//crashed validators do not take part in the vote
+		if crashed(validator) {
+			continue
+		}
 		msg := DelegateVoteRequestMessage{
 			delegateSize: delegateSize,
 		}
@@ -300,6 +411,9 @@ func chooseDelegates(validators []*Validator, delegateSize int) []*Validator {
 	//Recieve and tally up votes, punishing those who voted for someone with less reputation
 	delegateResultMap := make(map[string]int)
 	for _, validator := range validators {
+		if crashed(validator) {
+			continue
+		}
 		msg := <-validator.delegateVoteChannel
 		validator.reputation = math.Min(100, validator.reputation+1)
 		for _, validatorVoted := range msg.delegateVotes {
@@ -317,30 +431,50 @@ func chooseDelegates(validators []*Validator, delegateSize int) []*Validator {
 	})
 
 	validatorAddresses = validatorAddresses[:delegateSize]
-	delegates = make([]*Validator, 0)
+	chosen := make([]*Validator, 0)
 	for _, validatorAddress := range validatorAddresses {
-		delegates = append(delegates, validatorMap[validatorAddress])
+		chosen = append(chosen, validatorMap[validatorAddress])
 	}
-	return delegates
+	return chosen
 
 }
 
+// certify makes a copy of chain the certified chain
+func certify(chain []Block) {
+	certified := make([]Block, len(chain))
+	copy(certified, chain)
+	validatorsSliceLock.Lock()
+	CertifiedBlockchain = certified
+	validatorsSliceLock.Unlock()
+}
+
+// setProposer makes a validator the proposer of the current slot
+func setProposer(validator *Validator) {
+	validatorsSliceLock.Lock()
+	proposer = validator
+	validatorsSliceLock.Unlock()
+}
+
 func chooseBlockProposer() *Validator {
 	if len(validationCommittee) == 0 {
 		return nil
 	}
 
+	candidates := make([]*Validator, 0, len(validationCommittee))
 	totalWeight := 0.0
 	for _, validator := range validationCommittee {
+		if crashed(validator) {
+			continue
+		}
+		candidates = append(candidates, validator)
 		totalWeight += validator.Stake
 	}
 
 	randomNumber := 0.0
-	rand.Seed(time.Now().UnixNano())
 	randomNumber = rand.Float64() * totalWeight
 
 	weightSum := 0.0
-	for _, validator := range validationCommittee {
+	for _, validator := range candidates {
 		weightSum += validator.Stake
 		if weightSum >= randomNumber {
 			return validator
@@ -354,6 +488,10 @@ func balanceLongestChainConsensus() {
 	secondLongestLength := -1
 	var longestValidator *Validator = nil
 	for _, validator := range validators {
+		//a crashed validator's chain is stale, it syncs after it restarts
+		if crashed(validator) {
+			continue
+		}
 		// + 1 to check for second longest chain for balance attack
 		if len(validator.Blockchain)+1 >= longestLength {
 			if longestLength == -1 && len(validator.Blockchain) > longestLength {
@@ -379,33 +517,22 @@ func balanceLongestChainConsensus() {
 	if longestLength-secondLongestLength <= 1 {
 		fmt.Println("Longest chain consensus delayed")
 	} else {
-		CertifiedBlockchain = make([]Block, len(longestValidator.Blockchain))
-		copy(CertifiedBlockchain, longestValidator.Blockchain)
+		certify(longestValidator.Blockchain)
 
 		for _, validator := range validators {
 			//broadcast the verified transactions to all blocks
-			if validator.Address == longestValidator.Address {
+			if validator.Address == longestValidator.Address || crashed(validator) {
 				continue
 			}
-			blockChainBuffer := make([]Block, len(CertifiedBlockchain))
-			copy(blockChainBuffer, CertifiedBlockchain)
-			longestValidator.transactionPoolLock.Lock()
-			unconfirmedTransactionsBuffer := make(map[int]Transaction)
-			for id, transaction := range longestValidator.unconfirmedTransactions {
-				unconfirmedTransactionsBuffer[id] = transaction
-			}
-			confirmedTransactionsBuffer := make(map[int]bool)
-			for id, status := range longestValidator.confirmedTransactions {
-				confirmedTransactionsBuffer[id] = status
-			}
-			longestValidator.transactionPoolLock.Unlock()
-			validator.Blockchain = blockChainBuffer
-			validator.unconfirmedTransactions = unconfirmedTransactionsBuffer
-			validator.confirmedTransactions = confirmedTransactionsBuffer
+			publishReorg(validator, adoptChain(validator, CertifiedBlockchain))
 		}
+		resyncNonces()
+		settleBlockRewards()
+		skipCrashedForkProposer()
 		//slash fork proposer if there was a fork
 		if forked {
 			fmt.Printf("SLASHED FORK PROPOSER")
+			publishSlash(forkProposer, "proposed conflicting blocks")
 			if blockchainType == "slashing" {
 				forkProposer.Stake *= 0.2
 			}
@@ -423,50 +550,51 @@ func longestChainConsensus() {
 	longestLength := -1
 	var longestValidator *Validator = nil
 	for _, validator := range validators {
+		//a crashed validator's chain is stale, it syncs after it restarts
+		if crashed(validator) {
+			continue
+		}
 		if len(validator.Blockchain) > longestLength {
 			longestValidator = validator
 			longestLength = len(validator.Blockchain)
 		}
 	}
+	if longestValidator == nil {
+		fmt.Println("Longest chain consensus delayed, every validator is down")
+		return
+	}
 
-	CertifiedBlockchain = make([]Block, len(longestValidator.Blockchain))
-	copy(CertifiedBlockchain, longestValidator.Blockchain)
+	certify(longestValidator.Blockchain)
 
+	reorgs := make([]int, 0, len(validators))
 	for _, validator := range validators {
 		//broadcast the verified transactions to all blocks
-		if validator.Address == longestValidator.Address {
+		if validator.Address == longestValidator.Address || crashed(validator) {
 			continue
 		}
-		blockChainBuffer := make([]Block, len(CertifiedBlockchain))
-		copy(blockChainBuffer, CertifiedBlockchain)
-
-		longestValidator.transactionPoolLock.Lock()
-		unconfirmedTransactionsBuffer := make(map[int]Transaction)
-		for id, transaction := range longestValidator.unconfirmedTransactions {
-			unconfirmedTransactionsBuffer[id] = transaction
-		}
-
-		confirmedTransactionsBuffer := make(map[int]bool)
-		for id, status := range longestValidator.confirmedTransactions {
-			confirmedTransactionsBuffer[id] = status
-		}
-		longestValidator.transactionPoolLock.Unlock()
-
-		validator.Blockchain = blockChainBuffer
-		validator.unconfirmedTransactions = unconfirmedTransactionsBuffer
-		validator.confirmedTransactions = confirmedTransactionsBuffer
+		//revert this validator's state and mempool to the fork point and apply the winning branch,
+		//the longest one in its own group while the network is split
+		orphaned := adoptChain(validator, consensusChain(validator, CertifiedBlockchain))
+		reorgs = append(reorgs, orphaned)
+		publishReorg(validator, orphaned)
 	}
+	recordCheckpointReorgs(reorgs)
+	resyncNonces()
+	settleBlockRewards()
+	skipCrashedForkProposer()
 
 	//slash fork proposer if there was a fork
 	if forked {
 		if blockchainType == "pos" || blockchainType == "slashing" {
 			fmt.Printf("SLASHED FORK PROPOSER")
+			publishSlash(forkProposer, "proposed conflicting blocks")
 			if blockchainType == "slashing" {
 				forkProposer.Stake *= 0.2
 			}
 			forkProposer = nil
 		} else if blockchainType == "reputation" {
 			fmt.Printf("SLASHED FORK PROPOSER")
+			publishSlash(forkProposer, "proposed conflicting blocks")
 			forkProposer.reputation *= 0.2
 			forkProposer = nil
 		}
@@ -475,6 +603,17 @@ func longestChainConsensus() {
 
 	forked = false
 }
+
+// skipCrashedForkProposer drops the pending fork punishment when the fork proposer has gone down,
+// since a validator that is down is never slashed nor loses reputation
+func skipCrashedForkProposer() {
+	if forked && crashed(forkProposer) {
+		fmt.Printf("Fork proposer %s is down and is not slashed\n", forkProposer.Address[:3])
+		forkProposer = nil
+		forked = false
+	}
+}
+
 func balancePrintInfo() {
 	printString := ""
 	for _, block := range CertifiedBlockchain {
@@ -577,8 +716,11 @@ func printInfo() {
 }
 
 func balanceNextTimeSlot() {
-	time.Sleep(1 * time.Second)
+	clock.sleep(time.Second)
+	applyPartitionSchedule()
+	applyFaultSchedule()
 	fmt.Printf("\nTime slot %s\n\n", time.Now().Format("15:04:05"))
+	publishEvent(Event{Type: SlotStarted})
 	runConsensusCounter += 1
 
 	if runConsensusCounter >= 5 {
@@ -587,19 +729,24 @@ func balanceNextTimeSlot() {
 	}
 
 	//randomly choose new committee of a third of all validators who will validate the new block
-	validationCommittee = chooseValidationCommittee(validators, committeeSize)
+	committee := chooseValidationCommittee(validators, committeeSize)
+	validatorsSliceLock.Lock()
+	validationCommittee = committee
+	validatorsSliceLock.Unlock()
 	fmt.Println("New validation committee chosen")
+	publishCommittee(validationCommittee, "validation committee")
 	for _, commit := range validationCommittee {
 		commit.committeeCount += 1
 		// fmt.Println(commit.Address[:3])
 	}
 	//Choose a new block proposer based on stake
-	proposer = chooseBlockProposer()
+	setProposer(chooseBlockProposer())
 	if proposer == nil {
 		return
 	}
 	proposer.proposerCount += 1
 	fmt.Printf("Proposer %s chosen as new block proposer\n", proposer.Address[:3])
+	publishEvent(Event{Type: ProposerChosen, Validator: validatorName(proposer)})
 
 	//block proposer chooses a new block
 	newBlock, err := generateBlock(proposer)
@@ -626,6 +773,7 @@ func balanceNextTimeSlot() {
 	}
 
 	fmt.Printf("Block %d chosen as new block\n", newBlock.Index)
+	publishProposal(proposer, newBlock, Block{})
 
 	//validation committee validates blocks
 	//broadcast block to all members of committee
@@ -634,18 +782,30 @@ func balanceNextTimeSlot() {
 			newBlock: newBlock,
 			malVote:  malVote,
 		}
-		validator.incomingChannel <- msg
+		sendToValidator(validator, msg)
 	}
 
+	//votes that have not arrived by the deadline are not counted
+	deadline := voteDeadlineFromNow()
+
 	// Process validation results
 	validCount := 0
 	invalidCount := 0
+	votes := make([]CommitVote, 0)
 	validationResults := make(map[string]bool)
 	for _, validator := range validationCommittee {
-		msg := <-validator.outgoingChannel
+		msg := receiveVote(validator, newBlock.Hash, deadline)
+		if msg == nil {
+			fmt.Printf("Vote of %s missed the deadline\n", validator.Address[:3])
+			continue
+		}
+		publishVote(validator, msg, newBlock, Block{})
 		switch msg := msg.(type) { // Use type assertion to determine the type of the received message
 		case ValidationStatusMessage:
 			validationResults[validator.Address] = msg.isValid
+			if msg.isValid {
+				votes = append(votes, CommitVote{Validator: validator.Address, Signature: msg.signature})
+			}
 			if msg.isValid == true {
 				validCount++
 			} else {
@@ -657,13 +817,17 @@ func balanceNextTimeSlot() {
 		}
 	}
 
+	//committee signatures are stored with the block as its commit certificate
+	newBlock.Certificate = newCommitCertificate(newBlock.Hash, validationCommittee, votes)
+
 	// fmt.Printf("Voting results\nInvalid Count: %d\nValid Count: %d\nCommittee size: %d\n", invalidCount, validCount, len(validationCommittee))
 
 	//add block if majority believe block is valid
-	isValid := validCount > len(validationCommittee)/2
+	isValid := hasQuorum(validCount, len(validationCommittee))
 	if isValid {
 		// proposer.Blockchain = append(proposer.Blockchain, newBlock)
 		println("Valid block added to blockchain")
+		publishDecision(true, proposer, newBlock, validCount, validationCommittee)
 		proposer.blockSuccessCount += 1
 
 		//broadcast the verified transactions to all blocks
@@ -671,15 +835,14 @@ func balanceNextTimeSlot() {
 			transactions: newBlock.Transactions,
 			newBlock:     newBlock,
 		}
-		for _, validator := range validators {
-			validator.incomingChannel <- msg
-		}
+		broadcastVerifiedBlock(proposer, msg)
 
 		//Update transactional amounts and reward proposer
+		issueBlockReward(proposer, newBlock)
 		for _, transaction := range newBlock.Transactions {
 			transaction.Sender.Balance -= (transaction.Amount + transaction.Reward)
 			transaction.Receiver.Balance += transaction.Amount
-			proposer.Stake += transaction.Reward
+			creditReward(proposer, proposerFee(newBlock, transaction))
 
 			senderString := fmt.Sprintf("New balance: %f\n", transaction.Sender.Balance)
 			io.WriteString(transaction.Sender.conn, senderString)
@@ -689,28 +852,39 @@ func balanceNextTimeSlot() {
 		}
 	} else {
 		println("Committee votes block invalid")
+		publishDecision(false, proposer, newBlock, validCount, validationCommittee)
 		if blockchainType == "slashing" {
 			proposer.Stake *= 0.2
+			publishSlash(proposer, "proposed a block the committee rejected")
 		}
 	}
 	//punish validators who voted against the majority
 	slashPercentage := 0.2
 	for _, validator := range validationCommittee {
+		//validators whose vote never arrived are neither punished nor rewarded
+		if _, voted := validationResults[validator.Address]; !voted {
+			continue
+		}
 		if isValid {
 			if validationResults[validator.Address] == false {
 				if blockchainType == "slashing" {
 					validator.Stake *= slashPercentage
+					publishSlash(validator, "voted against the majority")
 				}
 			}
 		} else {
 			if validationResults[validator.Address] == true {
 				if blockchainType == "slashing" {
 					validator.Stake *= slashPercentage
+					publishSlash(validator, "voted against the majority")
 				}
 			}
 		}
 	}
 
+	//committee members who voted with the majority share the attestation rewards
+	rewardAttesters(validationCommittee, validationResults, isValid)
+
 	balancePrintInfo()
 }
 
@@ -730,41 +904,117 @@ func printEvaluation() {
 	}
 	fmt.Printf("Malicious blocks: %d\n", malBlockCount)
 	fmt.Printf("Transactions validated: %d\n", transactionCount)
+	voteSignaturesLock.Lock()
+	fmt.Printf("Votes with invalid signatures: %d\n", voteSignaturesRejected)
+	voteSignaturesLock.Unlock()
 	fmt.Printf("Time so far: %f\n", time.Now().Sub(startTime).Seconds())
+
+	if currAttack == "sybil" {
+		printSybilEvaluation()
+	}
+	if currAttack == "adaptive" {
+		printAdaptiveEvaluation()
+	}
+	if currAttack == "selfish_proposing" {
+		printSelfishEvaluation()
+	}
+	if currAttack == "double_spend" {
+		printDoubleSpendEvaluation()
+	}
+	if currAttack == "replay" {
+		printReplayEvaluation()
+	}
+	if len(lightClients) > 0 {
+		printLightClientEvaluation()
+	}
+	printFeeEvaluation()
+	printMempoolEvaluation()
+	printRewardEvaluation()
+	printConcentrationEvaluation()
+	printNetworkEvaluation()
+	printGossipEvaluation()
+	printPartitionEvaluation()
+	printFaultEvaluation()
+	printSyncEvaluation()
+	printEventEvaluation()
+	exportBlockTree()
+	exportConcentration()
 }
 
 func nextTimeSlot() {
 
 	//wait 5 seconds every slot
-	time.Sleep(1 * time.Second)
+	clock.sleep(time.Second)
+	applyPartitionSchedule()
+	applyFaultSchedule()
 
 	if len(validators) == 0 {
 		return
 	}
 
 	fmt.Printf("\nTime slot %s\n\n", time.Now().Format("15:04:05"))
+	publishEvent(Event{Type: SlotStarted})
 
 	runConsensusCounter += 1
 
 	if runConsensusCounter >= 5 {
+		if currAttack == "selfish_proposing" {
+			selfishRelease()
+		}
+		if currAttack == "replay" {
+			captureChainsBeforeConsensus()
+		}
 		longestChainConsensus()
+		if currAttack == "selfish_proposing" {
+			settleSelfishRelease()
+		}
+		if currAttack == "double_spend" {
+			settleDoubleSpends()
+		}
+		if currAttack == "replay" {
+			replayAfterReorg()
+		}
+		syncLightClients()
 		runConsensusCounter = 0
 	}
+	if currAttack == "double_spend" {
+		observeDoubleSpends()
+	}
 
 	//randomly choose new committee of a third of all validators who will validate the new block
-	validationCommittee = chooseValidationCommittee(validators, committeeSize)
+	committee := chooseValidationCommittee(validators, committeeSize)
+	validatorsSliceLock.Lock()
+	validationCommittee = committee
+	validatorsSliceLock.Unlock()
 	fmt.Println("New validation committee chosen")
+	publishCommittee(validationCommittee, "validation committee")
 	for _, commit := range validationCommittee {
 		commit.committeeCount += 1
 		// fmt.Println(commit.Address[:3])
 	}
+	if currAttack == "sybil" {
+		recordSybilCommittee(validationCommittee)
+	}
+	//adaptive adversary sees the committee before it votes
+	if currAttack == "adaptive" {
+		adaptiveCorrupt(validationCommittee)
+		applyPendingCorruptions(validationCommittee)
+	}
 	//Choose a new block proposer based on stake
-	proposer = chooseBlockProposer()
+	setProposer(chooseBlockProposer())
 	if proposer == nil {
 		return
 	}
 	proposer.proposerCount += 1
 	fmt.Printf("Proposer %s chosen as new block proposer\n", proposer.Address[:3])
+	publishEvent(Event{Type: ProposerChosen, Validator: validatorName(proposer)})
+
+	//colluding proposers withhold their blocks
+	if currAttack == "selfish_proposing" && proposer.IsMalicious {
+		selfishPropose(proposer)
+		printInfo()
+		return
+	}
 
 	//block proposer chooses a new block
 
@@ -775,7 +1025,13 @@ func nextTimeSlot() {
 	}
 
 	// oldBlock := Blockchain[len(Blockchain)-1]
-	newBlock, err := generateBlock(proposer)
+	var newBlock Block
+	var err error
+	if currAttack == "light_client" && proposer.IsMalicious {
+		newBlock, err = forgeLightClientBlock(proposer)
+	} else {
+		newBlock, err = generateBlock(proposer)
+	}
 	if err != nil {
 		fmt.Println(err.Error())
 		return
@@ -788,7 +1044,7 @@ func nextTimeSlot() {
 	}
 
 	var newBlockTwo Block
-	if currAttack == "network_partition" && evilProposer {
+	if partitionAttack() && evilProposer {
 		println("EVIL PROPOSER DOING WORK")
 		newBlockTwo, err = generateBlock(proposer)
 		if err != nil {
@@ -800,38 +1056,52 @@ func nextTimeSlot() {
 	}
 
 	fmt.Printf("Block %d chosen as new block\n", newBlock.Index)
+	publishProposal(proposer, newBlock, newBlockTwo)
 
 	//validation committee validates blocks
 	//broadcast block to all members of committee
 	for _, validator := range validationCommittee {
-		if currAttack == "network_partition" && evilProposer && !forked {
+		if partitionAttack() && evilProposer && !forked {
 			if evilProposer {
 				msg := ValidateShortAttackBlockMessage{
 					newBlock:    newBlock,
 					newBlockTwo: newBlockTwo,
 				}
-				validator.incomingChannel <- msg
+				sendToValidator(validator, msg)
 			}
 		} else {
 			msg := ValidateBlockMessage{
 				newBlock: newBlock,
 			}
-			validator.incomingChannel <- msg
+			sendToValidator(validator, msg)
 		}
 	}
 
+	//votes that have not arrived by the deadline are not counted
+	deadline := voteDeadlineFromNow()
+
 	// Process validation results
 	validCount := 0
 	invalidCount := 0
 	validTwoCount := 0
 	invalidTwoCount := 0
+	votes := make([]CommitVote, 0)
+	votesTwo := make([]CommitVote, 0)
 	validationResults := make(map[string]bool)
 	// validationResultsTwo := make(map[string]bool)
 	for _, validator := range validationCommittee {
-		msg := <-validator.outgoingChannel
+		msg := receiveVote(validator, newBlock.Hash, deadline)
+		if msg == nil {
+			fmt.Printf("Vote of %s missed the deadline\n", validator.Address[:3])
+			continue
+		}
+		publishVote(validator, msg, newBlock, newBlockTwo)
 		switch msg := msg.(type) { // Use type assertion to determine the type of the received message
 		case ValidationStatusMessage:
 			validationResults[validator.Address] = msg.isValid
+			if msg.isValid {
+				votes = append(votes, CommitVote{Validator: validator.Address, Signature: msg.signature})
+			}
 			if msg.isValid == true {
 				validCount++
 			} else {
@@ -840,6 +1110,12 @@ func nextTimeSlot() {
 		case ValidationShortAttackStatusMessage:
 			validationResults[validator.Address] = msg.isValid
 			validationResults[validator.Address] = msg.isValidTwo
+			if msg.isValid {
+				votes = append(votes, CommitVote{Validator: validator.Address, Signature: msg.signature})
+			}
+			if msg.isValidTwo {
+				votesTwo = append(votesTwo, CommitVote{Validator: validator.Address, Signature: msg.signatureTwo})
+			}
 			if msg.isValid == true {
 				validCount++
 			} else {
@@ -856,7 +1132,11 @@ func nextTimeSlot() {
 		}
 	}
 
-	if currAttack == "network_partition" && (forked || evilProposer) {
+	//committee signatures are stored with the block as its commit certificate
+	newBlock.Certificate = newCommitCertificate(newBlock.Hash, validationCommittee, votes)
+	newBlockTwo.Certificate = newCommitCertificate(newBlockTwo.Hash, validationCommittee, votesTwo)
+
+	if partitionAttack() && (forked || evilProposer) {
 		// fmt.Printf("Voting results\nInvalid Count: %d\nValid Count: %d\nInvalid Two Count: %d\nValid Two Count: %d\nCommittee size: %d\n", invalidCount, validCount, invalidTwoCount, validTwoCount, len(validationCommittee))
 	} else {
 		// fmt.Printf("Voting results\nInvalid Count: %d\nValid Count: %d\nCommittee size: %d\n", invalidCount, validCount, len(validationCommittee))
@@ -865,24 +1145,26 @@ func nextTimeSlot() {
 	//chain is forked
 	if forked {
 		println("Chain is forked")
-		isValid := validCount >= len(validationCommittee)/2
+		isValid := hasQuorum(validCount, len(validationCommittee))
+		if currAttack == "adaptive" {
+			recordAdaptiveRound(validationCommittee, newBlock, isValid)
+		}
 		if isValid {
 			//broadcast the verified transactions to only right branch-- branch with proposer
-			for _, validator := range validators {
-				if slices.Contains(ForkedBlockchain[proposerGroup], validator) {
-					msg := VerifiedShortAttackBlockMessage{
-						transactions: newBlock.Transactions,
-						newBlock:     newBlock,
-					}
-					validator.incomingChannel <- msg
-				}
+			side := forkSide(proposerGroup, true)
+			msg := VerifiedShortAttackBlockMessage{
+				transactions: newBlock.Transactions,
+				newBlock:     newBlock,
+				side:         side,
 			}
+			broadcastToSide(proposer, side, newBlock, msg)
 
 			//Update transactional amounts and reward proposer
+			issueBlockReward(proposer, newBlock)
 			for _, transaction := range newBlock.Transactions {
 				transaction.Sender.Balance -= (transaction.Amount + transaction.Reward)
 				transaction.Receiver.Balance += transaction.Amount
-				proposer.Stake += transaction.Reward
+				creditReward(proposer, proposerFee(newBlock, transaction))
 
 				senderString := fmt.Sprintf("New balance: %f\n", transaction.Sender.Balance)
 				io.WriteString(transaction.Sender.conn, senderString)
@@ -891,54 +1173,68 @@ func nextTimeSlot() {
 				io.WriteString(transaction.Receiver.conn, receiverString)
 			}
 			println("Valid block added to blockchain")
+			publishDecision(true, proposer, newBlock, validCount, validationCommittee)
 		} else {
 			println("Committee votes block invalid")
+			publishDecision(false, proposer, newBlock, validCount, validationCommittee)
 			if blockchainType == "slashing" {
 				proposer.Stake *= 0.2
+				publishSlash(proposer, "proposed a block the committee rejected")
 			}
 		}
 
 		if blockchainType == "slashing" {
 			slashPercentage := 0.2
 			for _, validator := range validationCommittee {
+				//validators whose vote never arrived are neither punished nor rewarded
+				if _, voted := validationResults[validator.Address]; !voted {
+					continue
+				}
 				if isValid {
 					if validationResults[validator.Address] == false {
 						validator.Stake *= slashPercentage
+						publishSlash(validator, "voted against the majority")
 					}
 				} else {
 					if validationResults[validator.Address] == true {
 						validator.Stake *= slashPercentage
+						publishSlash(validator, "voted against the majority")
 					}
 				}
 			}
 		}
 
+		//committee members who voted with the majority share the attestation rewards
+		rewardAttesters(validationCommittee, validationResults, isValid)
+
 		printInfo()
 		return
 	}
 
 	//short range attack
-	if currAttack == "network_partition" && evilProposer {
-		isValid := validCount >= len(validationCommittee)/2
-		isValidTwo := validTwoCount >= len(validationCommittee)/2
+	if partitionAttack() && evilProposer {
+		isValid := hasQuorum(validCount, len(validationCommittee))
+		isValidTwo := hasQuorum(validTwoCount, len(validationCommittee))
+		if currAttack == "adaptive" {
+			recordAdaptiveRound(validationCommittee, newBlock, isValid)
+		}
 
 		if isValid {
 			//broadcast the verified transactions to all blocks within proposer's group
-			for _, validator := range validators {
-				if slices.Contains(ForkedBlockchain[proposerGroup], validator) {
-					msg := VerifiedShortAttackBlockMessage{
-						transactions: newBlock.Transactions,
-						newBlock:     newBlock,
-					}
-					validator.incomingChannel <- msg
-				}
+			side := forkSide(proposerGroup, true)
+			msg := VerifiedShortAttackBlockMessage{
+				transactions: newBlock.Transactions,
+				newBlock:     newBlock,
+				side:         side,
 			}
+			broadcastToSide(proposer, side, newBlock, msg)
 
 			//Update transactional amounts and reward proposer
+			issueBlockReward(proposer, newBlock)
 			for _, transaction := range newBlock.Transactions {
 				transaction.Sender.Balance -= (transaction.Amount + transaction.Reward)
 				transaction.Receiver.Balance += transaction.Amount
-				proposer.Stake += transaction.Reward
+				creditReward(proposer, proposerFee(newBlock, transaction))
 
 				senderString := fmt.Sprintf("New balance: %f\n", transaction.Sender.Balance)
 				io.WriteString(transaction.Sender.conn, senderString)
@@ -947,29 +1243,31 @@ func nextTimeSlot() {
 				io.WriteString(transaction.Receiver.conn, receiverString)
 			}
 			println("Valid block added to blockchain")
+			publishDecision(true, proposer, newBlock, validCount, validationCommittee)
 		} else {
 			println("Committee votes block invalid")
+			publishDecision(false, proposer, newBlock, validCount, validationCommittee)
 			if blockchainType == "slashing" {
 				proposer.Stake *= 0.2
+				publishSlash(proposer, "proposed a block the committee rejected")
 			}
 		}
 		if isValidTwo {
 			//broadcast the verified transactions to all blocks not witihin proposer's group
-			for _, validator := range validators {
-				if !slices.Contains(ForkedBlockchain[proposerGroup], validator) {
-					msg := VerifiedShortAttackBlockTwoMessage{
-						transactions: newBlockTwo.Transactions,
-						newBlockTwo:  newBlockTwo,
-					}
-					validator.incomingChannel <- msg
-				}
+			side := forkSide(proposerGroup, false)
+			msg := VerifiedShortAttackBlockTwoMessage{
+				transactions: newBlockTwo.Transactions,
+				newBlockTwo:  newBlockTwo,
+				side:         side,
 			}
+			broadcastToSide(proposer, side, newBlockTwo, msg)
 
 			//Update transactional amounts and reward proposer
+			issueBlockReward(proposer, newBlockTwo)
 			for _, transaction := range newBlockTwo.Transactions {
 				transaction.Sender.Balance -= (transaction.Amount + transaction.Reward)
 				transaction.Receiver.Balance += transaction.Amount
-				proposer.Stake += transaction.Reward
+				creditReward(proposer, proposerFee(newBlockTwo, transaction))
 
 				senderString := fmt.Sprintf("New balance: %f\n", transaction.Sender.Balance)
 				io.WriteString(transaction.Sender.conn, senderString)
@@ -978,15 +1276,20 @@ func nextTimeSlot() {
 				io.WriteString(transaction.Receiver.conn, receiverString)
 			}
 			println("Valid block added to blockchain")
+			publishDecision(true, proposer, newBlockTwo, validTwoCount, validationCommittee)
 		} else {
 			println("Committee votes block invalid")
+			publishDecision(false, proposer, newBlockTwo, validTwoCount, validationCommittee)
 			if blockchainType == "slashing" {
 				proposer.Stake *= 0.2
+				publishSlash(proposer, "proposed a block the committee rejected")
 			}
 		}
 		if isValid && isValidTwo {
 			forked = true
+			forkCount++
 			forkProposer = proposer
+			publishFork(proposer, newBlock, newBlockTwo)
 		}
 		//punish validators who voted against the majority
 		// slashPercentage := 0.2
@@ -1015,10 +1318,15 @@ func nextTimeSlot() {
 		return
 	}
 
-	isValid := validCount >= len(validationCommittee)/2
+	isValid := hasQuorum(validCount, len(validationCommittee))
+	if currAttack == "adaptive" {
+		recordAdaptiveRound(validationCommittee, newBlock, isValid)
+	}
 	if isValid {
+		publishHeader(newBlock)
 		// proposer.Blockchain = append(proposer.Blockchain, newBlock)
 		println("Valid block added to blockchain")
+		publishDecision(true, proposer, newBlock, validCount, validationCommittee)
 		proposer.blockSuccessCount += 1
 
 		//broadcast the verified transactions to all blocks
@@ -1026,15 +1334,14 @@ func nextTimeSlot() {
 			transactions: newBlock.Transactions,
 			newBlock:     newBlock,
 		}
-		for _, validator := range validators {
-			validator.incomingChannel <- msg
-		}
+		broadcastVerifiedBlock(proposer, msg)
 
 		//Update transactional amounts and reward proposer
+		issueBlockReward(proposer, newBlock)
 		for _, transaction := range newBlock.Transactions {
 			transaction.Sender.Balance -= (transaction.Amount + transaction.Reward)
 			transaction.Receiver.Balance += transaction.Amount
-			proposer.Stake += transaction.Reward
+			creditReward(proposer, proposerFee(newBlock, transaction))
 
 			senderString := fmt.Sprintf("New balance: %f\n", transaction.Sender.Balance)
 			io.WriteString(transaction.Sender.conn, senderString)
@@ -1044,18 +1351,25 @@ func nextTimeSlot() {
 		}
 	} else {
 		println("Committee votes block invalid")
+		publishDecision(false, proposer, newBlock, validCount, validationCommittee)
 		if blockchainType == "slashing" {
 			proposer.Stake *= 0.2
+			publishSlash(proposer, "proposed a block the committee rejected")
 		}
 	}
 	//punish validators who voted against the majority
 	slashPercentage := 0.2
 	for _, validator := range validationCommittee {
+		//validators whose vote never arrived are neither punished nor rewarded
+		if _, voted := validationResults[validator.Address]; !voted {
+			continue
+		}
 		if isValid {
 			if validationResults[validator.Address] == false {
 				println("VALIDATED FALSE WHEN IT WAS TRUE")
 				if blockchainType == "slashing" {
 					validator.Stake *= slashPercentage
+					publishSlash(validator, "voted against the majority")
 				}
 			}
 		} else {
@@ -1063,17 +1377,24 @@ func nextTimeSlot() {
 				println("VALIDATED TRUE WHEN IT WAS FALSE")
 				if blockchainType == "slashing" {
 					validator.Stake *= slashPercentage
+					publishSlash(validator, "voted against the majority")
 				}
 			}
 		}
 	}
+	//committee members who voted with the majority share the attestation rewards
+	rewardAttesters(validationCommittee, validationResults, isValid)
+
 	printInfo()
 }
 
 func balanceReputationNextTimeSlot() {
 	//wait 5 seconds every slot
-	time.Sleep(1 * time.Second)
+	clock.sleep(time.Second)
+	applyPartitionSchedule()
+	applyFaultSchedule()
 	fmt.Printf("\nTime slot %s\n\n", time.Now().Format("15:04:05"))
+	publishEvent(Event{Type: SlotStarted})
 	runConsensusCounter += 1
 
 	if runConsensusCounter >= 5 {
@@ -1084,15 +1405,26 @@ func balanceReputationNextTimeSlot() {
 	//Choose new delegates
 	if delegateCounter == 2*delegateSize {
 		delegateCounter = 0
-		delegates = chooseDelegates(validators, delegateSize)
+		chosen := chooseDelegates(validators, delegateSize)
+		validatorsSliceLock.Lock()
+		delegates = chosen
+		validatorsSliceLock.Unlock()
 		fmt.Println("New delegates chosen")
+		publishCommittee(delegates, "delegates")
 	}
 
 	//Choose next sequential block proposer from delegates
-	proposer = delegates[delegateCounter%delegateSize]
+	setProposer(delegates[delegateCounter%delegateSize])
 	delegateCounter += 1
+	//a delegate that crashed since it was chosen misses its slot
+	if crashed(proposer) {
+		fmt.Printf("Proposer %s is down, slot missed\n", proposer.Address[:3])
+		setProposer(nil)
+		return
+	}
 	proposer.proposerCount += 1
 	fmt.Printf("Proposer %s chosen as new block proposer\n", proposer.Address[:3])
+	publishEvent(Event{Type: ProposerChosen, Validator: validatorName(proposer)})
 
 	// find length of the shorter fork
 	validatorsSliceLock.Lock()
@@ -1113,6 +1445,7 @@ func balanceReputationNextTimeSlot() {
 	}
 
 	fmt.Printf("Block %d chosen as new block\n", newBlock.Index)
+	publishProposal(proposer, newBlock, Block{})
 
 	//let malicious validators know if they should vote for/against block to balance
 	malVote := false
@@ -1127,18 +1460,30 @@ func balanceReputationNextTimeSlot() {
 			newBlock: newBlock,
 			malVote:  malVote,
 		}
-		validator.incomingChannel <- msg
+		sendToValidator(validator, msg)
 	}
 
+	//votes that have not arrived by the deadline are not counted
+	deadline := voteDeadlineFromNow()
+
 	// Process validation results
 	validCount := 0
 	invalidCount := 0
+	votes := make([]CommitVote, 0)
 	validationResults := make(map[string]bool)
 	for _, validator := range delegates {
-		msg := <-validator.outgoingChannel
+		msg := receiveVote(validator, newBlock.Hash, deadline)
+		if msg == nil {
+			fmt.Printf("Vote of %s missed the deadline\n", validator.Address[:3])
+			continue
+		}
+		publishVote(validator, msg, newBlock, Block{})
 		switch msg := msg.(type) { // Use type assertion to determine the type of the received message
 		case ValidationStatusMessage:
 			validationResults[validator.Address] = msg.isValid
+			if msg.isValid {
+				votes = append(votes, CommitVote{Validator: validator.Address, Signature: msg.signature})
+			}
 			if msg.isValid == true {
 				validCount++
 			} else {
@@ -1150,12 +1495,16 @@ func balanceReputationNextTimeSlot() {
 		}
 	}
 
+	//committee signatures are stored with the block as its commit certificate
+	newBlock.Certificate = newCommitCertificate(newBlock.Hash, delegates, votes)
+
 	// fmt.Printf("Voting results\nInvalid Count: %d\nValid Count: %d\nCommittee size: %d\n", invalidCount, validCount, len(delegates))
 
 	//add block if majority believe block is valid
-	isValid := validCount > len(delegates)/2
+	isValid := hasQuorum(validCount, len(delegates))
 	if isValid {
 		println("Valid block added to blockchain")
+		publishDecision(true, proposer, newBlock, validCount, delegates)
 		proposer.blockSuccessCount += 1
 		proposer.reputation = math.Min(100, proposer.reputation+1)
 		//broadcast the verified transactions to all blocks
@@ -1163,15 +1512,14 @@ func balanceReputationNextTimeSlot() {
 			transactions: newBlock.Transactions,
 			newBlock:     newBlock,
 		}
-		for _, validator := range validators {
-			validator.incomingChannel <- msg
-		}
+		broadcastVerifiedBlock(proposer, msg)
 
 		//Update transactional amounts and reward proposer
+		issueBlockReward(proposer, newBlock)
 		for _, transaction := range newBlock.Transactions {
 			transaction.Sender.Balance -= (transaction.Amount + transaction.Reward)
 			transaction.Receiver.Balance += transaction.Amount
-			proposer.Stake += transaction.Reward
+			creditReward(proposer, proposerFee(newBlock, transaction))
 
 			senderString := fmt.Sprintf("New balance: %f\n", transaction.Sender.Balance)
 			io.WriteString(transaction.Sender.conn, senderString)
@@ -1181,14 +1529,21 @@ func balanceReputationNextTimeSlot() {
 		}
 	} else {
 		println("Committee votes block invalid")
+		publishDecision(false, proposer, newBlock, validCount, delegates)
 		proposer.reputation *= 0.2
+		publishSlash(proposer, "proposed a block the committee rejected")
 	}
 	//punish validators who voted against the majority
 	for _, validator := range delegates {
+		//validators whose vote never arrived are neither punished nor rewarded
+		if _, voted := validationResults[validator.Address]; !voted {
+			continue
+		}
 		if isValid {
 			//Block was valid, but voted invalid
 			if validationResults[validator.Address] == false {
 				validator.reputation *= 0.5
+				publishSlash(validator, "voted against the majority")
 			} else {
 				validator.reputation = math.Min(100, 1+validator.reputation)
 			}
@@ -1196,39 +1551,92 @@ func balanceReputationNextTimeSlot() {
 			//Block invalid, but voted valid
 			if validationResults[validator.Address] == true {
 				validator.reputation *= 0.5
+				publishSlash(validator, "voted against the majority")
 			} else {
 				validator.reputation = math.Min(100, 1+validator.reputation)
 			}
 		}
 	}
 
+	//committee members who voted with the majority share the attestation rewards
+	rewardAttesters(delegates, validationResults, isValid)
+
 	balancePrintInfo()
 
 }
 
 func nextReputationTimeSlot() {
 	//wait 5 seconds every slot
-	time.Sleep(1 * time.Second)
+	clock.sleep(time.Second)
+	applyPartitionSchedule()
+	applyFaultSchedule()
 	fmt.Printf("\nTime slot %s\n\n", time.Now().Format("15:04:05"))
+	publishEvent(Event{Type: SlotStarted})
 	runConsensusCounter += 1
 
 	if runConsensusCounter >= 5 {
+		if currAttack == "selfish_proposing" {
+			selfishRelease()
+		}
+		if currAttack == "replay" {
+			captureChainsBeforeConsensus()
+		}
 		longestChainConsensus()
+		if currAttack == "selfish_proposing" {
+			settleSelfishRelease()
+		}
+		if currAttack == "double_spend" {
+			settleDoubleSpends()
+		}
+		if currAttack == "replay" {
+			replayAfterReorg()
+		}
+		syncLightClients()
 		runConsensusCounter = 0
 	}
+	if currAttack == "double_spend" {
+		observeDoubleSpends()
+	}
 
 	//Choose new delegates
 	if delegateCounter == 2*delegateSize {
 		delegateCounter = 0
-		delegates = chooseDelegates(validators, delegateSize)
+		chosen := chooseDelegates(validators, delegateSize)
+		validatorsSliceLock.Lock()
+		delegates = chosen
+		validatorsSliceLock.Unlock()
 		fmt.Println("New delegates chosen")
+		publishCommittee(delegates, "delegates")
+		if currAttack == "sybil" {
+			recordSybilDelegates(delegates)
+		}
+		if currAttack == "adaptive" {
+			adaptiveCorrupt(delegates)
+		}
+	}
+	if currAttack == "adaptive" {
+		applyPendingCorruptions(delegates)
 	}
 
 	//Choose next sequential block proposer from delegates
-	proposer = delegates[delegateCounter%delegateSize]
+	setProposer(delegates[delegateCounter%delegateSize])
 	delegateCounter += 1
+	//a delegate that crashed since it was chosen misses its slot
+	if crashed(proposer) {
+		fmt.Printf("Proposer %s is down, slot missed\n", proposer.Address[:3])
+		setProposer(nil)
+		return
+	}
 	proposer.proposerCount += 1
 	fmt.Printf("Proposer %s chosen as new block proposer\n", proposer.Address[:3])
+	publishEvent(Event{Type: ProposerChosen, Validator: validatorName(proposer)})
+
+	//colluding proposers withhold their blocks
+	if currAttack == "selfish_proposing" && proposer.IsMalicious {
+		selfishPropose(proposer)
+		printInfo()
+		return
+	}
 
 	//block proposer chooses a new block
 
@@ -1238,7 +1646,13 @@ func nextReputationTimeSlot() {
 		proposerGroup = 0
 	}
 
-	newBlock, err := generateBlock(proposer)
+	var newBlock Block
+	var err error
+	if currAttack == "light_client" && proposer.IsMalicious {
+		newBlock, err = forgeLightClientBlock(proposer)
+	} else {
+		newBlock, err = generateBlock(proposer)
+	}
 	if err != nil {
 		fmt.Println(err.Error())
 		return
@@ -1251,7 +1665,7 @@ func nextReputationTimeSlot() {
 	}
 
 	var newBlockTwo Block
-	if currAttack == "network_partition" && evilProposer {
+	if partitionAttack() && evilProposer {
 		println("EVIL PROPOSER DOING WORK")
 		newBlockTwo, err = generateBlock(proposer)
 		if err != nil {
@@ -1263,37 +1677,51 @@ func nextReputationTimeSlot() {
 	}
 
 	fmt.Printf("Block %d chosen as new block\n", newBlock.Index)
+	publishProposal(proposer, newBlock, newBlockTwo)
 
 	//validation committee validates blocks
 	//broadcast block to all members of committee
 	for _, validator := range delegates {
-		if currAttack == "network_partition" && evilProposer && !forked {
+		if partitionAttack() && evilProposer && !forked {
 			if evilProposer {
 				msg := ValidateShortAttackBlockMessage{
 					newBlock:    newBlock,
 					newBlockTwo: newBlockTwo,
 				}
-				validator.incomingChannel <- msg
+				sendToValidator(validator, msg)
 			}
 		} else {
 			msg := ValidateBlockMessage{
 				newBlock: newBlock,
 			}
-			validator.incomingChannel <- msg
+			sendToValidator(validator, msg)
 		}
 	}
 
+	//votes that have not arrived by the deadline are not counted
+	deadline := voteDeadlineFromNow()
+
 	// Process validation results
 	validCount := 0
 	invalidCount := 0
 	validTwoCount := 0
 	invalidTwoCount := 0
+	votes := make([]CommitVote, 0)
+	votesTwo := make([]CommitVote, 0)
 	validationResults := make(map[string]bool)
 	for _, validator := range delegates {
-		msg := <-validator.outgoingChannel
+		msg := receiveVote(validator, newBlock.Hash, deadline)
+		if msg == nil {
+			fmt.Printf("Vote of %s missed the deadline\n", validator.Address[:3])
+			continue
+		}
+		publishVote(validator, msg, newBlock, newBlockTwo)
 		switch msg := msg.(type) { // Use type assertion to determine the type of the received message
 		case ValidationStatusMessage:
 			validationResults[validator.Address] = msg.isValid
+			if msg.isValid {
+				votes = append(votes, CommitVote{Validator: validator.Address, Signature: msg.signature})
+			}
 			if msg.isValid == true {
 				validCount++
 			} else {
@@ -1302,6 +1730,12 @@ func nextReputationTimeSlot() {
 		case ValidationShortAttackStatusMessage:
 			validationResults[validator.Address] = msg.isValid
 			validationResults[validator.Address] = msg.isValidTwo
+			if msg.isValid {
+				votes = append(votes, CommitVote{Validator: validator.Address, Signature: msg.signature})
+			}
+			if msg.isValidTwo {
+				votesTwo = append(votesTwo, CommitVote{Validator: validator.Address, Signature: msg.signatureTwo})
+			}
 			if msg.isValid == true {
 				validCount++
 			} else {
@@ -1317,8 +1751,12 @@ func nextReputationTimeSlot() {
 			fmt.Printf("%T\n", msg)
 		}
 	}
+
+	//committee signatures are stored with the block as its commit certificate
+	newBlock.Certificate = newCommitCertificate(newBlock.Hash, delegates, votes)
+	newBlockTwo.Certificate = newCommitCertificate(newBlockTwo.Hash, delegates, votesTwo)
 	// fmt.Printf("Voting results\nInvalid Count: %d\nValid Count: %d\nCommittee size: %d\n", invalidCount, validCount, len(validationCommittee))
-	if currAttack == "network_partition" && (forked || evilProposer) {
+	if partitionAttack() && (forked || evilProposer) {
 		// fmt.Printf("Voting results\nInvalid Count: %d\nValid Count: %d\nInvalid Two Count: %d\nValid Two Count: %d\nCommittee size: %d\n", invalidCount, validCount, invalidTwoCount, validTwoCount, len(delegates))
 	} else {
 		// fmt.Printf("Voting results\nInvalid Count: %d\nValid Count: %d\nCommittee size: %d\n", invalidCount, validCount, len(delegates))
@@ -1329,27 +1767,30 @@ func nextReputationTimeSlot() {
 		println("Chain is forked")
 
 		//add block if majority believe block is valid
-		isValid := validCount >= len(delegates)/2
+		isValid := hasQuorum(validCount, len(delegates))
+		if currAttack == "adaptive" {
+			recordAdaptiveRound(delegates, newBlock, isValid)
+		}
 		if isValid {
 			println("Valid block added to blockchain")
+			publishDecision(true, proposer, newBlock, validCount, delegates)
 			proposer.blockSuccessCount += 1
 			proposer.reputation = math.Min(100, proposer.reputation+1)
 			//broadcast the verified transactions to all blocks
-			for _, validator := range validators {
-				if slices.Contains(ForkedBlockchain[proposerGroup], validator) {
-					msg := VerifiedShortAttackBlockMessage{
-						transactions: newBlock.Transactions,
-						newBlock:     newBlock,
-					}
-					validator.incomingChannel <- msg
-				}
+			side := forkSide(proposerGroup, true)
+			msg := VerifiedShortAttackBlockMessage{
+				transactions: newBlock.Transactions,
+				newBlock:     newBlock,
+				side:         side,
 			}
+			broadcastToSide(proposer, side, newBlock, msg)
 
 			//Update transactional amounts and reward proposer
+			issueBlockReward(proposer, newBlock)
 			for _, transaction := range newBlock.Transactions {
 				transaction.Sender.Balance -= (transaction.Amount + transaction.Reward)
 				transaction.Receiver.Balance += transaction.Amount
-				proposer.Stake += transaction.Reward
+				creditReward(proposer, proposerFee(newBlock, transaction))
 
 				senderString := fmt.Sprintf("New balance: %f\n", transaction.Sender.Balance)
 				io.WriteString(transaction.Sender.conn, senderString)
@@ -1359,14 +1800,21 @@ func nextReputationTimeSlot() {
 			}
 		} else {
 			println("Committee votes block invalid")
+			publishDecision(false, proposer, newBlock, validCount, delegates)
 			proposer.reputation *= 0.2
+			publishSlash(proposer, "proposed a block the committee rejected")
 		}
 		//punish validators who voted against the majority
 		for _, validator := range delegates {
+			//validators whose vote never arrived are neither punished nor rewarded
+			if _, voted := validationResults[validator.Address]; !voted {
+				continue
+			}
 			if isValid {
 				//Block was valid, but voted invalid
 				if validationResults[validator.Address] == false {
 					validator.reputation *= 0.5
+					publishSlash(validator, "voted against the majority")
 				} else {
 					validator.reputation = math.Min(100, 1+validator.reputation)
 				}
@@ -1374,42 +1822,49 @@ func nextReputationTimeSlot() {
 				//Block invalid, but voted valid
 				if validationResults[validator.Address] == true {
 					validator.reputation *= 0.5
+					publishSlash(validator, "voted against the majority")
 				} else {
 					validator.reputation = math.Min(100, 1+validator.reputation)
 				}
 			}
 		}
 
+		//committee members who voted with the majority share the attestation rewards
+		rewardAttesters(delegates, validationResults, isValid)
+
 		printInfo()
 		return
 	}
 
 	//short range attack
-	if currAttack == "network_partition" && evilProposer {
-		isValid := validCount >= len(delegates)/2
-		isValidTwo := validTwoCount >= len(delegates)/2
+	if partitionAttack() && evilProposer {
+		isValid := hasQuorum(validCount, len(delegates))
+		isValidTwo := hasQuorum(validTwoCount, len(delegates))
+		if currAttack == "adaptive" {
+			recordAdaptiveRound(delegates, newBlock, isValid)
+		}
 
 		if isValid {
 			//broadcast the verified transactions to all blocks within proposer's group
 			println("Valid block added to blockchain")
+			publishDecision(true, proposer, newBlock, validCount, delegates)
 			proposer.blockSuccessCount += 1
 			proposer.reputation = math.Min(100, proposer.reputation+1)
 			//broadcast the verified transactions to all blocks
-			for _, validator := range validators {
-				if slices.Contains(ForkedBlockchain[proposerGroup], validator) {
-					msg := VerifiedBlockMessage{
-						transactions: newBlock.Transactions,
-						newBlock:     newBlock,
-					}
-					validator.incomingChannel <- msg
-				}
+			side := forkSide(proposerGroup, true)
+			msg := VerifiedBlockMessage{
+				transactions: newBlock.Transactions,
+				newBlock:     newBlock,
+				side:         side,
 			}
+			broadcastToSide(proposer, side, newBlock, msg)
 
 			//Update transactional amounts and reward proposer
+			issueBlockReward(proposer, newBlock)
 			for _, transaction := range newBlock.Transactions {
 				transaction.Sender.Balance -= (transaction.Amount + transaction.Reward)
 				transaction.Receiver.Balance += transaction.Amount
-				proposer.Stake += transaction.Reward
+				creditReward(proposer, proposerFee(newBlock, transaction))
 
 				senderString := fmt.Sprintf("New balance: %f\n", transaction.Sender.Balance)
 				io.WriteString(transaction.Sender.conn, senderString)
@@ -1419,28 +1874,30 @@ func nextReputationTimeSlot() {
 			}
 		} else {
 			println("Committee votes block invalid")
+			publishDecision(false, proposer, newBlock, validCount, delegates)
 			proposer.reputation *= 0.2
+			publishSlash(proposer, "proposed a block the committee rejected")
 		}
 		if isValidTwo {
 			println("Valid block added to blockchain")
+			publishDecision(true, proposer, newBlockTwo, validTwoCount, delegates)
 			proposer.blockSuccessCount += 1
 			proposer.reputation = math.Min(100, proposer.reputation+1)
 			//broadcast the verified transactions to all blocks not witihin proposer's group
-			for _, validator := range validators {
-				if !slices.Contains(ForkedBlockchain[proposerGroup], validator) {
-					msg := VerifiedShortAttackBlockTwoMessage{
-						transactions: newBlockTwo.Transactions,
-						newBlockTwo:  newBlockTwo,
-					}
-					validator.incomingChannel <- msg
-				}
+			side := forkSide(proposerGroup, false)
+			msg := VerifiedShortAttackBlockTwoMessage{
+				transactions: newBlockTwo.Transactions,
+				newBlockTwo:  newBlockTwo,
+				side:         side,
 			}
+			broadcastToSide(proposer, side, newBlockTwo, msg)
 
 			//Update transactional amounts and reward proposer
+			issueBlockReward(proposer, newBlockTwo)
 			for _, transaction := range newBlockTwo.Transactions {
 				transaction.Sender.Balance -= (transaction.Amount + transaction.Reward)
 				transaction.Receiver.Balance += transaction.Amount
-				proposer.Stake += transaction.Reward
+				creditReward(proposer, proposerFee(newBlockTwo, transaction))
 
 				senderString := fmt.Sprintf("New balance: %f\n", transaction.Sender.Balance)
 				io.WriteString(transaction.Sender.conn, senderString)
@@ -1451,20 +1908,29 @@ func nextReputationTimeSlot() {
 			println("Valid block added to blockchain")
 		} else {
 			println("Committee votes block invalid")
+			publishDecision(false, proposer, newBlockTwo, validTwoCount, delegates)
 			proposer.reputation *= 0.2
+			publishSlash(proposer, "proposed a block the committee rejected")
 		}
 		if isValid && isValidTwo {
 			forked = true
+			forkCount++
 			forkProposer = proposer
+			publishFork(proposer, newBlock, newBlockTwo)
 		}
 		printInfo()
 		return
 	}
 
 	//add block if majority believe block is valid
-	isValid := validCount >= len(delegates)/2
+	isValid := hasQuorum(validCount, len(delegates))
+	if currAttack == "adaptive" {
+		recordAdaptiveRound(delegates, newBlock, isValid)
+	}
 	if isValid {
+		publishHeader(newBlock)
 		println("Valid block added to blockchain")
+		publishDecision(true, proposer, newBlock, validCount, delegates)
 		proposer.blockSuccessCount += 1
 		proposer.reputation = math.Min(100, proposer.reputation+1)
 		//broadcast the verified transactions to all blocks
@@ -1472,15 +1938,14 @@ func nextReputationTimeSlot() {
 			transactions: newBlock.Transactions,
 			newBlock:     newBlock,
 		}
-		for _, validator := range validators {
-			validator.incomingChannel <- msg
-		}
+		broadcastVerifiedBlock(proposer, msg)
 
 		//Update transactional amounts and reward proposer
+		issueBlockReward(proposer, newBlock)
 		for _, transaction := range newBlock.Transactions {
 			transaction.Sender.Balance -= (transaction.Amount + transaction.Reward)
 			transaction.Receiver.Balance += transaction.Amount
-			proposer.Stake += transaction.Reward
+			creditReward(proposer, proposerFee(newBlock, transaction))
 
 			senderString := fmt.Sprintf("New balance: %f\n", transaction.Sender.Balance)
 			io.WriteString(transaction.Sender.conn, senderString)
@@ -1490,14 +1955,21 @@ func nextReputationTimeSlot() {
 		}
 	} else {
 		println("Committee votes block invalid")
+		publishDecision(false, proposer, newBlock, validCount, delegates)
 		proposer.reputation *= 0.2
+		publishSlash(proposer, "proposed a block the committee rejected")
 	}
 	//punish validators who voted against the majority
 	for _, validator := range delegates {
+		//validators whose vote never arrived are neither punished nor rewarded
+		if _, voted := validationResults[validator.Address]; !voted {
+			continue
+		}
 		if isValid {
 			//Block was valid, but voted invalid
 			if validationResults[validator.Address] == false {
 				validator.reputation *= 0.5
+				publishSlash(validator, "voted against the majority")
 			} else {
 				validator.reputation = math.Min(100, 1+validator.reputation)
 			}
@@ -1505,17 +1977,21 @@ func nextReputationTimeSlot() {
 			//Block invalid, but voted valid
 			if validationResults[validator.Address] == true {
 				validator.reputation *= 0.5
+				publishSlash(validator, "voted against the majority")
 			} else {
 				validator.reputation = math.Min(100, 1+validator.reputation)
 			}
 		}
 	}
 
+	//committee members who voted with the majority share the attestation rewards
+	rewardAttesters(delegates, validationResults, isValid)
+
 	printInfo()
 
 }
 
-func handleConnection(conn net.Conn, runType string, connectionType string, malString string, splitView bool) {
+func handleConnection(conn net.Conn, runType string, connectionType string, malString string, stakeString string, sybil bool, splitView bool) {
 	defer conn.Close()
 
 	//Determine user or validator connection
@@ -1526,9 +2002,9 @@ func handleConnection(conn net.Conn, runType string, connectionType string, malS
 	}
 	for scannedType.Scan() {
 		if scannedType.Text() == "u" {
-			handleUserConnection(conn, runType)
+			handleUserConnection(conn, runType, malString)
 		} else if scannedType.Text() == "v" {
-			handleValidatorConnection(conn, runType, malString, splitView)
+			handleValidatorConnection(conn, runType, malString, stakeString, sybil, splitView)
 		} else {
 			fmt.Printf("%s is not a valid response\n Please enter 'u' or 'v' ", scannedType.Text())
 		}
@@ -0,0 +1,470 @@
+package pos
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// Peer topologies validators can gossip over, chosen by GOSSIP_TOPOLOGY
+const (
+	starTopology          = "star"
+	randomRegularTopology = "random_regular"
+	smallWorldTopology    = "small_world"
+	scaleFreeTopology     = "scale_free"
+)
+
+// star keeps the server sending every block and transaction to every validator itself
+var gossipTopology = starTopology
+
+// Peers per validator for random_regular and small_world, twice the links each new validator makes for scale_free
+var gossipDegree = 4
+
+// Probability that small_world rewires a lattice link to a random validator
+var gossipRewire = 0.1
+
+// Simulated seconds a message is remembered for deduplication after it entered the network
+var gossipRetention = 60
+
+// Propagation delays kept for the percentiles, the oldest are dropped first
+const gossipDelaySamples = 10000
+
+type gossipState struct {
+	lock sync.Mutex
+	//validators the graph was built for, it is rebuilt when they change
+	members int
+	peers   map[string][]*Validator
+	//validators that have received a message, keyed by message then validator address
+	seen map[string]map[string]bool
+	//when a message entered the network, messages older than gossipRetention are forgotten
+	origin     map[string]time.Duration
+	lastPruned time.Duration
+
+	blockDelays       []float64
+	transactionDelays []float64
+	//messages that entered the network and deliveries to validators, including the forgotten ones
+	blocks              int
+	transactions        int
+	blocksReached       int
+	transactionsReached int
+	duplicates          int
+}
+
+var gossip = &gossipState{
+	peers:  make(map[string][]*Validator),
+	seen:   make(map[string]map[string]bool),
+	origin: make(map[string]time.Duration),
+}
+
+func loadGossipConfig() {
+	gossipTopology = envString("GOSSIP_TOPOLOGY", gossipTopology)
+	switch gossipTopology {
+	case starTopology, randomRegularTopology, smallWorldTopology, scaleFreeTopology:
+	default:
+		fmt.Printf("Unknown GOSSIP_TOPOLOGY %s, using %s\n", gossipTopology, starTopology)
+		gossipTopology = starTopology
+	}
+	gossipDegree = envInt("GOSSIP_DEGREE", gossipDegree)
+	if gossipDegree < 1 {
+		fmt.Println("GOSSIP_DEGREE must be at least 1, using 1")
+		gossipDegree = 1
+	}
+	gossipRewire = envFloat("GOSSIP_REWIRE", gossipRewire)
+	gossipRetention = envInt("GOSSIP_RETENTION", gossipRetention)
+	if gossipRetention < 1 {
+		fmt.Println("GOSSIP_RETENTION must be at least 1, using 1")
+		gossipRetention = 1
+	}
+}
+
+func gossipEnabled() bool {
+	return gossipTopology != starTopology
+}
+
+// graph is an undirected peer graph over validator indices
+type graph map[int]map[int]bool
+
+func (g graph) connect(a int, b int) {
+	if a == b {
+		return
+	}
+	if g[a] == nil {
+		g[a] = make(map[int]bool)
+	}
+	if g[b] == nil {
+		g[b] = make(map[int]bool)
+	}
+	g[a][b] = true
+	g[b][a] = true
+}
+
+func (g graph) disconnect(a int, b int) {
+	delete(g[a], b)
+	delete(g[b], a)
+}
+
+// randomRegularGraph pairs up degree link ends per validator at random, retrying when a pairing
+// gives a self loop or a double link; the last attempt drops those links instead
+func randomRegularGraph(n int, degree int) graph {
+	if degree >= n {
+		degree = n - 1
+	}
+	for attempt := 0; ; attempt++ {
+		stubs := make([]int, 0, n*degree)
+		for i := 0; i < n; i++ {
+			for j := 0; j < degree; j++ {
+				stubs = append(stubs, i)
+			}
+		}
+		rand.Shuffle(len(stubs), func(i, j int) { stubs[i], stubs[j] = stubs[j], stubs[i] })
+		g := make(graph)
+		simple := true
+		for i := 0; i+1 < len(stubs); i += 2 {
+			a, b := stubs[i], stubs[i+1]
+			if a == b || g[a][b] {
+				simple = false
+				continue
+			}
+			g.connect(a, b)
+		}
+		if simple || attempt >= 100 {
+			return g
+		}
+	}
+}
+
+// smallWorldGraph is a Watts-Strogatz ring lattice where each validator links to its degree
+// nearest neighbours, with every link rewired to a random validator with probability rewire
+func smallWorldGraph(n int, degree int, rewire float64) graph {
+	g := make(graph)
+	half := degree / 2
+	if half < 1 {
+		half = 1
+	}
+	for i := 0; i < n; i++ {
+		for j := 1; j <= half; j++ {
+			g.connect(i, (i+j)%n)
+		}
+	}
+	for i := 0; i < n; i++ {
+		for j := 1; j <= half; j++ {
+			neighbour := (i + j) % n
+			if rand.Float64() >= rewire || !g[i][neighbour] {
+				continue
+			}
+			target := rand.Intn(n)
+			if target == i || g[i][target] {
+				continue
+			}
+			g.disconnect(i, neighbour)
+			g.connect(i, target)
+		}
+	}
+	return g
+}
+
+// scaleFreeGraph is a Barabasi-Albert graph where each new validator links to degree/2 existing
+// validators chosen in proportion to how many peers they already have
+func scaleFreeGraph(n int, degree int) graph {
+	g := make(graph)
+	links := degree / 2
+	if links < 1 {
+		links = 1
+	}
+	//every link end appears once, so picking a random end prefers well connected validators
+	ends := make([]int, 0)
+	for i := 1; i < n; i++ {
+		if i <= links {
+			for j := 0; j < i; j++ {
+				g.connect(i, j)
+				ends = append(ends, i, j)
+			}
+			continue
+		}
+		chosen := make(map[int]bool)
+		for len(chosen) < links {
+			chosen[ends[rand.Intn(len(ends))]] = true
+		}
+		for j := range chosen {
+			g.connect(i, j)
+			ends = append(ends, i, j)
+		}
+	}
+	return g
+}
+
+// ensureTopologyLocked rebuilds the peer graph when validators have joined since it was built
+func (s *gossipState) ensureTopologyLocked() {
+	validatorsSliceLock.Lock()
+	members := make([]*Validator, len(validators))
+	copy(members, validators)
+	validatorsSliceLock.Unlock()
+	if len(members) == s.members {
+		return
+	}
+	s.members = len(members)
+
+	var g graph
+	switch gossipTopology {
+	case randomRegularTopology:
+		g = randomRegularGraph(len(members), gossipDegree)
+	case smallWorldTopology:
+		g = smallWorldGraph(len(members), gossipDegree, gossipRewire)
+	case scaleFreeTopology:
+		g = scaleFreeGraph(len(members), gossipDegree)
+	}
+	s.peers = make(map[string][]*Validator)
+	for i, validator := range members {
+		neighbours := make([]int, 0, len(g[i]))
+		for j := range g[i] {
+			neighbours = append(neighbours, j)
+		}
+		sort.Ints(neighbours)
+		for _, j := range neighbours {
+			s.peers[validator.Address] = append(s.peers[validator.Address], members[j])
+		}
+	}
+}
+
+// originate records when a message entered the network
+func (s *gossipState) originate(key string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.pruneLocked()
+	if _, ok := s.origin[key]; !ok {
+		s.origin[key] = clock.now()
+		if strings.HasPrefix(key, "block/") {
+			s.blocks++
+		} else {
+			s.transactions++
+		}
+	}
+}
+
+// pruneLocked forgets the messages that entered the network more than gossipRetention ago,
+// at most twice per retention period. A copy arriving later is processed again, which the
+// validator's chain and mempool checks make harmless.
+func (s *gossipState) pruneLocked() {
+	retention := time.Duration(gossipRetention) * time.Second
+	now := clock.now()
+	if now-s.lastPruned < retention/2 {
+		return
+	}
+	s.lastPruned = now
+	for key, origin := range s.origin {
+		if now-origin > retention {
+			delete(s.origin, key)
+			delete(s.seen, key)
+		}
+	}
+}
+
+// appendDelay adds a propagation delay, keeping the most recent gossipDelaySamples
+func appendDelay(delays []float64, delay float64) []float64 {
+	if len(delays) >= gossipDelaySamples {
+		kept := make([]float64, gossipDelaySamples/2, gossipDelaySamples)
+		copy(kept, delays[len(delays)-gossipDelaySamples/2:])
+		delays = kept
+	}
+	return append(delays, delay)
+}
+
+// receive marks a message as seen by a validator, returning the peers to forward it to,
+// and false if the validator has already seen it
+func (s *gossipState) receive(validator *Validator, key string, block bool) ([]*Validator, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.seen[key] == nil {
+		s.seen[key] = make(map[string]bool)
+	}
+	if s.seen[key][validator.Address] {
+		s.duplicates++
+		return nil, false
+	}
+	s.seen[key][validator.Address] = true
+	origin, ok := s.origin[key]
+	if !ok {
+		//a message that never went through originate is timed from its first delivery, so it is pruned as well
+		origin = clock.now()
+		s.origin[key] = origin
+	}
+	delay := float64((clock.now() - origin).Microseconds()) / 1000
+	if block {
+		s.blockDelays = appendDelay(s.blockDelays, delay)
+		s.blocksReached++
+	} else {
+		s.transactionDelays = appendDelay(s.transactionDelays, delay)
+		s.transactionsReached++
+	}
+	s.ensureTopologyLocked()
+	return s.peers[validator.Address], true
+}
+
+func blockGossipKey(block Block) string {
+	return "block/" + block.Hash
+}
+
+func transactionGossipKey(transaction Transaction) string {
+	return "tx/" + strconv.Itoa(transaction.ID)
+}
+
+// broadcastVerifiedBlock sends an accepted block to every validator, directly under the star
+// topology and otherwise to the proposer, who starts gossiping it
+func broadcastVerifiedBlock(origin *Validator, msg VerifiedBlockMessage) {
+	if !gossipEnabled() {
+		for _, validator := range validators {
+			sendToValidator(validator, msg)
+		}
+		return
+	}
+	gossip.originate(blockGossipKey(msg.newBlock))
+	sendToValidator(origin, msg)
+}
+
+// forkSide lists the validators on one side of the partition attack's fork, in the proposer's group or outside it
+func forkSide(group int, inside bool) []*Validator {
+	validatorsSliceLock.Lock()
+	defer validatorsSliceLock.Unlock()
+	side := make([]*Validator, 0)
+	for _, validator := range validators {
+		if slices.Contains(ForkedBlockchain[group], validator) == inside {
+			side = append(side, validator)
+		}
+	}
+	return side
+}
+
+// broadcastToSide sends an accepted block of the partition attack to one side of the fork, directly under the
+// star topology and otherwise to one validator on that side, the proposer if it is there, who starts gossiping it
+func broadcastToSide(origin *Validator, side []*Validator, block Block, msg interface{}) {
+	if !gossipEnabled() {
+		for _, validator := range side {
+			sendToValidator(validator, msg)
+		}
+		return
+	}
+	if len(side) == 0 {
+		return
+	}
+	entry := side[rand.Intn(len(side))]
+	if slices.Contains(side, origin) {
+		entry = origin
+	}
+	gossip.originate(blockGossipKey(block))
+	sendToValidator(entry, msg)
+}
+
+// submitTransaction sends a user's transaction to every validator under the star topology,
+// and otherwise to one validator it is gossiped on from
+func submitTransaction(from string, recipients []*Validator, msg NewTransactionMessage) {
+	if !gossipEnabled() {
+		for _, validator := range recipients {
+			sendTransaction(from, validator, msg)
+		}
+		return
+	}
+	if len(recipients) == 0 {
+		return
+	}
+	gossip.originate(transactionGossipKey(msg.transaction))
+	sendTransaction(from, recipients[rand.Intn(len(recipients))], msg)
+}
+
+// gossipBlock is called when a validator receives a verified block; it forwards the block to the
+// validator's peers the first time and reports whether the block still has to be processed
+func gossipBlock(validator *Validator, msg VerifiedBlockMessage) bool {
+	return gossipSideBlock(validator, msg.newBlock, msg.side, msg)
+}
+
+// gossipSideBlock forwards a block message to the validator's peers the first time it sees it,
+// skipping peers outside side unless side is nil
+func gossipSideBlock(validator *Validator, block Block, side []*Validator, msg interface{}) bool {
+	if !gossipEnabled() {
+		return true
+	}
+	peers, first := gossip.receive(validator, blockGossipKey(block), true)
+	if !first {
+		return false
+	}
+	for _, peer := range peers {
+		if side != nil && !slices.Contains(side, peer) {
+			continue
+		}
+		peer := peer
+		simNetwork.send(validator.Address, peer.Address, "in/"+peer.Address, msg, func(msg interface{}) {
+			peer.incomingChannel <- msg
+		})
+	}
+	return true
+}
+
+// gossipTransaction forwards a transaction to the validator's peers the first time it sees it
+func gossipTransaction(validator *Validator, msg NewTransactionMessage) bool {
+	if !gossipEnabled() {
+		return true
+	}
+	peers, first := gossip.receive(validator, transactionGossipKey(msg.transaction), false)
+	if !first {
+		return false
+	}
+	for _, peer := range peers {
+		peer := peer
+		simNetwork.send(validator.Address, peer.Address, "tx/"+peer.Address, msg, func(msg interface{}) {
+			peer.transactionChannel <- msg.(NewTransactionMessage)
+		})
+	}
+	return true
+}
+
+// percentile reads the p-th percentile (0-100) of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	index := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if index < 0 {
+		index = 0
+	}
+	return sorted[index]
+}
+
+// printPropagation reports the delay percentiles over the recent deliveries and the share of validators reached over all of them
+func printPropagation(name string, delays []float64, reached int, messages int, members int) {
+	if messages == 0 {
+		return
+	}
+	sorted := make([]float64, len(delays))
+	copy(sorted, delays)
+	sort.Float64s(sorted)
+	coverage := 0.0
+	if members > 0 {
+		coverage = float64(reached) / float64(messages*members)
+	}
+	fmt.Printf("%s propagation p50: %f ms, p90: %f ms, p99: %f ms, max: %f ms, validators reached: %f\n", name, percentile(sorted, 50), percentile(sorted, 90), percentile(sorted, 99), percentile(sorted, 100), coverage)
+}
+
+func printGossipEvaluation() {
+	if !gossipEnabled() {
+		return
+	}
+	gossip.lock.Lock()
+	defer gossip.lock.Unlock()
+	links := 0
+	for _, peers := range gossip.peers {
+		links += len(peers)
+	}
+	averageDegree := 0.0
+	if gossip.members > 0 {
+		averageDegree = float64(links) / float64(gossip.members)
+	}
+	fmt.Printf("Gossip topology: %s, average peers: %f, duplicate deliveries: %d, messages remembered: %d\n", gossipTopology, averageDegree, gossip.duplicates, len(gossip.origin))
+	printPropagation("Block", gossip.blockDelays, gossip.blocksReached, gossip.blocks, gossip.members)
+	printPropagation("Transaction", gossip.transactionDelays, gossip.transactionsReached, gossip.transactions, gossip.members)
+}
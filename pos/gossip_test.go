@@ -0,0 +1,95 @@
+package pos
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestGossip swaps in an empty gossip state over a fixed peer graph and a network nobody delivers from
+func newTestGossip(t *testing.T, members []*Validator, peers map[string][]*Validator) {
+	t.Helper()
+	previousGossip, previousNetwork, previousTopology, previousValidators := gossip, simNetwork, gossipTopology, validators
+	t.Cleanup(func() {
+		gossip, simNetwork, gossipTopology, validators = previousGossip, previousNetwork, previousTopology, previousValidators
+	})
+	gossipTopology = randomRegularTopology
+	validators = members
+	gossip = &gossipState{
+		members: len(members),
+		peers:   peers,
+		seen:    make(map[string]map[string]bool),
+		origin:  make(map[string]time.Duration),
+	}
+	simNetwork = &network{
+		wake:       make(chan struct{}, 1),
+		mailboxes:  make(map[string]chan func()),
+		linkFreeAt: make(map[string]time.Duration),
+	}
+}
+
+func queuedMailboxes() map[string]bool {
+	simNetwork.lock.Lock()
+	defer simNetwork.lock.Unlock()
+	mailboxes := make(map[string]bool)
+	for _, delivery := range simNetwork.queue {
+		mailboxes[delivery.mailbox] = true
+	}
+	return mailboxes
+}
+
+func TestGossipDeduplicates(t *testing.T) {
+	a, b := &Validator{Address: "a"}, &Validator{Address: "b"}
+	newTestGossip(t, []*Validator{a, b}, map[string][]*Validator{"a": {b}, "b": {a}})
+	gossip.originate("block/x")
+
+	if peers, first := gossip.receive(a, "block/x", true); !first || len(peers) != 1 {
+		t.Fatalf("first delivery: got peers %v, first %t", peers, first)
+	}
+	if _, first := gossip.receive(a, "block/x", true); first || gossip.duplicates != 1 {
+		t.Fatal("second delivery was not counted as a duplicate")
+	}
+	if gossip.blocks != 1 || gossip.blocksReached != 1 {
+		t.Fatalf("got %d blocks reaching %d validators, want 1 and 1", gossip.blocks, gossip.blocksReached)
+	}
+}
+
+func TestGossipForgetsOldMessages(t *testing.T) {
+	a := &Validator{Address: "a"}
+	newTestGossip(t, []*Validator{a}, map[string][]*Validator{})
+	gossip.originate("tx/1")
+	gossip.receive(a, "tx/1", false)
+
+	advanceClock(t, time.Duration(gossipRetention+1)*time.Second)
+	gossip.originate("tx/2")
+	if _, ok := gossip.seen["tx/1"]; ok {
+		t.Fatal("message older than the retention is still remembered")
+	}
+	if _, ok := gossip.origin["tx/2"]; !ok || gossip.transactions != 2 {
+		t.Fatal("new message was not recorded")
+	}
+}
+
+func TestAppendDelayIsBounded(t *testing.T) {
+	delays := make([]float64, 0)
+	for i := 0; i < 3*gossipDelaySamples; i++ {
+		delays = appendDelay(delays, float64(i))
+	}
+	if len(delays) > gossipDelaySamples || delays[len(delays)-1] != float64(3*gossipDelaySamples-1) {
+		t.Fatalf("kept %d delays ending with %f", len(delays), delays[len(delays)-1])
+	}
+}
+
+func TestGossipSideBlockStaysOnItsSide(t *testing.T) {
+	a, b, c := &Validator{Address: "a"}, &Validator{Address: "b"}, &Validator{Address: "c"}
+	newTestGossip(t, []*Validator{a, b, c}, map[string][]*Validator{"a": {b, c}, "b": {a}, "c": {a}})
+
+	block := newTestBlock(newTestGenesis(), "a", nil)
+	msg := VerifiedShortAttackBlockMessage{newBlock: block, side: []*Validator{a, b}}
+	if !gossipSideBlock(a, block, msg.side, msg) {
+		t.Fatal("first delivery was not processed")
+	}
+	mailboxes := queuedMailboxes()
+	if !mailboxes["in/b"] || mailboxes["in/c"] {
+		t.Fatalf("got deliveries to %v, want only b", mailboxes)
+	}
+}
@@ -0,0 +1,206 @@
+package pos
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Signature schemes users and validators can hold keys for
+const (
+	ed25519Scheme = "ed25519"
+	rsaScheme     = "rsa"
+)
+
+// Scheme new keys are generated with
+var signatureScheme = ed25519Scheme
+
+// Directory keys are persisted in so identities survive across runs, disabled when empty
+var keystoreDir = ""
+
+var keystoreLock = &sync.Mutex{}
+
+var validatorID = 0
+
+var validatorIDLock = &sync.Mutex{}
+
+// keyPair signs on behalf of a user or validator; addresses are derived from publicKey
+type keyPair interface {
+	scheme() string
+	publicKey() []byte
+	privateKey() []byte
+	sign(message []byte) (string, error)
+}
+
+type ed25519KeyPair struct {
+	private ed25519.PrivateKey
+}
+
+func (k ed25519KeyPair) scheme() string {
+	return ed25519Scheme
+}
+
+func (k ed25519KeyPair) publicKey() []byte {
+	return k.private.Public().(ed25519.PublicKey)
+}
+
+func (k ed25519KeyPair) privateKey() []byte {
+	return k.private.Seed()
+}
+
+func (k ed25519KeyPair) sign(message []byte) (string, error) {
+	return hex.EncodeToString(ed25519.Sign(k.private, message)), nil
+}
+
+type rsaKeyPair struct {
+	private *rsa.PrivateKey
+}
+
+func (k rsaKeyPair) scheme() string {
+	return rsaScheme
+}
+
+func (k rsaKeyPair) publicKey() []byte {
+	return x509.MarshalPKCS1PublicKey(&k.private.PublicKey)
+}
+
+func (k rsaKeyPair) privateKey() []byte {
+	return x509.MarshalPKCS1PrivateKey(k.private)
+}
+
+func (k rsaKeyPair) sign(message []byte) (string, error) {
+	hash := sha256.Sum256(message)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, k.private, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(signature), nil
+}
+
+func loadKeyConfig() {
+	signatureScheme = envString("SIGNATURE_SCHEME", signatureScheme)
+	if signatureScheme != ed25519Scheme && signatureScheme != rsaScheme {
+		fmt.Printf("Unknown SIGNATURE_SCHEME %s, using %s\n", signatureScheme, ed25519Scheme)
+		signatureScheme = ed25519Scheme
+	}
+	keystoreDir = envString("KEYSTORE_DIR", keystoreDir)
+}
+
+func generateKeyPair(scheme string) (keyPair, error) {
+	switch scheme {
+	case ed25519Scheme:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return ed25519KeyPair{private: private}, nil
+	case rsaScheme:
+		private, err := rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return nil, err
+		}
+		return rsaKeyPair{private: private}, nil
+	}
+	return nil, fmt.Errorf("unknown signature scheme %s", scheme)
+}
+
+func parseKeyPair(scheme string, private []byte) (keyPair, error) {
+	switch scheme {
+	case ed25519Scheme:
+		if len(private) != ed25519.SeedSize {
+			return nil, errors.New("ed25519 key has the wrong length")
+		}
+		return ed25519KeyPair{private: ed25519.NewKeyFromSeed(private)}, nil
+	case rsaScheme:
+		key, err := x509.ParsePKCS1PrivateKey(private)
+		if err != nil {
+			return nil, err
+		}
+		return rsaKeyPair{private: key}, nil
+	}
+	return nil, fmt.Errorf("unknown signature scheme %s", scheme)
+}
+
+// verifySignature checks a hex signature made by keyPair.sign
+func verifySignature(scheme string, publicKey []byte, message []byte, signature string) bool {
+	decoded, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+	switch scheme {
+	case ed25519Scheme:
+		return len(publicKey) == ed25519.PublicKeySize && ed25519.Verify(publicKey, message, decoded)
+	case rsaScheme:
+		key, err := x509.ParsePKCS1PublicKey(publicKey)
+		if err != nil {
+			return false
+		}
+		hash := sha256.Sum256(message)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], decoded) == nil
+	}
+	return false
+}
+
+// storedKey is the keystore file format, one file per identity
+type storedKey struct {
+	Scheme     string `json:"scheme"`
+	PrivateKey []byte `json:"private_key"`
+}
+
+// loadOrGenerateKey returns the stored key of an identity, generating and storing one if it has none
+// A stored key keeps its own scheme even if SIGNATURE_SCHEME has changed since
+func loadOrGenerateKey(name string) (keyPair, error) {
+	if keystoreDir == "" {
+		return generateKeyPair(signatureScheme)
+	}
+	keystoreLock.Lock()
+	defer keystoreLock.Unlock()
+
+	path := filepath.Join(keystoreDir, name+".json")
+	data, err := os.ReadFile(path)
+	if err == nil {
+		stored := storedKey{}
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return nil, fmt.Errorf("keystore file %s: %v", path, err)
+		}
+		return parseKeyPair(stored.Scheme, stored.PrivateKey)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	keys, err := generateKeyPair(signatureScheme)
+	if err != nil {
+		return nil, err
+	}
+	data, err = json.Marshal(storedKey{Scheme: keys.scheme(), PrivateKey: keys.privateKey()})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(keystoreDir, 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// nextValidatorName names validators in join order so their keys can be found in the keystore
+func nextValidatorName() string {
+	validatorIDLock.Lock()
+	defer validatorIDLock.Unlock()
+	name := fmt.Sprintf("validator%d", validatorID)
+	validatorID++
+	return name
+}
@@ -0,0 +1,95 @@
+package pos
+
+import "testing"
+
+func TestSignAndParseKeyPair(t *testing.T) {
+	message := []byte("block hash")
+	for _, scheme := range []string{ed25519Scheme, rsaScheme} {
+		t.Run(scheme, func(t *testing.T) {
+			keys, err := generateKeyPair(scheme)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signature, err := keys.sign(message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !verifySignature(scheme, keys.publicKey(), message, signature) {
+				t.Fatal("signature does not verify")
+			}
+			if verifySignature(scheme, keys.publicKey(), []byte("other hash"), signature) {
+				t.Fatal("signature verifies for another message")
+			}
+			other, err := generateKeyPair(scheme)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if verifySignature(scheme, other.publicKey(), message, signature) {
+				t.Fatal("signature verifies under another key")
+			}
+
+			parsed, err := parseKeyPair(scheme, keys.privateKey())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if parsed.scheme() != scheme || string(parsed.publicKey()) != string(keys.publicKey()) {
+				t.Fatal("parsed key differs from the generated one")
+			}
+			signature, err = parsed.sign(message)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !verifySignature(scheme, keys.publicKey(), message, signature) {
+				t.Fatal("signature of the parsed key does not verify")
+			}
+		})
+	}
+	if _, err := parseKeyPair(ed25519Scheme, []byte("short")); err == nil {
+		t.Fatal("ed25519 key of the wrong length parsed")
+	}
+	if verifySignature(ed25519Scheme, nil, message, "not hex") {
+		t.Fatal("malformed signature verified")
+	}
+}
+
+func TestLoadOrGenerateKey(t *testing.T) {
+	previousDir, previousScheme := keystoreDir, signatureScheme
+	t.Cleanup(func() { keystoreDir, signatureScheme = previousDir, previousScheme })
+	keystoreDir = t.TempDir()
+
+	signatureScheme = rsaScheme
+	keys, err := loadOrGenerateKey("validator0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//a stored key keeps its scheme after the configured one changes
+	signatureScheme = ed25519Scheme
+	loaded, err := loadOrGenerateKey("validator0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.scheme() != rsaScheme || string(loaded.publicKey()) != string(keys.publicKey()) {
+		t.Fatal("stored key was not loaded back")
+	}
+	other, err := loadOrGenerateKey("validator1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.scheme() != ed25519Scheme {
+		t.Fatalf("new key uses %s, want the configured %s", other.scheme(), ed25519Scheme)
+	}
+
+	//without a keystore every call generates a fresh key
+	keystoreDir = ""
+	first, err := loadOrGenerateKey("validator0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := loadOrGenerateKey("validator0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(first.publicKey()) == string(second.publicKey()) {
+		t.Fatal("key generated without a keystore was reused")
+	}
+}
@@ -0,0 +1,241 @@
+package pos
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+// Number of light clients following headers; the light_client attack defaults to 2
+var lightClientCount = 0
+
+// LightClient follows block headers only and checks payments to its merchant with inclusion proofs
+// It trusts any header with a valid commit certificate, since it cannot execute blocks itself
+type LightClient struct {
+	id            int
+	watched       *User
+	headers       []BlockHeader
+	headerChannel chan interface{}
+	lock          sync.Mutex
+
+	//payment ID to the hash of the header it was proven against
+	paymentsVerified map[int]string
+	paymentsReverted int
+	proofsRejected   int
+	headersRejected  int
+	reorgs           int
+}
+
+var lightClients = make([]*LightClient, 0)
+
+// IDs of payments the light_client attack fabricated
+var forgedPaymentIDs = make(map[int]bool)
+
+func loadLightClientConfig() {
+	if currAttack == "light_client" {
+		lightClientCount = 2
+	}
+	lightClientCount = envInt("LIGHT_CLIENTS", lightClientCount)
+}
+
+// startLightClients creates the light clients on top of the certified genesis block
+func startLightClients() {
+	for i := 0; i < lightClientCount; i++ {
+		client := &LightClient{
+			id:               i,
+			headers:          []BlockHeader{CertifiedBlockchain[0].header()},
+			headerChannel:    make(chan interface{}, 64),
+			paymentsVerified: make(map[int]string),
+		}
+		lightClients = append(lightClients, client)
+		go client.run()
+	}
+}
+
+func (client *LightClient) run() {
+	for msg := range client.headerChannel {
+		client.lock.Lock()
+		switch msg := msg.(type) {
+		case LightHeaderMessage:
+			client.acceptHeader(msg)
+		case LightSyncMessage:
+			client.sync(msg.headers)
+		}
+		client.lock.Unlock()
+	}
+}
+
+// acceptHeader appends a certified header and records the payments proven against its TxRoot
+func (client *LightClient) acceptHeader(msg LightHeaderMessage) {
+	header := msg.header
+	if calculateHeaderHash(header) != header.Hash {
+		client.headersRejected++
+		return
+	}
+	if err := verifyCertificate(header.Hash, header.Validator, msg.proposerSignature, msg.certificate); err != nil {
+		client.headersRejected++
+		return
+	}
+	tip := client.headers[len(client.headers)-1]
+	if header.PrevHash != tip.Hash || header.Index != tip.Index+1 {
+		//the header builds on a branch this client is not following, wait for the next sync
+		client.headersRejected++
+		return
+	}
+	client.headers = append(client.headers, header)
+
+	for i, payment := range msg.payments {
+		if verifyTransactionInclusion(header, payment, msg.proofs[i]) {
+			client.paymentsVerified[payment.ID] = header.Hash
+		} else {
+			client.proofsRejected++
+		}
+	}
+}
+
+// sync switches the client to the certified header chain after the longest chain consensus
+// Payments proven against headers that are reorged away are no longer verified
+func (client *LightClient) sync(headers []BlockHeader) {
+	common := 0
+	for common < len(client.headers) && common < len(headers) && client.headers[common].Hash == headers[common].Hash {
+		common++
+	}
+	if common < len(client.headers) {
+		client.reorgs++
+		dropped := make(map[string]bool)
+		for _, header := range client.headers[common:] {
+			dropped[header.Hash] = true
+		}
+		for id, hash := range client.paymentsVerified {
+			if dropped[hash] {
+				delete(client.paymentsVerified, id)
+				client.paymentsReverted++
+			}
+		}
+	}
+	client.headers = append(client.headers[:common], headers[common:]...)
+}
+
+// watchedUser returns the merchant the client checks payments for, picking one once users have joined
+func (client *LightClient) watchedUser() *User {
+	if client.watched == nil {
+		usersSliceLock.Lock()
+		if len(users) > 0 {
+			names := make([]string, 0, len(users))
+			for name := range users {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			client.watched = users[names[client.id%len(names)]]
+		}
+		usersSliceLock.Unlock()
+	}
+	return client.watched
+}
+
+// publishHeader sends the header and certificate of a committee approved block to every light client,
+// together with inclusion proofs for the payments to its merchant
+func publishHeader(block Block) {
+	for _, client := range lightClients {
+		watched := client.watchedUser()
+		msg := LightHeaderMessage{
+			header:            block.header(),
+			proposerSignature: block.ProposerSignature,
+			certificate:       block.Certificate,
+			payments:          make([]Transaction, 0),
+			proofs:            make([]merkleProof, 0),
+		}
+		for _, transaction := range block.Transactions {
+			if watched == nil || transaction.Receiver != watched {
+				continue
+			}
+			payment, proof, err := transactionInclusionProof(block, transaction.ID)
+			if err != nil {
+				continue
+			}
+			msg.payments = append(msg.payments, payment)
+			msg.proofs = append(msg.proofs, proof)
+		}
+		client.headerChannel <- msg
+	}
+}
+
+// syncLightClients pushes the certified header chain to every light client
+func syncLightClients() {
+	headers := make([]BlockHeader, len(CertifiedBlockchain))
+	for i, block := range CertifiedBlockchain {
+		headers[i] = block.header()
+	}
+	for _, client := range lightClients {
+		client.headerChannel <- LightSyncMessage{headers: headers}
+	}
+}
+
+// forgeLightClientBlock builds a block paying a light client's merchant with funds the sender does not have
+// The header and proof are well formed, so only full validators executing the block can tell it is invalid
+func forgeLightClientBlock(proposer *Validator) (Block, error) {
+	newBlock, err := generateBlock(proposer)
+	if err != nil {
+		return newBlock, err
+	}
+	if len(lightClients) == 0 {
+		return newBlock, nil
+	}
+	merchant := lightClients[rand.Intn(len(lightClients))].watchedUser()
+	if merchant == nil {
+		return newBlock, nil
+	}
+
+	usersSliceLock.Lock()
+	var sender *User
+	for _, user := range users {
+		if user != merchant {
+			sender = user
+			break
+		}
+	}
+	usersSliceLock.Unlock()
+	if sender == nil {
+		return newBlock, nil
+	}
+
+	transactionIDLock.Lock()
+	id := transactionID
+	transactionID++
+	transactionIDLock.Unlock()
+
+	forged := Transaction{
+		ID:        id,
+		Sender:    sender,
+		Receiver:  merchant,
+		Signature: "",
+		Amount:    proposer.state.balance(sender.Address) + 1000,
+		Reward:    0,
+		Nonce:     proposer.state.nonce(sender.Address),
+	}
+	newBlock.Transactions = append(newBlock.Transactions, forged)
+	newBlock.TxRoot = transactionRoot(newBlock.Transactions)
+	newBlock.IsMalicious = true
+	newBlock.Hash = calculateBlockHash(newBlock)
+	signBlock(&newBlock, proposer)
+	forgedPaymentIDs[id] = true
+	fmt.Printf("Proposer %s forged a payment of %f to %s\n", proposer.Address[:3], forged.Amount, merchant.Name)
+	return newBlock, nil
+}
+
+func printLightClientEvaluation() {
+	fmt.Printf("Forged payments sent: %d\n", len(forgedPaymentIDs))
+	for _, client := range lightClients {
+		client.lock.Lock()
+		forgedAccepted := 0
+		for id := range client.paymentsVerified {
+			if forgedPaymentIDs[id] {
+				forgedAccepted++
+			}
+		}
+		fmt.Printf("Light client %d: header height %d, payments verified %d, forged payments accepted %d, payments reverted by reorgs %d, bad proofs %d, headers rejected %d, reorgs %d\n",
+			client.id, len(client.headers)-1, len(client.paymentsVerified), forgedAccepted, client.paymentsReverted, client.proofsRejected, client.headersRejected, client.reorgs)
+		client.lock.Unlock()
+	}
+}
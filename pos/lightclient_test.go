@@ -0,0 +1,29 @@
+package pos
+
+import "testing"
+
+func TestLightClientSyncRevertsPayments(t *testing.T) {
+	genesis := newTestGenesis()
+	kept := newTestBlock(genesis, "proposer", nil)
+	orphaned := newTestBlock(kept, "proposer", nil)
+	winner := newTestBlock(kept, "other", nil)
+
+	client := &LightClient{
+		headers:          []BlockHeader{genesis.header(), kept.header(), orphaned.header()},
+		paymentsVerified: map[int]string{1: kept.Hash, 2: orphaned.Hash},
+	}
+	client.sync([]BlockHeader{genesis.header(), kept.header(), winner.header()})
+
+	if _, ok := client.paymentsVerified[1]; !ok {
+		t.Fatal("payment in a kept header was reverted")
+	}
+	if _, ok := client.paymentsVerified[2]; ok {
+		t.Fatal("payment in an orphaned header is still verified")
+	}
+	if client.reorgs != 1 || client.paymentsReverted != 1 {
+		t.Fatalf("got %d reorgs and %d reverted payments, want 1 and 1", client.reorgs, client.paymentsReverted)
+	}
+	if client.headers[2].Hash != winner.Hash {
+		t.Fatal("client did not switch to the certified headers")
+	}
+}
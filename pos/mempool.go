@@ -0,0 +1,230 @@
+package pos
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Maximum number of pending transactions a validator keeps
+var mempoolCapacity = 500
+
+// Simulated seconds a transaction may stay pending before it is dropped
+var mempoolTTL = 30
+
+var errDuplicateTransaction = errors.New("transaction is already pending")
+var errMempoolFull = errors.New("mempool is full and the fee is too low to evict a transaction")
+
+// mempool holds a validator's pending transactions and the IDs its chain has confirmed
+type mempool struct {
+	lock      sync.Mutex
+	pending   map[int]Transaction
+	received  map[int]time.Duration
+	confirmed map[int]bool
+
+	peak       int
+	duplicates int
+	conflicts  int
+	evicted    int
+	rejected   int
+	expired    int
+	reinserted int
+}
+
+func loadMempoolConfig() {
+	mempoolCapacity = envInt("MEMPOOL_CAPACITY", mempoolCapacity)
+	mempoolTTL = envInt("MEMPOOL_TTL", mempoolTTL)
+}
+
+func newMempool() *mempool {
+	return &mempool{
+		pending:   make(map[int]Transaction),
+		received:  make(map[int]time.Duration),
+		confirmed: make(map[int]bool),
+	}
+}
+
+// add inserts a pending transaction, evicting the lowest fee transaction when the pool is full
+// A transaction reusing a pending transaction's sender nonce, or one of its inputs in the UTXO ledger,
+// is rejected, the first one seen wins
+func (m *mempool) add(transaction Transaction) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.expireLocked()
+
+	if _, ok := m.pending[transaction.ID]; ok {
+		m.duplicates++
+		return errDuplicateTransaction
+	}
+	for _, pending := range m.pending {
+		if ledgerMode == utxoLedgerMode {
+			if spendsSameOutput(pending, transaction) {
+				m.conflicts++
+				return errConflictingInput
+			}
+		} else if pending.Sender == transaction.Sender && pending.Nonce == transaction.Nonce {
+			m.conflicts++
+			return errNonceUsed
+		}
+	}
+	if len(m.pending) >= mempoolCapacity {
+		lowest, found := Transaction{}, false
+		for _, pending := range m.pending {
+			if !found || pending.Reward < lowest.Reward || (pending.Reward == lowest.Reward && pending.ID > lowest.ID) {
+				lowest, found = pending, true
+			}
+		}
+		if !found || lowest.Reward >= transaction.Reward {
+			m.rejected++
+			return errMempoolFull
+		}
+		m.removeLocked(lowest.ID)
+		m.evicted++
+	}
+	m.pending[transaction.ID] = transaction
+	m.received[transaction.ID] = clock.now()
+	if len(m.pending) > m.peak {
+		m.peak = len(m.pending)
+	}
+	return nil
+}
+
+func (m *mempool) removeLocked(id int) {
+	delete(m.pending, id)
+	delete(m.received, id)
+}
+
+func (m *mempool) expireLocked() {
+	cutoff := clock.now() - time.Duration(mempoolTTL)*time.Second
+	for id, received := range m.received {
+		if received < cutoff {
+			m.removeLocked(id)
+			m.expired++
+		}
+	}
+}
+
+// candidates returns the unexpired pending transactions not in exclude
+func (m *mempool) candidates(exclude map[int]bool) []Transaction {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.expireLocked()
+	candidates := make([]Transaction, 0, len(m.pending))
+	for id, transaction := range m.pending {
+		if !exclude[id] {
+			candidates = append(candidates, transaction)
+		}
+	}
+	return candidates
+}
+
+// isConfirmed reports whether the validator's chain already contains the transaction
+func (m *mempool) isConfirmed(id int) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.confirmed[id]
+}
+
+// confirm takes the transactions of a block added to the chain out of the pool
+func (m *mempool) confirm(transactions []Transaction) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.confirmLocked(transactions)
+}
+
+func (m *mempool) confirmLocked(transactions []Transaction) {
+	for _, transaction := range transactions {
+		m.confirmed[transaction.ID] = true
+		m.removeLocked(transaction.ID)
+	}
+}
+
+// reorg puts the transactions of orphaned blocks back into the pool and confirms those of adopted blocks
+func (m *mempool) reorg(orphaned []Block, adopted []Block) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, block := range orphaned {
+		for _, transaction := range block.Transactions {
+			delete(m.confirmed, transaction.ID)
+		}
+	}
+	for _, block := range adopted {
+		m.confirmLocked(block.Transactions)
+	}
+	now := clock.now()
+	for _, block := range orphaned {
+		for _, transaction := range block.Transactions {
+			if m.confirmed[transaction.ID] {
+				continue
+			}
+			if _, ok := m.pending[transaction.ID]; ok {
+				continue
+			}
+			//reinserted transactions skip eviction, the pool shrinks back as blocks are built
+			m.pending[transaction.ID] = transaction
+			m.received[transaction.ID] = now
+			m.reinserted++
+		}
+	}
+	if len(m.pending) > m.peak {
+		m.peak = len(m.pending)
+	}
+}
+
+func (m *mempool) size() int {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return len(m.pending)
+}
+
+// adoptChain switches a validator to chain, updating its state and mempool from the fork point
+func adoptChain(validator *Validator, chain []Block) int {
+	validator.chainLock.Lock()
+	defer validator.chainLock.Unlock()
+	common := 0
+	for common < len(validator.Blockchain) && common < len(chain) && validator.Blockchain[common].Hash == chain[common].Hash {
+		common++
+	}
+	//a chain is cut at the first block that does not execute
+	chain = chain[:reorgState(validator, chain)]
+	if common > len(chain) {
+		common = len(chain)
+	}
+	validator.pool.reorg(validator.Blockchain[common:], chain[common:])
+	orphaned := len(validator.Blockchain) - common
+	rememberBlocks(validator.Blockchain[common:])
+	validator.Blockchain = make([]Block, len(chain))
+	copy(validator.Blockchain, chain)
+	return orphaned
+}
+
+func printMempoolEvaluation() {
+	validatorsSliceLock.Lock()
+	validatorsCopy := make([]*Validator, len(validators))
+	copy(validatorsCopy, validators)
+	validatorsSliceLock.Unlock()
+	if len(validatorsCopy) == 0 {
+		return
+	}
+
+	total, peak := 0, 0
+	duplicates, conflicts, evicted, rejected, expired, reinserted := 0, 0, 0, 0, 0, 0
+	for _, validator := range validatorsCopy {
+		pool := validator.pool
+		pool.lock.Lock()
+		total += len(pool.pending)
+		if pool.peak > peak {
+			peak = pool.peak
+		}
+		duplicates += pool.duplicates
+		conflicts += pool.conflicts
+		evicted += pool.evicted
+		rejected += pool.rejected
+		expired += pool.expired
+		reinserted += pool.reinserted
+		pool.lock.Unlock()
+	}
+	fmt.Printf("Mempool size average: %f, peak: %d, capacity: %d\n", float64(total)/float64(len(validatorsCopy)), peak, mempoolCapacity)
+	fmt.Printf("Mempool duplicates: %d, conflicting spends: %d, evicted: %d, rejected when full: %d, expired: %d, reinserted after reorg: %d\n", duplicates, conflicts, evicted, rejected, expired, reinserted)
+}
@@ -0,0 +1,104 @@
+package pos
+
+import (
+	"testing"
+	"time"
+)
+
+// advanceClock moves the simulated clock forward without waiting
+func advanceClock(t *testing.T, d time.Duration) {
+	t.Helper()
+	previous := clock
+	clock = &simClock{start: clock.start.Add(-clock.realDuration(d))}
+	t.Cleanup(func() { clock = previous })
+}
+
+func TestMempoolRejectsDuplicatesAndNonceConflicts(t *testing.T) {
+	alice := newTestUser(t, "alice", 100)
+	bob := newTestUser(t, "bob", 100)
+	pool := newMempool()
+	if err := pool.add(newTestTransaction(1, alice, bob, 1, 1, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if err := pool.add(newTestTransaction(1, alice, bob, 1, 1, 0)); err != errDuplicateTransaction {
+		t.Fatalf("duplicate: got %v, want %v", err, errDuplicateTransaction)
+	}
+	if err := pool.add(newTestTransaction(2, alice, bob, 2, 1, 0)); err != errNonceUsed {
+		t.Fatalf("same nonce: got %v, want %v", err, errNonceUsed)
+	}
+	if err := pool.add(newTestTransaction(3, alice, bob, 1, 1, 1)); err != nil {
+		t.Fatalf("next nonce: got %v, want nil", err)
+	}
+}
+
+func TestMempoolEvictsLowestFee(t *testing.T) {
+	previous := mempoolCapacity
+	t.Cleanup(func() { mempoolCapacity = previous })
+	mempoolCapacity = 2
+
+	alice := newTestUser(t, "alice", 100)
+	bob := newTestUser(t, "bob", 100)
+	pool := newMempool()
+	pool.add(newTestTransaction(1, alice, bob, 1, 1, 0))
+	pool.add(newTestTransaction(2, alice, bob, 1, 3, 1))
+	if err := pool.add(newTestTransaction(3, bob, alice, 1, 1, 0)); err != errMempoolFull {
+		t.Fatalf("equal fee: got %v, want %v", err, errMempoolFull)
+	}
+	if err := pool.add(newTestTransaction(4, bob, alice, 1, 2, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pool.pending[1]; ok || pool.evicted != 1 {
+		t.Fatal("lowest fee transaction was not evicted")
+	}
+}
+
+func TestMempoolExpiresOnSimulatedClock(t *testing.T) {
+	alice := newTestUser(t, "alice", 100)
+	bob := newTestUser(t, "bob", 100)
+	pool := newMempool()
+	pool.add(newTestTransaction(1, alice, bob, 1, 1, 0))
+
+	advanceClock(t, time.Duration(mempoolTTL-1)*time.Second)
+	if len(pool.candidates(nil)) != 1 {
+		t.Fatal("transaction expired before the TTL")
+	}
+	advanceClock(t, 2*time.Second)
+	if len(pool.candidates(nil)) != 0 || pool.expired != 1 {
+		t.Fatal("transaction did not expire after the TTL")
+	}
+}
+
+func TestRecordInclusionsPrunesSubmissions(t *testing.T) {
+	alice := newTestUser(t, "alice", 100)
+	bob := newTestUser(t, "bob", 100)
+	previousChain, previousHeight := CertifiedBlockchain, inclusionHeight
+	previousSubmitted, previousDelays := transactionSubmitted, inclusionDelays
+	transactionSubmitted = make(map[int]time.Duration)
+	inclusionDelays = make(map[int][]float64)
+	t.Cleanup(func() {
+		CertifiedBlockchain, inclusionHeight = previousChain, previousHeight
+		transactionSubmitted, inclusionDelays = previousSubmitted, previousDelays
+	})
+
+	genesis := newTestGenesis()
+	CertifiedBlockchain = []Block{genesis}
+	inclusionHeight = 1
+	block := newTestBlock(genesis, "proposer", []Transaction{newTestTransaction(1, alice, bob, 1, 1, 0)})
+	recordTransactionSubmitted(1)
+	recordTransactionSubmitted(2)
+	advanceClock(t, 2*time.Second)
+	CertifiedBlockchain = append(CertifiedBlockchain, block)
+	recordInclusions()
+
+	if len(inclusionDelays[1]) != 1 || inclusionDelays[1][0] < 2 {
+		t.Fatalf("got delays %v, want one of at least 2 seconds", inclusionDelays)
+	}
+	if _, ok := transactionSubmitted[1]; ok {
+		t.Fatal("included transaction was not pruned")
+	}
+	advanceClock(t, time.Duration(mempoolTTL)*time.Second)
+	recordInclusions()
+	if len(transactionSubmitted) != 0 {
+		t.Fatal("submission older than the mempool TTL was not pruned")
+	}
+}
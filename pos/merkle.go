@@ -0,0 +1,107 @@
+package pos
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Leaf and node hashes are domain separated so a leaf can never be passed off as an inner node
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+func merkleLeafHash(leaf []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{merkleLeafPrefix})
+	h.Write(leaf)
+	return h.Sum(nil)
+}
+
+func merkleNodeHash(left []byte, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{merkleNodePrefix})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// merkleParents hashes a level of the tree in pairs, promoting an unpaired last node unchanged
+// Duplicating it instead would give the leaves [a b c] and [a b c c] the same root (CVE-2012-2459)
+func merkleParents(level [][]byte) [][]byte {
+	next := make([][]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			continue
+		}
+		next = append(next, merkleNodeHash(level[i], level[i+1]))
+	}
+	return next
+}
+
+// merkleRoot returns the hex root of a binary Merkle tree
+func merkleRoot(leaves [][]byte) string {
+	if len(leaves) == 0 {
+		empty := sha256.Sum256(nil)
+		return hex.EncodeToString(empty[:])
+	}
+	level := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = merkleLeafHash(leaf)
+	}
+	for len(level) > 1 {
+		level = merkleParents(level)
+	}
+	return hex.EncodeToString(level[0])
+}
+
+// merkleProofStep is a sibling hash on the path from a leaf to the root
+type merkleProofStep struct {
+	Sibling string
+	Left    bool
+}
+
+type merkleProof struct {
+	Index int
+	Steps []merkleProofStep
+}
+
+// merkleProofFor builds the inclusion proof for the leaf at index, following the same tree shape as merkleRoot
+func merkleProofFor(leaves [][]byte, index int) merkleProof {
+	proof := merkleProof{Index: index, Steps: make([]merkleProofStep, 0)}
+	level := make([][]byte, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = merkleLeafHash(leaf)
+	}
+	position := index
+	for len(level) > 1 {
+		//a promoted node has no sibling on this level
+		if sibling := position ^ 1; sibling < len(level) {
+			proof.Steps = append(proof.Steps, merkleProofStep{
+				Sibling: hex.EncodeToString(level[sibling]),
+				Left:    sibling < position,
+			})
+		}
+		level = merkleParents(level)
+		position /= 2
+	}
+	return proof
+}
+
+// verifyMerkleProof recomputes the root from a leaf and its proof
+func verifyMerkleProof(root string, leaf []byte, proof merkleProof) bool {
+	current := merkleLeafHash(leaf)
+	for _, step := range proof.Steps {
+		sibling, err := hex.DecodeString(step.Sibling)
+		if err != nil {
+			return false
+		}
+		if step.Left {
+			current = merkleNodeHash(sibling, current)
+		} else {
+			current = merkleNodeHash(current, sibling)
+		}
+	}
+	return hex.EncodeToString(current) == root
+}
@@ -0,0 +1,41 @@
+package pos
+
+import (
+	"fmt"
+	"testing"
+)
+
+func testLeaves(count int) [][]byte {
+	leaves := make([][]byte, count)
+	for i := range leaves {
+		leaves[i] = []byte(fmt.Sprintf("leaf %d", i))
+	}
+	return leaves
+}
+
+func TestMerkleRootDoesNotDuplicateOddNodes(t *testing.T) {
+	leaves := testLeaves(3)
+	duplicated := append(testLeaves(3), leaves[2])
+	if merkleRoot(leaves) == merkleRoot(duplicated) {
+		t.Fatal("three leaves and the same leaves with the last one repeated have the same root")
+	}
+	if merkleRoot(testLeaves(5)) == merkleRoot(append(testLeaves(5), []byte("leaf 4"))) {
+		t.Fatal("five leaves and the same leaves with the last one repeated have the same root")
+	}
+}
+
+func TestMerkleProofs(t *testing.T) {
+	for count := 1; count <= 9; count++ {
+		leaves := testLeaves(count)
+		root := merkleRoot(leaves)
+		for index := range leaves {
+			proof := merkleProofFor(leaves, index)
+			if !verifyMerkleProof(root, leaves[index], proof) {
+				t.Fatalf("%d leaves: proof for leaf %d does not verify", count, index)
+			}
+			if verifyMerkleProof(root, []byte("other"), proof) {
+				t.Fatalf("%d leaves: proof for leaf %d verifies another leaf", count, index)
+			}
+		}
+	}
+}
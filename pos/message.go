@@ -15,12 +15,18 @@ type ValidateShortAttackBlockMessage struct {
 }
 
 type ValidationStatusMessage struct {
-	isValid bool
+	hash      string
+	isValid   bool
+	signature string
 }
 
 type ValidationShortAttackStatusMessage struct {
-	isValid    bool
-	isValidTwo bool
+	hash         string
+	hashTwo      string
+	isValid      bool
+	isValidTwo   bool
+	signature    string
+	signatureTwo string
 }
 
 type ValidationForkedChainStatusMessage struct {
@@ -34,16 +40,20 @@ type NewTransactionMessage struct {
 type VerifiedBlockMessage struct {
 	transactions []Transaction
 	newBlock     Block
+	//validators the block is gossiped to, everyone when nil
+	side []*Validator
 }
 
 type VerifiedShortAttackBlockMessage struct {
 	transactions []Transaction
 	newBlock     Block
+	side         []*Validator
 }
 
 type VerifiedShortAttackBlockTwoMessage struct {
 	transactions []Transaction
 	newBlockTwo  Block
+	side         []*Validator
 }
 
 // type ConsensusMessage struct {
@@ -59,3 +69,41 @@ type DelegateVoteRequestMessage struct {
 type DelegateVoteMessage struct {
 	delegateVotes []*Validator
 }
+
+type LightHeaderMessage struct {
+	header            BlockHeader
+	proposerSignature string
+	certificate       CommitCertificate
+	payments          []Transaction
+	proofs            []merkleProof
+}
+
+type CaughtUpMessage struct{}
+
+type LightSyncMessage struct {
+	headers []BlockHeader
+}
+
+type HeadersRequestMessage struct {
+	id        int
+	requester *Validator
+	from      int
+}
+
+type HeadersMessage struct {
+	id        int
+	responder *Validator
+	headers   []BlockHeader
+}
+
+type BlocksRequestMessage struct {
+	id        int
+	requester *Validator
+	hashes    []string
+}
+
+type BlocksMessage struct {
+	id        int
+	responder *Validator
+	blocks    []Block
+}
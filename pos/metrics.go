@@ -0,0 +1,218 @@
+package pos
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The HTTP API serves Prometheus metrics at /metrics in the text exposition format, so long runs
+// can be watched in Grafana. Block, slash and reorg counters come from the event bus; the fork
+// counter and the chain, mempool, stake and reputation gauges come from the view the simulation
+// publishes after every round, the same one the JSON API serves.
+
+// Upper bounds in seconds of the round duration histogram buckets
+var roundBuckets = []float64{0.5, 1, 1.5, 2, 3, 5, 10, 30}
+
+// roundTimer is a histogram of the wall clock time rounds take
+type roundTimer struct {
+	lock    sync.Mutex
+	last    time.Time
+	buckets []int
+	count   int
+	sum     float64
+}
+
+var roundDurations = &roundTimer{buckets: make([]int, len(roundBuckets))}
+
+func loadMetricsConfig() {
+	bounds := envString("METRICS_ROUND_BUCKETS", "")
+	if bounds == "" {
+		roundDurations.last = time.Now()
+		return
+	}
+	parsed := make([]float64, 0)
+	for _, field := range strings.Split(bounds, ",") {
+		bound, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || bound <= 0 {
+			fmt.Printf("METRICS_ROUND_BUCKETS entry %s is not a positive number of seconds\n", field)
+			continue
+		}
+		parsed = append(parsed, bound)
+	}
+	if len(parsed) > 0 {
+		sort.Float64s(parsed)
+		roundBuckets = parsed
+		roundDurations.buckets = make([]int, len(roundBuckets))
+	}
+	roundDurations.last = time.Now()
+}
+
+// observeRound records how long the round that just ended took
+func observeRound() {
+	roundDurations.lock.Lock()
+	defer roundDurations.lock.Unlock()
+	now := time.Now()
+	seconds := now.Sub(roundDurations.last).Seconds()
+	roundDurations.last = now
+	roundDurations.count++
+	roundDurations.sum += seconds
+	for i, bound := range roundBuckets {
+		if seconds <= bound {
+			roundDurations.buckets[i]++
+		}
+	}
+}
+
+// metricsWriter writes metric families in the Prometheus text format
+type metricsWriter struct {
+	b strings.Builder
+}
+
+func (m *metricsWriter) family(name string, kind string, help string) {
+	fmt.Fprintf(&m.b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes one sample, labels given as name, value pairs
+func (m *metricsWriter) sample(name string, value float64, labels ...string) {
+	m.b.WriteString(name)
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", labels[i], escapeLabel(labels[i+1])))
+		}
+		m.b.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	m.b.WriteString(" " + strconv.FormatFloat(value, 'g', -1, 64) + "\n")
+}
+
+func (m *metricsWriter) single(name string, kind string, help string, value float64) {
+	m.family(name, kind, help)
+	m.sample(name, value)
+}
+
+func escapeLabel(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "\"", `\"`)
+	return strings.ReplaceAll(value, "\n", `\n`)
+}
+
+func boolLabel(value bool) string {
+	return strconv.FormatBool(value)
+}
+
+func boolValue(value bool) float64 {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+// serveMetrics writes the metrics of the simulation, GET /metrics
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if !allowGet(w, r) {
+		return
+	}
+	m := &metricsWriter{}
+	view := currentView()
+
+	events.lock.Lock()
+	counts := make(map[EventType]int)
+	for eventType, count := range events.counts {
+		counts[eventType] = count
+	}
+	slashes := make(map[string]int)
+	for reason, count := range events.slashes {
+		slashes[reason] = count
+	}
+	orphaned := events.orphaned
+	events.lock.Unlock()
+
+	m.single("pos_rounds_total", "counter", "Rounds completed.", float64(view.round))
+	m.single("pos_blocks_proposed_total", "counter", "Blocks proposed to a committee, both blocks of a partition attack counted once.", float64(counts[BlockProposed]))
+	m.single("pos_blocks_accepted_total", "counter", "Blocks the committee accepted.", float64(counts[BlockAccepted]))
+	m.single("pos_blocks_rejected_total", "counter", "Blocks the committee rejected.", float64(counts[BlockRejected]))
+	m.single("pos_votes_total", "counter", "Committee votes that arrived before the deadline.", float64(counts[VoteCast]))
+	m.single("pos_forks_total", "counter", "Forks created by a proposer getting conflicting blocks accepted.", float64(view.forks))
+	m.single("pos_consensus_reorgs_total", "counter", "Validators that dropped blocks when switching to the consensus chain.", float64(counts[ConsensusReorg]))
+	m.single("pos_reorged_blocks_total", "counter", "Blocks dropped when switching to the consensus chain.", float64(orphaned))
+	m.family("pos_slashes_total", "counter", "Slashing events by reason.")
+	reasons := make([]string, 0, len(slashes))
+	for reason := range slashes {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		m.sample("pos_slashes_total", float64(slashes[reason]), "reason", reason)
+	}
+
+	maliciousBlocks, transactions := 0, 0
+	for _, block := range view.certified {
+		if block.IsMalicious {
+			maliciousBlocks++
+		}
+		transactions += len(block.Transactions)
+	}
+	m.single("pos_certified_blocks", "gauge", "Blocks in the certified chain.", float64(len(view.certified)))
+	m.single("pos_certified_malicious_blocks", "gauge", "Malicious blocks in the certified chain.", float64(maliciousBlocks))
+	m.single("pos_certified_transactions", "gauge", "Transactions in the certified chain.", float64(transactions))
+	m.single("pos_forked", "gauge", "Whether the chain is forked until the next checkpoint.", boolValue(view.forked))
+
+	m.family("pos_validators", "gauge", "Validators that joined.")
+	counted := map[bool]int{false: 0, true: 0}
+	for _, validator := range view.validators {
+		counted[validator.Malicious]++
+	}
+	for _, malicious := range []bool{false, true} {
+		m.sample("pos_validators", float64(counted[malicious]), "malicious", boolLabel(malicious))
+	}
+
+	m.family("pos_mempool_pending", "gauge", "Pending transactions in each validator's mempool.")
+	for _, validator := range view.validators {
+		m.sample("pos_mempool_pending", float64(validator.Pending), "validator", validator.Name)
+	}
+
+	stakes := make([]float64, 0, len(view.validators))
+	reputations := make([]float64, 0, len(view.validators))
+	malicious := make([]bool, 0, len(view.validators))
+	m.family("pos_validator_stake", "gauge", "Stake of each validator.")
+	for _, validator := range view.validators {
+		stakes = append(stakes, validator.Stake)
+		reputations = append(reputations, validator.Reputation)
+		malicious = append(malicious, validator.Malicious)
+		m.sample("pos_validator_stake", validator.Stake, "validator", validator.Name, "malicious", boolLabel(validator.Malicious))
+	}
+	m.family("pos_validator_reputation", "gauge", "Reputation of each validator.")
+	for _, validator := range view.validators {
+		m.sample("pos_validator_reputation", validator.Reputation, "validator", validator.Name, "malicious", boolLabel(validator.Malicious))
+	}
+	for _, distribution := range []struct {
+		name   string
+		values []float64
+	}{{"stake", stakes}, {"reputation", reputations}} {
+		c := measureConcentration(distribution.values, malicious)
+		m.single("pos_"+distribution.name+"_gini", "gauge", "Gini coefficient of the "+distribution.name+" distribution.", c.gini)
+		m.single("pos_"+distribution.name+"_herfindahl", "gauge", "Herfindahl index of the "+distribution.name+" distribution.", c.herfindahl)
+		m.single("pos_"+distribution.name+"_malicious_share", "gauge", "Share of "+distribution.name+" held by malicious validators.", c.maliciousShare)
+		m.family("pos_"+distribution.name+"_nakamoto_coefficient", "gauge", "Largest validators needed to hold more than the threshold of "+distribution.name+".")
+		m.sample("pos_"+distribution.name+"_nakamoto_coefficient", float64(c.nakamotoThird), "threshold", "1/3")
+		m.sample("pos_"+distribution.name+"_nakamoto_coefficient", float64(c.nakamotoHalf), "threshold", "1/2")
+	}
+
+	roundDurations.lock.Lock()
+	m.family("pos_round_duration_seconds", "histogram", "Wall clock time rounds take.")
+	for i, bound := range roundBuckets {
+		m.sample("pos_round_duration_seconds_bucket", float64(roundDurations.buckets[i]), "le", strconv.FormatFloat(bound, 'g', -1, 64))
+	}
+	m.sample("pos_round_duration_seconds_bucket", float64(roundDurations.count), "le", "+Inf")
+	m.sample("pos_round_duration_seconds_sum", roundDurations.sum)
+	m.sample("pos_round_duration_seconds_count", float64(roundDurations.count))
+	roundDurations.lock.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(m.b.String()))
+}
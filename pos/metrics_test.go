@@ -0,0 +1,43 @@
+package pos
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsServePublishedView(t *testing.T) {
+	previousPort, previousView := httpPort, publishedView
+	previousValidators, previousChain, previousForks := validators, CertifiedBlockchain, forkCount
+	t.Cleanup(func() {
+		httpPort, publishedView = previousPort, previousView
+		validators, CertifiedBlockchain, forkCount = previousValidators, previousChain, previousForks
+	})
+	httpPort = "0"
+
+	genesis := newTestGenesis()
+	validator := newTestValidator(t, []Block{genesis})
+	validator.name, validator.Stake, validator.IsMalicious = "validator0", 40, true
+	validator.pool = newMempool()
+	validators = []*Validator{validator}
+	CertifiedBlockchain = []Block{genesis}
+	forkCount = 2
+	publishAPIView()
+	validator.Stake = 8
+	forkCount = 3
+
+	recorder := httptest.NewRecorder()
+	serveMetrics(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := recorder.Body.String()
+	for _, sample := range []string{
+		"pos_forks_total 2\n",
+		"pos_certified_blocks 1\n",
+		`pos_validator_stake{validator="validator0",malicious="true"} 40` + "\n",
+		`pos_validators{malicious="true"} 1` + "\n",
+	} {
+		if !strings.Contains(body, sample) {
+			t.Fatalf("metrics do not contain %q", sample)
+		}
+	}
+}
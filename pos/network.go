@@ -0,0 +1,414 @@
+package pos
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Messages between the server, validators and users pass through simulated links. With the
+// default "none" latency distribution they are delivered instantly, exactly as direct channel sends.
+
+// Latency distributions a link can draw its delay from
+const (
+	noLatency          = "none"
+	constantLatency    = "constant"
+	uniformLatency     = "uniform"
+	normalLatency      = "normal"
+	exponentialLatency = "exponential"
+)
+
+// Name of the global server as a network node
+const serverNode = "server"
+
+var latencyDistribution = noLatency
+
+// Mean one way latency in milliseconds
+var latencyMean = 50.0
+
+// Spread of the latency in milliseconds: the half width of the uniform distribution, the standard
+// deviation of the normal one and a uniform offset in [-jitter, jitter] for the others
+var latencyJitter = 10.0
+
+// Probability that a message is lost
+var dropRate = 0.0
+
+// Bytes a link carries per simulated second, unlimited when 0
+var bandwidth = 0.0
+
+// Milliseconds the server waits for committee votes after sending out a block
+var voteDeadline = 500.0
+
+// Simulated seconds that pass per real second
+var clockScale = 1.0
+
+func loadNetworkConfig() {
+	latencyDistribution = envString("LATENCY_DISTRIBUTION", latencyDistribution)
+	switch latencyDistribution {
+	case noLatency, constantLatency, uniformLatency, normalLatency, exponentialLatency:
+	default:
+		fmt.Printf("Unknown LATENCY_DISTRIBUTION %s, using %s\n", latencyDistribution, noLatency)
+		latencyDistribution = noLatency
+	}
+	latencyMean = envFloat("LATENCY_MEAN", latencyMean)
+	latencyJitter = envFloat("LATENCY_JITTER", latencyJitter)
+	dropRate = envFloat("DROP_RATE", dropRate)
+	bandwidth = envFloat("BANDWIDTH", bandwidth)
+	voteDeadline = envFloat("VOTE_DEADLINE", voteDeadline)
+	clockScale = envFloat("CLOCK_SCALE", clockScale)
+	if clockScale <= 0 {
+		fmt.Println("CLOCK_SCALE must be positive, using 1")
+		clockScale = 1
+	}
+}
+
+// networkEnabled reports whether messages are delayed and dropped at all
+func networkEnabled() bool {
+	return latencyDistribution != noLatency || dropRate > 0 || bandwidth > 0 || partitionScheduled() || faultScheduled()
+}
+
+// simClock is the simulated time, running clockScale times as fast as real time
+type simClock struct {
+	start time.Time
+}
+
+var clock = &simClock{start: time.Now()}
+
+// now is the simulated time since the simulation started
+func (c *simClock) now() time.Duration {
+	return time.Duration(float64(time.Since(c.start)) * clockScale)
+}
+
+// realDuration converts a simulated duration to the real time it takes
+func (c *simClock) realDuration(d time.Duration) time.Duration {
+	return time.Duration(float64(d) / clockScale)
+}
+
+func (c *simClock) sleep(d time.Duration) {
+	time.Sleep(c.realDuration(d))
+}
+
+func milliseconds(ms float64) time.Duration {
+	return time.Duration(ms * float64(time.Millisecond))
+}
+
+// delivery is a message in flight, handed to its destination's mailbox at simulated time at
+type delivery struct {
+	at      time.Duration
+	seq     int
+	mailbox string
+	deliver func()
+}
+
+type deliveryQueue []*delivery
+
+func (q deliveryQueue) Len() int { return len(q) }
+func (q deliveryQueue) Less(i, j int) bool {
+	if q[i].at != q[j].at {
+		return q[i].at < q[j].at
+	}
+	return q[i].seq < q[j].seq
+}
+func (q deliveryQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *deliveryQueue) Push(x interface{}) { *q = append(*q, x.(*delivery)) }
+func (q *deliveryQueue) Pop() interface{} {
+	old := *q
+	d := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return d
+}
+
+type network struct {
+	lock      sync.Mutex
+	queue     deliveryQueue
+	seq       int
+	wake      chan struct{}
+	mailboxes map[string]chan func()
+	//time each link finishes sending its previous message, for the bandwidth limit
+	linkFreeAt map[string]time.Duration
+
+	sent       int
+	dropped    int
+	delivered  int
+	totalDelay time.Duration
+	votesLate  int
+}
+
+var simNetwork = &network{
+	wake:       make(chan struct{}, 1),
+	mailboxes:  make(map[string]chan func()),
+	linkFreeAt: make(map[string]time.Duration),
+}
+
+var startNetworkOnce = &sync.Once{}
+
+// startNetwork runs the goroutine that hands messages to their destinations once they arrive
+func startNetwork() {
+	if !networkEnabled() && !gossipEnabled() {
+		return
+	}
+	startNetworkOnce.Do(func() {
+		go simNetwork.dispatch()
+	})
+}
+
+func (n *network) dispatch() {
+	for {
+		n.lock.Lock()
+		if len(n.queue) == 0 {
+			n.lock.Unlock()
+			<-n.wake
+			continue
+		}
+		next := n.queue[0]
+		wait := next.at - clock.now()
+		if wait > 0 {
+			n.lock.Unlock()
+			select {
+			case <-time.After(clock.realDuration(wait)):
+			case <-n.wake:
+			}
+			continue
+		}
+		heap.Pop(&n.queue)
+		mailbox := n.mailboxLocked(next.mailbox)
+		n.delivered++
+		n.lock.Unlock()
+		mailbox <- next.deliver
+	}
+}
+
+// mailboxLocked returns the queue of arrived messages for a destination, delivering them in order
+// so a destination that is slow to read does not hold up the others
+func (n *network) mailboxLocked(name string) chan func() {
+	mailbox, ok := n.mailboxes[name]
+	if !ok {
+		mailbox = make(chan func(), 4096)
+		n.mailboxes[name] = mailbox
+		go func() {
+			for deliver := range mailbox {
+				deliver()
+			}
+		}()
+	}
+	return mailbox
+}
+
+// sampleLatency draws a one way delay from the configured distribution
+func sampleLatency() time.Duration {
+	latency := latencyMean
+	switch latencyDistribution {
+	case noLatency:
+		latency = 0
+	case uniformLatency:
+		latency = latencyMean + (rand.Float64()*2-1)*latencyJitter
+	case normalLatency:
+		latency = latencyMean + rand.NormFloat64()*latencyJitter
+	case exponentialLatency:
+		latency = rand.ExpFloat64()*latencyMean + (rand.Float64()*2-1)*latencyJitter
+	default:
+		latency = latencyMean + (rand.Float64()*2-1)*latencyJitter
+	}
+	return milliseconds(math.Max(0, latency))
+}
+
+// send puts a message on the link from -> to; deliver runs in the mailbox's goroutine when it
+// arrives, and never if the message is dropped, a partition separates the two nodes or either
+// of them has crashed. Injected faults may hand deliver a corrupted copy of the message.
+func (n *network) send(from string, to string, mailbox string, msg interface{}, deliver func(msg interface{})) {
+	if !reachable(from, to) || !faultReachable(from, to) {
+		return
+	}
+	msg, delay := injectMessageFaults(msg)
+	size := messageSize(msg)
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.sent++
+	if rand.Float64() < dropRate {
+		n.dropped++
+		return
+	}
+
+	now := clock.now()
+	start := now
+	if bandwidth > 0 {
+		link := from + "->" + to
+		if n.linkFreeAt[link] > start {
+			start = n.linkFreeAt[link]
+		}
+		start += time.Duration(float64(size) / bandwidth * float64(time.Second))
+		n.linkFreeAt[link] = start
+	}
+	at := start + sampleLatency() + delay
+	n.totalDelay += at - now
+
+	n.seq++
+	heap.Push(&n.queue, &delivery{at: at, seq: n.seq, mailbox: mailbox, deliver: func() { deliver(msg) }})
+	select {
+	case n.wake <- struct{}{}:
+	default:
+	}
+}
+
+// messageSize approximates the bytes a message takes on the wire
+func messageSize(msg interface{}) int {
+	switch msg := msg.(type) {
+	case ValidateBlockMessage:
+		return len(encodeBlock(msg.newBlock)) + 1
+	case ValidateShortAttackBlockMessage:
+		return len(encodeBlock(msg.newBlock)) + len(encodeBlock(msg.newBlockTwo))
+	case VerifiedBlockMessage:
+		return len(encodeBlock(msg.newBlock))
+	case VerifiedShortAttackBlockMessage:
+		return len(encodeBlock(msg.newBlock))
+	case VerifiedShortAttackBlockTwoMessage:
+		return len(encodeBlock(msg.newBlockTwo))
+	case ValidationStatusMessage:
+		return len(msg.hash) + len(msg.signature) + 1
+	case ValidationShortAttackStatusMessage:
+		return len(msg.hash) + len(msg.signature) + len(msg.signatureTwo) + 2
+	case NewTransactionMessage:
+		return len(encodeTransaction(msg.transaction))
+	case HeadersMessage:
+		size := 0
+		for _, header := range msg.headers {
+			size += len(headerHashData(header)) + len(header.Hash)
+		}
+		return size
+	case BlocksRequestMessage:
+		return len(msg.hashes) * 64
+	case BlocksMessage:
+		size := 0
+		for _, block := range msg.blocks {
+			size += len(encodeBlock(block))
+		}
+		return size
+	}
+	return 64
+}
+
+// messageOrigin is the node a message to a validator comes from: the proposer for its blocks,
+// the server otherwise
+func messageOrigin(msg interface{}) string {
+	switch msg := msg.(type) {
+	case ValidateBlockMessage:
+		return msg.newBlock.Validator
+	case ValidateShortAttackBlockMessage:
+		return msg.newBlock.Validator
+	case VerifiedBlockMessage:
+		return msg.newBlock.Validator
+	case VerifiedShortAttackBlockMessage:
+		return msg.newBlock.Validator
+	case VerifiedShortAttackBlockTwoMessage:
+		return msg.newBlockTwo.Validator
+	}
+	return serverNode
+}
+
+// sendToValidator delivers a message from the server or a block's proposer to a validator's incoming channel
+func sendToValidator(validator *Validator, msg interface{}) {
+	if !networkEnabled() {
+		validator.incomingChannel <- msg
+		return
+	}
+	simNetwork.send(messageOrigin(msg), validator.Address, "in/"+validator.Address, msg, func(msg interface{}) {
+		validator.incomingChannel <- msg
+	})
+}
+
+// sendTransaction delivers a transaction from a user or the attacker to a validator
+func sendTransaction(from string, validator *Validator, msg NewTransactionMessage) {
+	if !networkEnabled() {
+		validator.transactionChannel <- msg
+		return
+	}
+	simNetwork.send(from, validator.Address, "tx/"+validator.Address, msg, func(msg interface{}) {
+		validator.transactionChannel <- msg.(NewTransactionMessage)
+	})
+}
+
+// replyToServer delivers a validator's vote on a block to the server, by way of the block's proposer
+func replyToServer(validator *Validator, proposer string, msg interface{}) {
+	if !networkEnabled() {
+		validator.outgoingChannel <- msg
+		return
+	}
+	simNetwork.send(validator.Address, proposer, "out/"+validator.Address, msg, func(msg interface{}) {
+		validator.outgoingChannel <- msg
+	})
+}
+
+// voteDeadlineFromNow is when the server stops waiting for the votes on a block it just sent out
+func voteDeadlineFromNow() time.Duration {
+	return clock.now() + milliseconds(voteDeadline)
+}
+
+// receiveVote waits for a validator's vote on the block with the given hash until the deadline,
+// returning nil if it was lost or arrives too late. Late votes on earlier blocks are discarded.
+func receiveVote(validator *Validator, hash string, deadline time.Duration) interface{} {
+	msg := awaitVote(validator, hash, deadline)
+	if msg == nil {
+		return nil
+	}
+	return checkVoteSignatures(validator, msg)
+}
+
+func awaitVote(validator *Validator, hash string, deadline time.Duration) interface{} {
+	if !networkEnabled() {
+		return <-validator.outgoingChannel
+	}
+	for {
+		wait := deadline - clock.now()
+		if wait <= 0 {
+			//votes that arrived before the deadline are still waiting to be read
+			select {
+			case msg := <-validator.outgoingChannel:
+				if voteHash(msg) == hash {
+					return msg
+				}
+				continue
+			default:
+			}
+			simNetwork.lock.Lock()
+			simNetwork.votesLate++
+			simNetwork.lock.Unlock()
+			recordMissedVote(validator)
+			return nil
+		}
+		select {
+		case msg := <-validator.outgoingChannel:
+			if voteHash(msg) == hash {
+				return msg
+			}
+		case <-time.After(clock.realDuration(wait)):
+		}
+	}
+}
+
+func voteHash(msg interface{}) string {
+	switch msg := msg.(type) {
+	case ValidationStatusMessage:
+		return msg.hash
+	case ValidationShortAttackStatusMessage:
+		return msg.hash
+	}
+	return ""
+}
+
+func printNetworkEvaluation() {
+	if !networkEnabled() {
+		return
+	}
+	simNetwork.lock.Lock()
+	defer simNetwork.lock.Unlock()
+	averageDelay := 0.0
+	if simNetwork.sent > simNetwork.dropped {
+		averageDelay = float64(simNetwork.totalDelay.Milliseconds()) / float64(simNetwork.sent-simNetwork.dropped)
+	}
+	fmt.Printf("Network latency: %s, mean: %f ms, jitter: %f ms, drop rate: %f, bandwidth: %f bytes/s\n", latencyDistribution, latencyMean, latencyJitter, dropRate, bandwidth)
+	fmt.Printf("Messages sent: %d, dropped: %d, delivered: %d, in flight: %d, average delay: %f ms\n", simNetwork.sent, simNetwork.dropped, simNetwork.delivered, len(simNetwork.queue), averageDelay)
+	fmt.Printf("Votes missing at the deadline: %d\n", simNetwork.votesLate)
+}
@@ -0,0 +1,109 @@
+package pos
+
+import (
+	"container/heap"
+	"testing"
+	"time"
+)
+
+// useLatencyForTest delays every message by a constant latency on a clock running scale times as fast as real time
+func useLatencyForTest(t *testing.T, latency float64, scale float64) {
+	t.Helper()
+	previousDistribution, previousMean, previousJitter := latencyDistribution, latencyMean, latencyJitter
+	previousDrop, previousBandwidth, previousScale, previousClock := dropRate, bandwidth, clockScale, clock
+	t.Cleanup(func() {
+		latencyDistribution, latencyMean, latencyJitter = previousDistribution, previousMean, previousJitter
+		dropRate, bandwidth, clockScale, clock = previousDrop, previousBandwidth, previousScale, previousClock
+	})
+	latencyDistribution, latencyMean, latencyJitter = constantLatency, latency, 0
+	dropRate, bandwidth, clockScale = 0, 0, scale
+	clock = &simClock{start: time.Now()}
+}
+
+func newTestNetwork() *network {
+	n := &network{
+		wake:       make(chan struct{}, 1),
+		mailboxes:  make(map[string]chan func()),
+		linkFreeAt: make(map[string]time.Duration),
+	}
+	go n.dispatch()
+	return n
+}
+
+func TestDeliveryQueueOrder(t *testing.T) {
+	queue := &deliveryQueue{}
+	for i, at := range []time.Duration{30, 10, 20, 10} {
+		heap.Push(queue, &delivery{at: at, seq: i})
+	}
+	want := []int{1, 3, 2, 0}
+	for _, seq := range want {
+		next := heap.Pop(queue).(*delivery)
+		if next.seq != seq {
+			t.Fatalf("got delivery %d at %d, want %d: earliest arrival first, then send order", next.seq, next.at, seq)
+		}
+	}
+}
+
+func TestSendDeliversOnSimulatedClock(t *testing.T) {
+	useLatencyForTest(t, 200, 20)
+	n := newTestNetwork()
+	type arrival struct {
+		msg interface{}
+		at  time.Duration
+	}
+	arrived := make(chan arrival, 3)
+	sent := clock.now()
+	for i := 0; i < 3; i++ {
+		n.send("a", "b", "in/b", i, func(msg interface{}) {
+			arrived <- arrival{msg: msg, at: clock.now()}
+		})
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case got := <-arrived:
+			if got.msg != i {
+				t.Fatalf("got message %v, want %d: messages on a link arrive in the order they were sent", got.msg, i)
+			}
+			if got.at-sent < milliseconds(200) {
+				t.Fatalf("message %d arrived after %s, want at least the 200ms latency", i, got.at-sent)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("message %d never arrived", i)
+		}
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.sent != 3 || n.delivered != 3 {
+		t.Fatalf("got %d sent and %d delivered, want 3 and 3", n.sent, n.delivered)
+	}
+}
+
+func TestAwaitVoteDeadline(t *testing.T) {
+	useLatencyForTest(t, 0, 1)
+	validator := &Validator{Address: "voter", outgoingChannel: make(chan interface{}, 2)}
+
+	//a late vote on an earlier block is skipped
+	validator.outgoingChannel <- ValidationStatusMessage{hash: "earlier", isValid: true}
+	validator.outgoingChannel <- ValidationStatusMessage{hash: "current", isValid: true}
+	msg := awaitVote(validator, "current", voteDeadlineFromNow())
+	if vote, ok := msg.(ValidationStatusMessage); !ok || vote.hash != "current" {
+		t.Fatalf("got %+v, want the vote on the current block", msg)
+	}
+
+	simNetwork.lock.Lock()
+	late := simNetwork.votesLate
+	simNetwork.lock.Unlock()
+	deadline := clock.now() + 20*time.Millisecond
+	if msg := awaitVote(validator, "current", deadline); msg != nil {
+		t.Fatalf("got %+v without a vote being sent", msg)
+	}
+	if clock.now() < deadline {
+		t.Fatal("stopped waiting before the deadline")
+	}
+	simNetwork.lock.Lock()
+	defer simNetwork.lock.Unlock()
+	if simNetwork.votesLate != late+1 {
+		t.Fatalf("got %d late votes, want %d", simNetwork.votesLate, late+1)
+	}
+}
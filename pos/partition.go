@@ -0,0 +1,292 @@
+package pos
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// partitionEvent splits the validators into groups of the given relative sizes at a simulated
+// time, or heals the network when it has no sizes
+type partitionEvent struct {
+	at    time.Duration
+	sizes []float64
+}
+
+// partitionHeal records what a heal left behind
+type partitionHeal struct {
+	at        time.Duration
+	groups    int
+	duration  time.Duration
+	forkDepth int
+	//validators that switched chains and the blocks they dropped, known after the next checkpoint
+	reorgs    int
+	reorged   int
+	maxReorg  int
+	completed bool
+}
+
+// Parsed PARTITION_SCHEDULE, in time order
+var partitionSchedule = make([]partitionEvent, 0)
+
+// Whether PARTITION_SCHEDULE had any events, so messages go through the simulated network
+var partitionEnabled = false
+
+var partitionLock = &sync.Mutex{}
+
+// Group of each validator address while the network is split, nil when it is whole
+var partitionGroups map[string]int
+
+var partitionGroupCount = 0
+var partitionStarted time.Duration
+var partitionHeals = make([]*partitionHeal, 0)
+var partitionDropped = 0
+
+// loadPartitionConfig parses PARTITION_SCHEDULE, e.g. "10:50,30,20;40:heal" splits the validators
+// 50/30/20 after 10 simulated seconds and heals the network after 40
+func loadPartitionConfig() {
+	schedule := envString("PARTITION_SCHEDULE", "")
+	if schedule == "" {
+		return
+	}
+	for _, entry := range strings.Split(schedule, ";") {
+		fields := strings.SplitN(strings.TrimSpace(entry), ":", 2)
+		if len(fields) != 2 {
+			fmt.Printf("PARTITION_SCHEDULE entry %s is not time:sizes or time:heal\n", entry)
+			continue
+		}
+		seconds, err := strconv.ParseFloat(strings.TrimSpace(fields[0]), 64)
+		if err != nil || seconds < 0 {
+			fmt.Printf("PARTITION_SCHEDULE time %s is not a number of seconds\n", fields[0])
+			continue
+		}
+		event := partitionEvent{at: time.Duration(seconds * float64(time.Second))}
+		if strings.TrimSpace(fields[1]) != "heal" {
+			for _, field := range strings.Split(fields[1], ",") {
+				size, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+				if err != nil || size <= 0 {
+					fmt.Printf("PARTITION_SCHEDULE group size %s is not a positive number\n", field)
+					event.sizes = nil
+					break
+				}
+				event.sizes = append(event.sizes, size)
+			}
+			if len(event.sizes) < 2 {
+				fmt.Printf("PARTITION_SCHEDULE entry %s needs at least two group sizes\n", entry)
+				continue
+			}
+		}
+		partitionSchedule = append(partitionSchedule, event)
+	}
+	partitionEnabled = len(partitionSchedule) > 0
+	for i := 1; i < len(partitionSchedule); i++ {
+		for j := i; j > 0 && partitionSchedule[j].at < partitionSchedule[j-1].at; j-- {
+			partitionSchedule[j], partitionSchedule[j-1] = partitionSchedule[j-1], partitionSchedule[j]
+		}
+	}
+}
+
+func partitionScheduled() bool {
+	return partitionEnabled
+}
+
+// applyPartitionSchedule splits or heals the network for the events that are due
+func applyPartitionSchedule() {
+	partitionLock.Lock()
+	defer partitionLock.Unlock()
+	now := clock.now()
+	for len(partitionSchedule) > 0 && partitionSchedule[0].at <= now {
+		event := partitionSchedule[0]
+		partitionSchedule = partitionSchedule[1:]
+		if event.sizes == nil {
+			healPartitionLocked(now)
+		} else {
+			if partitionGroups != nil {
+				healPartitionLocked(now)
+			}
+			splitNetworkLocked(event.sizes, now)
+		}
+	}
+}
+
+// splitNetworkLocked assigns validators at random to groups holding the given shares of them
+func splitNetworkLocked(sizes []float64, now time.Duration) {
+	validatorsSliceLock.Lock()
+	members := make([]*Validator, len(validators))
+	copy(members, validators)
+	validatorsSliceLock.Unlock()
+	rand.Shuffle(len(members), func(i, j int) { members[i], members[j] = members[j], members[i] })
+
+	total := 0.0
+	for _, size := range sizes {
+		total += size
+	}
+	partitionGroups = make(map[string]int)
+	partitionGroupCount = len(sizes)
+	partitionStarted = now
+	cumulative := 0.0
+	next := 0
+	for group, size := range sizes {
+		cumulative += size
+		end := int(float64(len(members))*cumulative/total + 0.5)
+		for ; next < end && next < len(members); next++ {
+			partitionGroups[members[next].Address] = group
+		}
+	}
+	counts := make([]int, len(sizes))
+	for _, group := range partitionGroups {
+		counts[group]++
+	}
+	fmt.Printf("Network split into groups of %v validators\n", counts)
+}
+
+// healPartitionLocked reconnects the groups and measures how far their chains have diverged
+func healPartitionLocked(now time.Duration) {
+	if partitionGroups == nil {
+		return
+	}
+	validatorsSliceLock.Lock()
+	members := make([]*Validator, len(validators))
+	copy(members, validators)
+	validatorsSliceLock.Unlock()
+
+	heads := make(map[int][]Block)
+	for _, validator := range members {
+		group, ok := partitionGroups[validator.Address]
+		if !ok {
+			continue
+		}
+		if chain := chainSnapshot(validator); heads[group] == nil || len(chain) > len(heads[group]) {
+			heads[group] = chain
+		}
+	}
+
+	//fork depth is the longest branch a group built past the point it left the longest chain,
+	//which the group has to drop once it hears of the longest chain again
+	var longest []Block
+	for _, head := range heads {
+		if longest == nil || len(head) > len(longest) {
+			longest = head
+		}
+	}
+	forkDepth := 0
+	for _, head := range heads {
+		common := 0
+		for common < len(head) && common < len(longest) && head[common].Hash == longest[common].Hash {
+			common++
+		}
+		if len(head)-common > forkDepth {
+			forkDepth = len(head) - common
+		}
+	}
+
+	partitionHeals = append(partitionHeals, &partitionHeal{
+		at:        now,
+		groups:    partitionGroupCount,
+		duration:  now - partitionStarted,
+		forkDepth: forkDepth,
+	})
+	fmt.Printf("Network healed after %f seconds, fork depth %d\n", (now - partitionStarted).Seconds(), forkDepth)
+	partitionGroups = nil
+	partitionGroupCount = 0
+}
+
+// partitionGroup returns the group of a node, or -1 if it can reach every group
+func partitionGroup(node string) int {
+	if partitionGroups == nil {
+		return -1
+	}
+	group, ok := partitionGroups[node]
+	if !ok {
+		return -1
+	}
+	return group
+}
+
+// reachable reports whether a message can travel between two nodes under the current partition
+func reachable(from string, to string) bool {
+	partitionLock.Lock()
+	defer partitionLock.Unlock()
+	fromGroup := partitionGroup(from)
+	toGroup := partitionGroup(to)
+	if fromGroup == -1 || toGroup == -1 || fromGroup == toGroup {
+		return true
+	}
+	partitionDropped++
+	return false
+}
+
+// consensusChain is the chain a validator adopts at a longest chain checkpoint: the certified
+// chain, or while the network is split the longest chain it can hear of in its own group
+func consensusChain(validator *Validator, certified []Block) []Block {
+	partitionLock.Lock()
+	defer partitionLock.Unlock()
+	group := partitionGroup(validator.Address)
+	if group == -1 {
+		return certified
+	}
+	validatorsSliceLock.Lock()
+	members := make([]*Validator, len(validators))
+	copy(members, validators)
+	validatorsSliceLock.Unlock()
+
+	longest := chainSnapshot(validator)
+	for _, other := range members {
+		if partitionGroup(other.Address) != group || other == validator {
+			continue
+		}
+		if chain := chainSnapshot(other); len(chain) > len(longest) {
+			longest = chain
+		}
+	}
+	return longest
+}
+
+// recordCheckpointReorgs fills in the reorgs of the first checkpoint after a heal
+func recordCheckpointReorgs(reorgs []int) {
+	partitionLock.Lock()
+	defer partitionLock.Unlock()
+	for _, heal := range partitionHeals {
+		if heal.completed {
+			continue
+		}
+		heal.completed = true
+		for _, length := range reorgs {
+			if length == 0 {
+				continue
+			}
+			heal.reorgs++
+			heal.reorged += length
+			if length > heal.maxReorg {
+				heal.maxReorg = length
+			}
+		}
+	}
+}
+
+func printPartitionEvaluation() {
+	partitionLock.Lock()
+	defer partitionLock.Unlock()
+	if len(partitionHeals) == 0 && partitionGroups == nil && partitionDropped == 0 {
+		return
+	}
+	fmt.Printf("Messages dropped by partitions: %d\n", partitionDropped)
+	if partitionGroups != nil {
+		fmt.Printf("Network split into %d groups for %f seconds\n", partitionGroupCount, (clock.now() - partitionStarted).Seconds())
+	}
+	for i, heal := range partitionHeals {
+		fmt.Printf("Heal %d at %f seconds (%s): %d groups split for %f seconds, fork depth %d", i+1, heal.at.Seconds(), blockchainType, heal.groups, heal.duration.Seconds(), heal.forkDepth)
+		if heal.completed {
+			average := 0.0
+			if heal.reorgs > 0 {
+				average = float64(heal.reorged) / float64(heal.reorgs)
+			}
+			fmt.Printf(", %d validators reorganized, average reorg length %f, longest %d\n", heal.reorgs, average, heal.maxReorg)
+		} else {
+			fmt.Println(", waiting for the next checkpoint")
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package pos
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// resetPartitionForTest heals the network and clears the schedule, restoring both when the test ends
+func resetPartitionForTest(t *testing.T) {
+	t.Helper()
+	previousSchedule, previousEnabled, previousGroups := partitionSchedule, partitionEnabled, partitionGroups
+	previousCount, previousStarted, previousHeals := partitionGroupCount, partitionStarted, partitionHeals
+	previousDropped, previousValidators := partitionDropped, validators
+	t.Cleanup(func() {
+		partitionSchedule, partitionEnabled, partitionGroups = previousSchedule, previousEnabled, previousGroups
+		partitionGroupCount, partitionStarted, partitionHeals = previousCount, previousStarted, previousHeals
+		partitionDropped, validators = previousDropped, previousValidators
+	})
+	partitionSchedule, partitionEnabled, partitionGroups = make([]partitionEvent, 0), false, nil
+	partitionGroupCount, partitionHeals, partitionDropped = 0, make([]*partitionHeal, 0), 0
+}
+
+func TestLoadPartitionSchedule(t *testing.T) {
+	resetPartitionForTest(t)
+	t.Setenv("PARTITION_SCHEDULE", "40:heal; 10:50,30,20;20:70;x:1,1;30:1,-1")
+	loadPartitionConfig()
+
+	if !partitionScheduled() || len(partitionSchedule) != 2 {
+		t.Fatalf("got %d events, want the split and the heal", len(partitionSchedule))
+	}
+	split, heal := partitionSchedule[0], partitionSchedule[1]
+	if split.at != 10*time.Second || len(split.sizes) != 3 || split.sizes[0] != 50 || split.sizes[2] != 20 {
+		t.Fatalf("got split %+v, want 50/30/20 after 10s", split)
+	}
+	if heal.at != 40*time.Second || heal.sizes != nil {
+		t.Fatalf("got heal %+v, want a heal after 40s", heal)
+	}
+}
+
+func TestSplitNetworkAndReachable(t *testing.T) {
+	resetPartitionForTest(t)
+	validators = make([]*Validator, 10)
+	for i := range validators {
+		validators[i] = &Validator{Address: fmt.Sprintf("validator%d", i)}
+	}
+	splitNetworkLocked([]float64{50, 30, 20}, 0)
+
+	counts := make([]int, 3)
+	for _, group := range partitionGroups {
+		counts[group]++
+	}
+	if counts[0] != 5 || counts[1] != 3 || counts[2] != 2 {
+		t.Fatalf("got groups of %v, want [5 3 2]", counts)
+	}
+
+	var same, other string
+	for address, group := range partitionGroups {
+		if address == validators[0].Address {
+			continue
+		}
+		if group == partitionGroups[validators[0].Address] {
+			same = address
+		} else {
+			other = address
+		}
+	}
+	if !reachable(validators[0].Address, same) {
+		t.Fatal("validators of the same group cannot reach each other")
+	}
+	if reachable(validators[0].Address, other) || partitionDropped != 1 {
+		t.Fatal("message crossed the partition")
+	}
+	//the server is in no group and reaches every validator
+	if !reachable(serverNode, other) {
+		t.Fatal("server cannot reach a validator")
+	}
+}
+
+func TestHealPartitionMeasuresForkDepth(t *testing.T) {
+	resetPartitionForTest(t)
+	genesis := newTestGenesis()
+	longest := []Block{genesis}
+	for i := 0; i < 3; i++ {
+		longest = append(longest, newTestBlock(longest[len(longest)-1], "a", nil))
+	}
+	branch := []Block{genesis, newTestBlock(genesis, "b", nil)}
+	branch = append(branch, newTestBlock(branch[1], "b", nil))
+
+	a := newTestValidator(t, longest)
+	a.Address = "a"
+	b := newTestValidator(t, branch)
+	b.Address = "b"
+	behind := newTestValidator(t, []Block{genesis})
+	behind.Address = "behind"
+	validators = []*Validator{a, b, behind}
+	partitionGroups = map[string]int{"a": 0, "b": 1, "behind": 1}
+	partitionGroupCount, partitionStarted = 2, 5*time.Second
+
+	//while split, a checkpoint moves each validator to the longest chain of its own group
+	if chain := consensusChain(behind, longest); len(chain) != len(branch) || chain[len(chain)-1].Hash != branch[len(branch)-1].Hash {
+		t.Fatalf("got a chain of %d blocks, want its group's longest", len(chain))
+	}
+
+	healPartitionLocked(15 * time.Second)
+	if partitionGroups != nil || len(partitionHeals) != 1 {
+		t.Fatal("network was not healed")
+	}
+	heal := partitionHeals[0]
+	if heal.forkDepth != 2 || heal.duration != 10*time.Second || heal.groups != 2 {
+		t.Fatalf("got heal %+v, want fork depth 2 after 10s", *heal)
+	}
+	if chain := consensusChain(behind, longest); len(chain) != len(longest) {
+		t.Fatal("healed validator does not adopt the certified chain")
+	}
+}
@@ -0,0 +1,119 @@
+package pos
+
+import (
+	"fmt"
+)
+
+// Recent certified blocks whose transactions are replayed after a reorg
+var replayDepth = 3
+
+// Validator chains seen right before the last consensus checkpoint
+var preConsensusChains = make([][]Block, 0)
+
+var replayReorgs = 0
+var replaySent = 0
+
+func loadReplayConfig() {
+	replayDepth = envInt("REPLAY_DEPTH", replayDepth)
+}
+
+// captureChainsBeforeConsensus remembers every validator's chain so orphaned blocks can be found afterwards
+func captureChainsBeforeConsensus() {
+	preConsensusChains = make([][]Block, 0, len(validators))
+	for _, validator := range validators {
+		preConsensusChains = append(preConsensusChains, validator.Blockchain)
+	}
+}
+
+// replayAfterReorg rebroadcasts confirmed and orphaned transactions, verbatim and under fresh IDs
+func replayAfterReorg() {
+	certified := make(map[string]bool)
+	for _, block := range CertifiedBlockchain {
+		certified[block.Hash] = true
+	}
+
+	seen := make(map[int]bool)
+	candidates := make([]Transaction, 0)
+	for _, chain := range preConsensusChains {
+		for _, block := range chain {
+			if certified[block.Hash] {
+				continue
+			}
+			for _, transaction := range block.Transactions {
+				if !seen[transaction.ID] {
+					seen[transaction.ID] = true
+					candidates = append(candidates, transaction)
+				}
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		return
+	}
+	replayReorgs++
+
+	start := len(CertifiedBlockchain) - replayDepth
+	if start < 0 {
+		start = 0
+	}
+	for _, block := range CertifiedBlockchain[start:] {
+		for _, transaction := range block.Transactions {
+			if !seen[transaction.ID] {
+				seen[transaction.ID] = true
+				candidates = append(candidates, transaction)
+			}
+		}
+	}
+
+	validatorsSliceLock.Lock()
+	validatorsCopy := make([]*Validator, len(validators))
+	copy(validatorsCopy, validators)
+	validatorsSliceLock.Unlock()
+
+	replays := make([]Transaction, 0, 2*len(candidates))
+	for _, transaction := range candidates {
+		transactionIDLock.Lock()
+		freshID := transactionID
+		transactionID++
+		transactionIDLock.Unlock()
+
+		//the ID is not signed, so the copy still carries a valid signature
+		fresh := transaction
+		fresh.ID = freshID
+		replays = append(replays, transaction, fresh)
+	}
+	replaySent += len(replays)
+	fmt.Printf("Replaying %d transactions after reorg\n", len(replays))
+
+	go func() {
+		for _, transaction := range replays {
+			broadcastTransaction(transaction, validatorsCopy)
+		}
+	}()
+}
+
+// duplicateNonces counts transactions that reuse a sender nonce already applied earlier in the chain,
+// the only way a replay can succeed: a copy of an orphaned transaction whose nonce never executed on
+// the winning chain is a legitimate re-inclusion
+func duplicateNonces(chain []Block) int {
+	used := make(map[string]map[int]bool)
+	duplicates := 0
+	for _, block := range chain {
+		for _, transaction := range block.Transactions {
+			address := transaction.Sender.Address
+			if used[address] == nil {
+				used[address] = make(map[int]bool)
+			}
+			if used[address][transaction.Nonce] {
+				duplicates++
+			}
+			used[address][transaction.Nonce] = true
+		}
+	}
+	return duplicates
+}
+
+func printReplayEvaluation() {
+	fmt.Printf("Reorgs replayed: %d, replayed transactions sent: %d\n", replayReorgs, replaySent)
+	fmt.Printf("Replays confirmed (sender nonces applied twice): %d\n", duplicateNonces(CertifiedBlockchain))
+}
@@ -0,0 +1,21 @@
+package pos
+
+import "testing"
+
+func TestDuplicateNoncesIgnoresReinclusion(t *testing.T) {
+	alice := newTestUser(t, "alice", 100)
+	bob := newTestUser(t, "bob", 100)
+	genesis := newTestGenesis()
+
+	//an orphaned transaction included again under a fresh ID is not a replay
+	first := newTestBlock(genesis, "proposer", []Transaction{newTestTransaction(1, alice, bob, 1, 0, 0)})
+	reincluded := newTestBlock(first, "proposer", []Transaction{newTestTransaction(7, alice, bob, 1, 0, 1)})
+	if got := duplicateNonces([]Block{genesis, first, reincluded}); got != 0 {
+		t.Fatalf("got %d replays, want 0", got)
+	}
+
+	replayed := newTestBlock(reincluded, "proposer", []Transaction{newTestTransaction(8, alice, bob, 1, 0, 0)})
+	if got := duplicateNonces([]Block{genesis, first, reincluded, replayed}); got != 1 {
+		t.Fatalf("got %d replays, want 1", got)
+	}
+}
@@ -0,0 +1,218 @@
+package pos
+
+import (
+	"fmt"
+)
+
+// What validators do with their rewards, chosen by REWARD_MODE
+const (
+	compoundRewardMode = "compound"
+	withdrawRewardMode = "withdraw"
+)
+
+// Tokens minted for every accepted block on top of the inflation
+var blockReward = 0.0
+
+// Fraction of the total stake minted per modeled year
+var annualIssuance = 0.0
+
+// Fraction of each slot's issuance paid to the committee members who voted with the majority
+var attestationShare = 0.25
+
+var rewardMode = compoundRewardMode
+
+// Modeled seconds per time slot, used to turn the inflation rate into a per slot issuance and to annualize yields
+var slotSeconds = 12.0
+
+const secondsPerYear = 365 * 24 * 60 * 60
+
+// Total tokens minted by issuance and attestation rewards
+var totalIssued = 0.0
+
+// issuedReward is the proposer issuance paid for a block that could still be orphaned
+type issuedReward struct {
+	proposer *Validator
+	index    int
+	amount   float64
+}
+
+// Issuance of the blocks not yet held by every running validator, so it can be taken back if the block is orphaned
+var blockIssuance = make(map[string]issuedReward)
+
+// Block rewards taken back because their block was orphaned
+var revokedRewards = 0
+var revokedIssuance = 0.0
+
+func loadRewardConfig() {
+	blockReward = envFloat("BLOCK_REWARD", blockReward)
+	annualIssuance = envFloat("ANNUAL_ISSUANCE", annualIssuance)
+	attestationShare = envFloat("ATTESTATION_SHARE", attestationShare)
+	if attestationShare < 0 || attestationShare > 1 {
+		fmt.Println("ATTESTATION_SHARE must be between 0 and 1, using 0.25")
+		attestationShare = 0.25
+	}
+	rewardMode = envString("REWARD_MODE", rewardMode)
+	if rewardMode != compoundRewardMode && rewardMode != withdrawRewardMode {
+		fmt.Printf("Unknown REWARD_MODE %s, using %s\n", rewardMode, compoundRewardMode)
+		rewardMode = compoundRewardMode
+	}
+	slotSeconds = envFloat("SLOT_SECONDS", slotSeconds)
+	if slotSeconds <= 0 {
+		fmt.Println("SLOT_SECONDS must be positive, using 12")
+		slotSeconds = 12
+	}
+}
+
+// slotIssuance is the number of tokens minted in a slot
+func slotIssuance() float64 {
+	totalStake := 0.0
+	for _, validator := range validators {
+		totalStake += validator.Stake
+	}
+	return blockReward + totalStake*annualIssuance*slotSeconds/secondsPerYear
+}
+
+// creditReward pays a validator, adding to its stake or to its withdrawn balance
+// A negative amount takes back a reward, e.g. for a block that was orphaned
+func creditReward(validator *Validator, amount float64) {
+	validator.earned += amount
+	if rewardMode == withdrawRewardMode {
+		validator.withdrawn += amount
+	} else {
+		validator.Stake += amount
+	}
+}
+
+// issueBlockReward mints the proposer's share of the slot issuance for an accepted block
+func issueBlockReward(proposer *Validator, block Block) {
+	amount := slotIssuance() * (1 - attestationShare)
+	if amount <= 0 {
+		return
+	}
+	blockIssuance[block.Hash] = issuedReward{proposer: proposer, index: block.Index, amount: amount}
+	totalIssued += amount
+	creditReward(proposer, amount)
+}
+
+// revokeBlockReward takes back the issuance of a block that left the chain
+func revokeBlockReward(proposer *Validator, block Block) {
+	issued, ok := blockIssuance[block.Hash]
+	if !ok {
+		return
+	}
+	delete(blockIssuance, block.Hash)
+	totalIssued -= issued.amount
+	revokedRewards++
+	revokedIssuance += issued.amount
+	creditReward(proposer, -issued.amount)
+}
+
+// settleBlockRewards runs after a consensus checkpoint. Blocks no running validator holds any more, at heights
+// the certified chain has reached, were orphaned and lose their issuance. Blocks every running validator holds
+// can no longer be orphaned and are forgotten, unless a private chain of the selfish proposing attack may still
+// orphan them when it is released. Blocks held by one side of a partition wait for the heal.
+func settleBlockRewards() {
+	if len(blockIssuance) == 0 {
+		return
+	}
+	validatorsSliceLock.Lock()
+	validatorsCopy := make([]*Validator, len(validators))
+	copy(validatorsCopy, validators)
+	validatorsSliceLock.Unlock()
+
+	holders := make(map[string]int)
+	running := 0
+	for _, validator := range validatorsCopy {
+		if crashed(validator) {
+			continue
+		}
+		running++
+		for _, block := range validator.Blockchain {
+			if _, ok := blockIssuance[block.Hash]; ok {
+				holders[block.Hash]++
+			}
+		}
+	}
+
+	head := CertifiedBlockchain[len(CertifiedBlockchain)-1].Index
+	for hash, issued := range blockIssuance {
+		if holders[hash] == running && !privateChainCanOrphan(issued.index) {
+			delete(blockIssuance, hash)
+		} else if holders[hash] == 0 && issued.index <= head {
+			revokeBlockReward(issued.proposer, Block{Hash: hash})
+		}
+	}
+}
+
+// privateChainCanOrphan reports whether a withheld or not yet settled private chain forks below the index
+func privateChainCanOrphan(index int) bool {
+	return (len(privateChain) > 0 || len(releasedBlocks) > 0) && index > privateBase.Index
+}
+
+// rewardAttesters splits the attestation share of the slot issuance between the committee members
+// whose vote matched the outcome
+func rewardAttesters(committee []*Validator, validationResults map[string]bool, isValid bool) {
+	majority := make([]*Validator, 0)
+	for _, validator := range committee {
+		if vote, voted := validationResults[validator.Address]; voted && vote == isValid {
+			majority = append(majority, validator)
+		}
+	}
+	amount := slotIssuance() * attestationShare
+	if amount <= 0 || len(majority) == 0 {
+		return
+	}
+	totalIssued += amount
+	for _, validator := range majority {
+		validator.attestationCount++
+		creditReward(validator, amount/float64(len(majority)))
+	}
+}
+
+// annualizedYield scales a validator's rewards over the slots run so far to a modeled year
+func annualizedYield(validator *Validator) float64 {
+	if validator.initialStake <= 0 || roundCount == 0 {
+		return 0
+	}
+	return validator.earned / validator.initialStake * secondsPerYear / (float64(roundCount) * slotSeconds)
+}
+
+func printRewardEvaluation() {
+	validatorsSliceLock.Lock()
+	validatorsCopy := make([]*Validator, len(validators))
+	copy(validatorsCopy, validators)
+	validatorsSliceLock.Unlock()
+
+	yields := make(map[string][]float64)
+	earned := make(map[string]float64)
+	attestations := make(map[string]int)
+	initialStake := 0.0
+	for _, validator := range validatorsCopy {
+		label := "honest"
+		if validator.IsMalicious {
+			label = "malicious"
+		}
+		yields[label] = append(yields[label], annualizedYield(validator))
+		earned[label] += validator.earned
+		attestations[label] += validator.attestationCount
+		initialStake += validator.initialStake
+	}
+
+	fmt.Printf("Reward mode: %s, block reward: %f, annual issuance: %f, attestation share: %f\n", rewardMode, blockReward, annualIssuance, attestationShare)
+	inflation := 0.0
+	if initialStake > 0 && roundCount > 0 {
+		inflation = totalIssued / initialStake * secondsPerYear / (float64(roundCount) * slotSeconds)
+	}
+	fmt.Printf("Tokens issued: %f, annualized inflation: %f\n", totalIssued, inflation)
+	fmt.Printf("Rewards of orphaned blocks revoked: %d, tokens: %f, blocks that could still be orphaned: %d\n", revokedRewards, revokedIssuance, len(blockIssuance))
+	for _, label := range []string{"honest", "malicious"} {
+		if len(yields[label]) == 0 {
+			continue
+		}
+		total := 0.0
+		for _, yield := range yields[label] {
+			total += yield
+		}
+		fmt.Printf("Rewards %s: %f, attestations rewarded: %d, average annualized yield: %f\n", label, earned[label], attestations[label], total/float64(len(yields[label])))
+	}
+}
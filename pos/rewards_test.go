@@ -0,0 +1,87 @@
+package pos
+
+import "testing"
+
+func TestSettleBlockRewards(t *testing.T) {
+	previousValidators, previousChain := validators, CertifiedBlockchain
+	previousIssued, previousIssuance, previousReward := totalIssued, blockIssuance, blockReward
+	t.Cleanup(func() {
+		validators, CertifiedBlockchain = previousValidators, previousChain
+		totalIssued, blockIssuance, blockReward = previousIssued, previousIssuance, previousReward
+	})
+	blockIssuance = make(map[string]issuedReward)
+	totalIssued = 0
+	blockReward = 4
+
+	genesis := newTestGenesis()
+	kept := newTestBlock(genesis, "winner", nil)
+	orphaned := newTestBlock(genesis, "loser", nil)
+	pending := newTestBlock(kept, "winner", nil)
+	winner := &Validator{Address: "winner", Stake: 10}
+	loser := &Validator{Address: "loser", Stake: 10}
+	issueBlockReward(winner, kept)
+	issueBlockReward(loser, orphaned)
+	issueBlockReward(winner, pending)
+
+	//both validators adopted the winning chain, the next block is still on its way to the loser
+	winner.Blockchain = []Block{genesis, kept, pending}
+	loser.Blockchain = []Block{genesis, kept}
+	validators = []*Validator{winner, loser}
+	CertifiedBlockchain = []Block{genesis, kept}
+	loserStake := loser.Stake
+	settleBlockRewards()
+
+	if _, ok := blockIssuance[kept.Hash]; ok {
+		t.Fatal("issuance of a block every validator holds was not pruned")
+	}
+	if _, ok := blockIssuance[orphaned.Hash]; ok || loser.Stake >= loserStake {
+		t.Fatal("issuance of an orphaned block was not revoked")
+	}
+	if _, ok := blockIssuance[pending.Hash]; !ok {
+		t.Fatal("issuance of a block some validators hold was settled")
+	}
+	if totalIssued <= 0 || revokedRewards == 0 {
+		t.Fatalf("got %f issued and %d revoked", totalIssued, revokedRewards)
+	}
+}
+
+func TestSettleBlockRewardsKeepsBlocksAPrivateChainCanOrphan(t *testing.T) {
+	resetSelfishForTest(t)
+	previousValidators, previousChain := validators, CertifiedBlockchain
+	previousIssued, previousIssuance, previousReward := totalIssued, blockIssuance, blockReward
+	t.Cleanup(func() {
+		validators, CertifiedBlockchain = previousValidators, previousChain
+		totalIssued, blockIssuance, blockReward = previousIssued, previousIssuance, previousReward
+	})
+	blockIssuance = make(map[string]issuedReward)
+	blockReward = 4
+
+	genesis := newTestGenesis()
+	base := newTestBlock(genesis, "honest", nil)
+	public := newTestBlock(base, "honest", nil)
+	honest := &Validator{Address: "honest", Stake: 10}
+	issueBlockReward(honest, base)
+	issueBlockReward(honest, public)
+	honest.Blockchain = []Block{genesis, base, public}
+	validators = []*Validator{honest}
+	CertifiedBlockchain = []Block{genesis, base, public}
+	//colluding proposers withhold a block built on base
+	privateBase = base
+	privateChain = []Block{newTestBlock(base, "selfish", nil)}
+	settleBlockRewards()
+
+	if _, ok := blockIssuance[base.Hash]; ok {
+		t.Fatal("issuance of the private chain's fork point was kept")
+	}
+	issued, ok := blockIssuance[public.Hash]
+	if !ok {
+		t.Fatal("issuance of a block the private chain can orphan was forgotten")
+	}
+
+	//once the release orphans the block its issuance is taken back
+	stake := honest.Stake
+	revokeBlockReward(honest, public)
+	if honest.Stake != stake-issued.amount {
+		t.Fatalf("got stake %f after the orphaned block's revocation, want %f", honest.Stake, stake-issued.amount)
+	}
+}
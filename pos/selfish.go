@@ -0,0 +1,183 @@
+package pos
+
+import (
+	"fmt"
+)
+
+// Blocks the colluding proposers have built but not yet released
+var privateChain = make([]Block, 0)
+
+// Public block the private chain was built on
+var privateBase Block
+
+// State after executing the public chain up to the base and the private chain
+var privateState *accountState
+
+// How far the private chain may fall behind before the adversary abandons it
+var selfishGiveUpLag = 2
+
+// Private blocks released before the consensus checkpoint and the public blocks they would orphan,
+// settled once the checkpoint certifies the released chain
+var releasedBlocks = make([]Block, 0)
+var releaseOrphans = make([]Block, 0)
+
+var selfishBlocksReleased = 0
+var selfishBlocksAbandoned = 0
+var selfishReleases = 0
+var honestBlocksOrphaned = 0
+
+func loadSelfishConfig() {
+	selfishGiveUpLag = envInt("SELFISH_GIVE_UP_LAG", selfishGiveUpLag)
+}
+
+// selfishPropose extends the private chain instead of sending the block to the committee
+func selfishPropose(proposer *Validator) {
+	if len(privateChain) == 0 {
+		privateBase = proposer.Blockchain[len(proposer.Blockchain)-1]
+		privateState = proposer.state.copy()
+	}
+
+	oldBlock := privateBase
+	exclude := make(map[int]bool)
+	for _, block := range privateChain {
+		for _, transaction := range block.Transactions {
+			exclude[transaction.ID] = true
+		}
+		oldBlock = block
+	}
+
+	newBlock, err := generateBlockFrom(proposer, oldBlock, privateState, exclude)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	if err := privateState.applyBlock(newBlock); err != nil {
+		fmt.Println("Private block discarded:", err)
+		return
+	}
+	privateChain = append(privateChain, newBlock)
+	fmt.Printf("Private block %d withheld, private chain length %d\n", newBlock.Index, len(privateChain))
+}
+
+// selfishRelease runs before the consensus checkpoint and publishes the private chain if it overtakes the honest one
+func selfishRelease() {
+	if len(privateChain) == 0 {
+		return
+	}
+
+	var publicChain []Block
+	for _, validator := range validators {
+		if len(validator.Blockchain) > len(publicChain) {
+			publicChain = validator.Blockchain
+		}
+	}
+
+	//the public chain was reorganised below the private chain's base, so it no longer extends anything
+	if len(publicChain) <= privateBase.Index || publicChain[privateBase.Index].Hash != privateBase.Hash {
+		println("Selfish proposers dropped private chain, its fork point left the public chain")
+		selfishBlocksAbandoned += len(privateChain)
+		privateChain = make([]Block, 0)
+		return
+	}
+
+	privateLength := privateBase.Index + 1 + len(privateChain)
+	if privateLength <= len(publicChain) {
+		if len(publicChain)-privateLength >= selfishGiveUpLag {
+			println("Selfish proposers abandoned private chain")
+			selfishBlocksAbandoned += len(privateChain)
+			privateChain = make([]Block, 0)
+		}
+		return
+	}
+
+	println("Selfish proposers released private chain")
+	releaseOrphans = append([]Block{}, publicChain[privateBase.Index+1:]...)
+	releasedBlocks = privateChain
+
+	releasedChain := make([]Block, 0, privateLength)
+	releasedChain = append(releasedChain, publicChain[:privateBase.Index+1]...)
+	releasedChain = append(releasedChain, privateChain...)
+
+	//colluding validators adopt the released chain so it wins the longest chain checkpoint
+	for _, validator := range malValidators {
+		adoptChain(validator, releasedChain)
+	}
+	privateChain = make([]Block, 0)
+}
+
+// settleSelfishRelease runs after the consensus checkpoint. If the checkpoint certified the released chain,
+// the blocks it orphaned are reverted and the released blocks pay out, otherwise the release is abandoned.
+func settleSelfishRelease() {
+	if len(releasedBlocks) == 0 {
+		return
+	}
+	released, orphaned := releasedBlocks, releaseOrphans
+	releasedBlocks, releaseOrphans = make([]Block, 0), make([]Block, 0)
+
+	head := released[len(released)-1]
+	if len(CertifiedBlockchain) <= head.Index || CertifiedBlockchain[head.Index].Hash != head.Hash {
+		println("Released private chain was not certified")
+		selfishBlocksAbandoned += len(released)
+		return
+	}
+	for _, block := range orphaned {
+		settleBlock(block, -1)
+	}
+	for _, block := range released {
+		settleBlock(block, 1)
+		if blockProposer := validatorByAddress(block.Validator); blockProposer != nil {
+			blockProposer.blockSuccessCount += 1
+		}
+	}
+	honestBlocksOrphaned += len(orphaned)
+	selfishBlocksReleased += len(released)
+	selfishReleases++
+}
+
+// settleBlock applies (sign 1) or reverts (sign -1) the balance and reward changes of a block
+func settleBlock(block Block, sign float64) {
+	blockProposer := validatorByAddress(block.Validator)
+	if blockProposer != nil {
+		if sign > 0 {
+			issueBlockReward(blockProposer, block)
+		} else {
+			revokeBlockReward(blockProposer, block)
+		}
+	}
+	for _, transaction := range block.Transactions {
+		transaction.Sender.Balance -= sign * (transaction.Amount + transaction.Reward)
+		transaction.Receiver.Balance += sign * transaction.Amount
+		if blockProposer != nil {
+			creditReward(blockProposer, sign*proposerFee(block, transaction))
+		}
+	}
+}
+
+func printSelfishEvaluation() {
+	maliciousRevenue := 0.0
+	totalRevenue := 0.0
+	for _, block := range CertifiedBlockchain {
+		for _, transaction := range block.Transactions {
+			totalRevenue += proposerFee(block, transaction)
+			if block.IsMalicious {
+				maliciousRevenue += proposerFee(block, transaction)
+			}
+		}
+	}
+	maliciousStake := 0.0
+	totalStake := 0.0
+	for _, validator := range validators {
+		totalStake += validator.Stake
+		if validator.IsMalicious {
+			maliciousStake += validator.Stake
+		}
+	}
+	if totalRevenue > 0 {
+		fmt.Printf("Malicious revenue share: %f\n", maliciousRevenue/totalRevenue)
+	}
+	if totalStake > 0 {
+		fmt.Printf("Malicious stake share: %f\n", maliciousStake/totalStake)
+	}
+	fmt.Printf("Private chain releases: %d, blocks released: %d, blocks abandoned: %d, pending: %d\n", selfishReleases, selfishBlocksReleased, selfishBlocksAbandoned, len(privateChain))
+	fmt.Printf("Honest blocks orphaned: %d\n", honestBlocksOrphaned)
+}
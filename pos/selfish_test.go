@@ -0,0 +1,127 @@
+package pos
+
+import "testing"
+
+// resetSelfishForTest clears the private chain and its counters and restores them when the test ends
+func resetSelfishForTest(t *testing.T) {
+	t.Helper()
+	previousChain, previousBase, previousState := privateChain, privateBase, privateState
+	previousReleased, previousOrphans := releasedBlocks, releaseOrphans
+	previousValidators, previousMal, previousCertified := validators, malValidators, CertifiedBlockchain
+	previousCounts := []int{selfishBlocksReleased, selfishBlocksAbandoned, selfishReleases, honestBlocksOrphaned}
+	t.Cleanup(func() {
+		privateChain, privateBase, privateState = previousChain, previousBase, previousState
+		releasedBlocks, releaseOrphans = previousReleased, previousOrphans
+		validators, malValidators, CertifiedBlockchain = previousValidators, previousMal, previousCertified
+		selfishBlocksReleased, selfishBlocksAbandoned = previousCounts[0], previousCounts[1]
+		selfishReleases, honestBlocksOrphaned = previousCounts[2], previousCounts[3]
+	})
+	privateChain = make([]Block, 0)
+	releasedBlocks, releaseOrphans = make([]Block, 0), make([]Block, 0)
+	selfishBlocksReleased, selfishBlocksAbandoned, selfishReleases, honestBlocksOrphaned = 0, 0, 0, 0
+}
+
+// newSelfishTestValidators connects an honest and a malicious committee member holding the given chains
+func newSelfishTestValidators(t *testing.T, honestChain []Block, maliciousChain []Block) (*Validator, *Validator) {
+	t.Helper()
+	committee := newTestCommittee(t, 2)
+	for i, chain := range [][]Block{honestChain, maliciousChain} {
+		connected := newTestValidator(t, chain)
+		committee[i].conn, committee[i].Blockchain, committee[i].state = connected.conn, connected.Blockchain, connected.state
+		committee[i].chainLock = connected.chainLock
+		committee[i].pool = newMempool()
+	}
+	honest, malicious := committee[0], committee[1]
+	malicious.IsMalicious = true
+	malValidators = []*Validator{malicious}
+	return honest, malicious
+}
+
+// withholdForTest has the malicious validator build a private block holding the transaction
+func withholdForTest(t *testing.T, proposer *Validator, transaction Transaction) {
+	t.Helper()
+	if err := proposer.pool.add(transaction); err != nil {
+		t.Fatal(err)
+	}
+	withheld := len(privateChain)
+	selfishPropose(proposer)
+	if len(privateChain) != withheld+1 {
+		t.Fatal("private block was not withheld")
+	}
+}
+
+func TestSelfishReleaseSettlesAtCheckpoint(t *testing.T) {
+	resetSelfishForTest(t)
+	alice := newTestUser(t, "alice", 100)
+	bob := newTestUser(t, "bob", 100)
+	genesis := newTestGenesis()
+	honestBlock := newTestBlock(genesis, "honest", []Transaction{newTestTransaction(1, alice, bob, 10, 1, 0)})
+	_, malicious := newSelfishTestValidators(t, []Block{genesis, honestBlock}, []Block{genesis})
+	//the honest block was accepted and paid out
+	alice.Balance, bob.Balance = 89, 110
+
+	withholdForTest(t, malicious, newTestTransaction(2, alice, bob, 5, 1, 0))
+	withholdForTest(t, malicious, newTestTransaction(3, alice, bob, 5, 1, 1))
+	if malicious.blockSuccessCount != 0 || alice.Balance != 89 {
+		t.Fatal("withheld blocks were counted or settled before their release")
+	}
+
+	selfishRelease()
+	if len(malicious.Blockchain) != 3 || len(privateChain) != 0 {
+		t.Fatalf("malicious validator holds %d blocks after the release, want 3", len(malicious.Blockchain))
+	}
+	if alice.Balance != 89 || selfishReleases != 0 {
+		t.Fatal("release settled before the checkpoint certified it")
+	}
+
+	CertifiedBlockchain = malicious.Blockchain
+	settleSelfishRelease()
+	if honestBlocksOrphaned != 1 || selfishBlocksReleased != 2 || selfishReleases != 1 {
+		t.Fatalf("got %d orphaned and %d released, want 1 and 2", honestBlocksOrphaned, selfishBlocksReleased)
+	}
+	if alice.Balance != 88 || bob.Balance != 110 {
+		t.Fatalf("got balances %f and %f, want 88 and 110", alice.Balance, bob.Balance)
+	}
+	if malicious.blockSuccessCount != 2 {
+		t.Fatalf("got %d successful blocks, want the 2 released", malicious.blockSuccessCount)
+	}
+}
+
+func TestSelfishReleaseNotCertifiedIsAbandoned(t *testing.T) {
+	resetSelfishForTest(t)
+	alice := newTestUser(t, "alice", 100)
+	bob := newTestUser(t, "bob", 100)
+	genesis := newTestGenesis()
+	_, malicious := newSelfishTestValidators(t, []Block{genesis}, []Block{genesis})
+	withholdForTest(t, malicious, newTestTransaction(1, alice, bob, 5, 1, 0))
+	selfishRelease()
+
+	CertifiedBlockchain = []Block{genesis}
+	settleSelfishRelease()
+	if selfishReleases != 0 || selfishBlocksAbandoned != 1 || alice.Balance != 100 {
+		t.Fatal("release the checkpoint did not certify was settled")
+	}
+}
+
+func TestSelfishReleaseDropsChainWhenForkPointChanged(t *testing.T) {
+	resetSelfishForTest(t)
+	alice := newTestUser(t, "alice", 100)
+	bob := newTestUser(t, "bob", 100)
+	genesis := newTestGenesis()
+	base := newTestBlock(genesis, "honest", nil)
+	honest, malicious := newSelfishTestValidators(t, []Block{genesis, base}, []Block{genesis, base})
+	withholdForTest(t, malicious, newTestTransaction(1, alice, bob, 5, 1, 0))
+	withholdForTest(t, malicious, newTestTransaction(2, alice, bob, 5, 1, 1))
+	//a checkpoint replaced the block the private chain was built on
+	replaced := newTestBlock(genesis, "other", nil)
+	honest.Blockchain = []Block{genesis, replaced}
+	malicious.Blockchain = []Block{genesis, replaced}
+
+	selfishRelease()
+	if len(privateChain) != 0 || selfishBlocksAbandoned != 2 || len(releasedBlocks) != 0 {
+		t.Fatal("private chain built on a replaced block was not dropped")
+	}
+	if head := malicious.Blockchain[len(malicious.Blockchain)-1]; head.Hash != replaced.Hash {
+		t.Fatal("dropped private chain was released")
+	}
+}
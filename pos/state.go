@@ -0,0 +1,427 @@
+package pos
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Balances and stakes accounts start with before the chain touches them.
+// Users and validators join off chain, so every validator reads the same allocations.
+var genesisBalances = make(map[string]int64)
+var genesisStakes = make(map[string]int64)
+var genesisLock = &sync.Mutex{}
+
+func registerGenesisBalance(address string, balance float64) {
+	genesisLock.Lock()
+	genesisBalances[address] = toFixedPoint(balance)
+	genesisOutputs[genesisOutPoint(address).key()] = utxo{address: address, amount: toFixedPoint(balance)}
+	genesisLock.Unlock()
+}
+
+func registerGenesisStake(address string, stake float64) {
+	genesisLock.Lock()
+	genesisStakes[address] = toFixedPoint(stake)
+	genesisLock.Unlock()
+}
+
+// accountState is a validator's view of balances, nonces and stakes, derived by executing its chain
+type accountState struct {
+	lock     sync.Mutex
+	balances map[string]int64
+	nonces   map[string]int
+	stakes   map[string]int64
+	outputs  map[string]*utxo
+	undo     []stateUndo
+}
+
+type accountUndo struct {
+	balance    int64
+	hadBalance bool
+	nonce      int
+	hadNonce   bool
+	stake      int64
+	hadStake   bool
+}
+
+type outputUndo struct {
+	output *utxo
+	had    bool
+}
+
+// stateUndo records the values a block overwrote so the block can be reverted on a reorg
+type stateUndo struct {
+	hash     string
+	accounts map[string]accountUndo
+	outputs  map[string]outputUndo
+}
+
+func newStateUndo(hash string) stateUndo {
+	return stateUndo{hash: hash, accounts: make(map[string]accountUndo), outputs: make(map[string]outputUndo)}
+}
+
+var errInsufficientFunds = errors.New("sender has insufficient funds")
+var errNonceUsed = errors.New("transaction nonce was already used")
+var errNonceAhead = errors.New("transaction nonce is ahead of the sender's next nonce")
+
+func newAccountState() *accountState {
+	return &accountState{
+		balances: make(map[string]int64),
+		nonces:   make(map[string]int),
+		stakes:   make(map[string]int64),
+		outputs:  make(map[string]*utxo),
+		undo:     make([]stateUndo, 0),
+	}
+}
+
+// stateFromChain executes a chain from genesis
+// It stops at the first block that does not apply, the returned state then covers chain[:height()]
+func stateFromChain(chain []Block) (*accountState, error) {
+	state := newAccountState()
+	for _, block := range chain {
+		if err := state.applyBlock(block); err != nil {
+			return state, fmt.Errorf("block %d does not apply to state: %s", block.Index, err.Error())
+		}
+	}
+	return state, nil
+}
+
+func (s *accountState) balanceLocked(address string) int64 {
+	if balance, ok := s.balances[address]; ok {
+		return balance
+	}
+	genesisLock.Lock()
+	defer genesisLock.Unlock()
+	return genesisBalances[address]
+}
+
+func (s *accountState) stakeLocked(address string) int64 {
+	if stake, ok := s.stakes[address]; ok {
+		return stake
+	}
+	genesisLock.Lock()
+	defer genesisLock.Unlock()
+	return genesisStakes[address]
+}
+
+// balance returns an account balance in tokens
+func (s *accountState) balance(address string) float64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return fromFixedPoint(s.balanceLocked(address))
+}
+
+// nonce returns the next nonce the state expects from a sender
+func (s *accountState) nonce(address string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.nonces[address]
+}
+
+// height is the number of blocks executed into the state
+func (s *accountState) height() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.undo)
+}
+
+func (s *accountState) remember(record stateUndo, address string) {
+	if _, ok := record.accounts[address]; ok {
+		return
+	}
+	balance, hadBalance := s.balances[address]
+	nonce, hadNonce := s.nonces[address]
+	stake, hadStake := s.stakes[address]
+	record.accounts[address] = accountUndo{
+		balance:    balance,
+		hadBalance: hadBalance,
+		nonce:      nonce,
+		hadNonce:   hadNonce,
+		stake:      stake,
+		hadStake:   hadStake,
+	}
+}
+
+// checkTransaction reports why a transaction cannot be executed on top of the state, if it cannot
+// A transaction whose nonce is ahead of the sender's next nonce gets errNonceAhead once every other
+// check passes, so the mempool can hold it until the missing nonces execute
+func (s *accountState) checkTransaction(transaction Transaction) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.checkTransactionLocked(transaction)
+}
+
+func (s *accountState) checkTransactionLocked(transaction Transaction) error {
+	if transaction.Sender == nil || transaction.Receiver == nil {
+		return errors.New("transaction sender or receiver is not an active user")
+	}
+	//outputs can only be spent once, so the UTXO ledger needs no nonce or balance check
+	if ledgerMode == utxoLedgerMode {
+		return s.checkInputsLocked(transaction)
+	}
+	expected := s.nonces[transaction.Sender.Address]
+	if transaction.Nonce < expected {
+		return errNonceUsed
+	}
+	if toFixedPoint(transaction.Amount) < 0 || toFixedPoint(transaction.Reward) < 0 {
+		return errors.New("transaction amounts must not be negative")
+	}
+	if toFixedPoint(transaction.Amount)+toFixedPoint(transaction.Reward) > s.balanceLocked(transaction.Sender.Address) {
+		return errInsufficientFunds
+	}
+	if transaction.Nonce > expected {
+		return errNonceAhead
+	}
+	return nil
+}
+
+// executeLocked pays the proposer the fee above the base fee and burns the base fee
+func (s *accountState) executeLocked(transaction Transaction, proposerAddress string, baseFee int64, record stateUndo) {
+	sender := transaction.Sender.Address
+	receiver := transaction.Receiver.Address
+	s.remember(record, sender)
+	s.remember(record, receiver)
+	s.remember(record, proposerAddress)
+
+	amount := toFixedPoint(transaction.Amount)
+	reward := toFixedPoint(transaction.Reward)
+	if ledgerMode == utxoLedgerMode {
+		s.executeOutputsLocked(transaction, record)
+	} else {
+		s.balances[sender] = s.balanceLocked(sender) - amount - reward
+		s.balances[receiver] = s.balanceLocked(receiver) + amount
+	}
+	s.nonces[sender] = transaction.Nonce + 1
+	s.stakes[proposerAddress] = s.stakeLocked(proposerAddress) + reward - baseFee
+}
+
+func (s *accountState) revertLocked(record stateUndo) {
+	for address, previous := range record.accounts {
+		if previous.hadBalance {
+			s.balances[address] = previous.balance
+		} else {
+			delete(s.balances, address)
+		}
+		if previous.hadNonce {
+			s.nonces[address] = previous.nonce
+		} else {
+			delete(s.nonces, address)
+		}
+		if previous.hadStake {
+			s.stakes[address] = previous.stake
+		} else {
+			delete(s.stakes, address)
+		}
+	}
+	for key, previous := range record.outputs {
+		if previous.had {
+			s.outputs[key] = previous.output
+		} else {
+			delete(s.outputs, key)
+		}
+	}
+}
+
+// applyBlock executes every transaction of a block, or none of them if one is invalid
+func (s *accountState) applyBlock(block Block) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	record := newStateUndo(block.Hash)
+	baseFee := toFixedPoint(block.BaseFee)
+	for _, transaction := range block.Transactions {
+		err := s.checkTransactionLocked(transaction)
+		if err == nil && toFixedPoint(transaction.Reward) < baseFee {
+			err = errFeeBelowBaseFee
+		}
+		if err != nil {
+			s.revertLocked(record)
+			return fmt.Errorf("transaction %d: %s", transaction.ID, err.Error())
+		}
+		s.executeLocked(transaction, block.Validator, baseFee, record)
+	}
+	s.undo = append(s.undo, record)
+	return nil
+}
+
+// revertBlock undoes the most recently applied block
+func (s *accountState) revertBlock() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.undo) == 0 {
+		return
+	}
+	s.revertLocked(s.undo[len(s.undo)-1])
+	s.undo = s.undo[:len(s.undo)-1]
+}
+
+// selectTransactions keeps the transactions that execute in order on top of the state and pay the base fee
+func (s *accountState) selectTransactions(candidates []Transaction, proposerAddress string, baseFee float64, limit int) []Transaction {
+	staged := s.copy()
+	record := newStateUndo("")
+	selected := make([]Transaction, 0)
+	fee := toFixedPoint(baseFee)
+	for _, transaction := range candidates {
+		if len(selected) == limit {
+			break
+		}
+		if toFixedPoint(transaction.Reward) < fee || staged.checkTransactionLocked(transaction) != nil {
+			continue
+		}
+		staged.executeLocked(transaction, proposerAddress, fee, record)
+		selected = append(selected, transaction)
+	}
+	return selected
+}
+
+// rootAfter returns the state root once block is applied, without changing the state
+func (s *accountState) rootAfter(block Block) (string, error) {
+	staged := s.copy()
+	if err := staged.applyBlock(block); err != nil {
+		return "", err
+	}
+	return staged.root(), nil
+}
+
+// root commits to every account the chain has touched, sorted by address
+func (s *accountState) root() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	addresses := make([]string, 0, len(s.balances)+len(s.stakes))
+	seen := make(map[string]bool)
+	for address := range s.balances {
+		seen[address] = true
+	}
+	for address := range s.nonces {
+		seen[address] = true
+	}
+	for address := range s.stakes {
+		seen[address] = true
+	}
+	for address := range seen {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	leaves := make([][]byte, 0, len(addresses))
+	for _, address := range addresses {
+		e := &encoder{}
+		e.writeString(address)
+		e.writeInt64(s.balanceLocked(address))
+		e.writeUint64(uint64(s.nonces[address]))
+		e.writeInt64(s.stakeLocked(address))
+		leaves = append(leaves, e.buf.Bytes())
+	}
+
+	//outputs touched by the chain follow the accounts, spent ones included
+	keys := make([]string, 0, len(s.outputs))
+	for key := range s.outputs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		e := &encoder{}
+		e.writeString(key)
+		output := s.outputs[key]
+		e.writeBool(output != nil)
+		if output != nil {
+			e.writeString(output.address)
+			e.writeInt64(output.amount)
+		}
+		leaves = append(leaves, e.buf.Bytes())
+	}
+	return merkleRoot(leaves)
+}
+
+// copy returns an independent state with the same accounts and undo history
+func (s *accountState) copy() *accountState {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c := newAccountState()
+	for address, balance := range s.balances {
+		c.balances[address] = balance
+	}
+	for address, nonce := range s.nonces {
+		c.nonces[address] = nonce
+	}
+	for address, stake := range s.stakes {
+		c.stakes[address] = stake
+	}
+	for key, output := range s.outputs {
+		c.outputs[key] = output
+	}
+	c.undo = make([]stateUndo, len(s.undo))
+	copy(c.undo, s.undo)
+	return c
+}
+
+// reorgState reverts a validator's state to the last block it shares with chain and executes the rest of chain
+// It returns how many blocks of chain the state covers, which is fewer than len(chain) if a block does not apply
+func reorgState(validator *Validator, chain []Block) int {
+	common := 0
+	for common < len(validator.Blockchain) && common < len(chain) && validator.Blockchain[common].Hash == chain[common].Hash {
+		common++
+	}
+	for validator.state.height() > common {
+		validator.state.revertBlock()
+	}
+	for _, block := range chain[validator.state.height():] {
+		if err := validator.state.applyBlock(block); err != nil {
+			fmt.Printf("Validator %s could not execute block %d after reorg: %s\n", validator.Address[:3], block.Index, err.Error())
+			validator.state, _ = stateFromChain(chain)
+			return validator.state.height()
+		}
+	}
+	return validator.state.height()
+}
+
+// isBlockStateValid checks a proposed block's state transition on top of its parent
+// A validator on another branch, e.g. the losing side of a fork, executes the block on the parent's state
+// taken from the proposer's chain
+func isBlockStateValid(block Block, validator *Validator) bool {
+	validator.chainLock.Lock()
+	head := validator.Blockchain[len(validator.Blockchain)-1]
+	if head.Hash == block.PrevHash {
+		defer validator.chainLock.Unlock()
+		return isStateTransitionValid(block, head, validator.state, validator.conn)
+	}
+	chain := make([]Block, len(validator.Blockchain))
+	copy(chain, validator.Blockchain)
+	validator.chainLock.Unlock()
+
+	parent := block.Index - 1
+	if parent < 0 || parent >= len(chain) || chain[parent].Hash != block.PrevHash {
+		if blockProposer := validatorByAddress(block.Validator); blockProposer != nil {
+			chain = chainSnapshot(blockProposer)
+		}
+	}
+	if parent < 0 || parent >= len(chain) || chain[parent].Hash != block.PrevHash {
+		io.WriteString(validator.conn, "Block builds on an unknown parent\n")
+		return false
+	}
+	state, err := stateFromChain(chain[:parent+1])
+	if err != nil {
+		io.WriteString(validator.conn, fmt.Sprintf("Block's parent does not execute: %s\n", err.Error()))
+		return false
+	}
+	return isStateTransitionValid(block, chain[parent], state, validator.conn)
+}
+
+// isStateTransitionValid checks the block's base fee and state root against its parent and the state after it
+func isStateTransitionValid(block Block, parent Block, state *accountState, conn io.Writer) bool {
+	if toFixedPoint(block.BaseFee) != toFixedPoint(nextBaseFee(parent)) {
+		io.WriteString(conn, "Block base fee does not follow its parent\n")
+		return false
+	}
+	root, err := state.rootAfter(block)
+	if err != nil {
+		io.WriteString(conn, fmt.Sprintf("Block is not a valid state transition: %s\n", err.Error()))
+		return false
+	}
+	if root != block.StateRoot {
+		io.WriteString(conn, "Block state root does not match\n")
+		return false
+	}
+	return true
+}
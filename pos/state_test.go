@@ -0,0 +1,201 @@
+package pos
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+)
+
+// newTestUser creates a user with fresh keys and registers its genesis balance
+func newTestUser(t *testing.T, name string, balance float64) *User {
+	t.Helper()
+	keys, err := generateKeyPair(ed25519Scheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &User{Name: name, Address: addressFromPublicKey(keys.publicKey()), Balance: balance, keys: keys, PublicKey: keys.publicKey()}
+	registerGenesisBalance(user.Address, balance)
+	return user
+}
+
+func newTestTransaction(id int, sender *User, receiver *User, amount float64, reward float64, nonce int) Transaction {
+	return generateTransactionWithNonce(id, sender, receiver, amount, reward, nonce)
+}
+
+// newTestBlock builds an unsigned block on top of parent
+func newTestBlock(parent Block, proposer string, transactions []Transaction) Block {
+	block := Block{
+		Index:        parent.Index + 1,
+		Timestamp:    fmt.Sprintf("block %d", parent.Index+1),
+		PrevHash:     parent.Hash,
+		Validator:    proposer,
+		Transactions: transactions,
+		TxRoot:       transactionRoot(transactions),
+	}
+	block.Hash = calculateBlockHash(block)
+	return block
+}
+
+func newTestGenesis() Block {
+	genesis := Block{Index: 0, Timestamp: "genesis", Transactions: []Transaction{}}
+	genesis.Hash = calculateBlockHash(genesis)
+	return genesis
+}
+
+func TestCheckTransactionNonce(t *testing.T) {
+	alice := newTestUser(t, "alice", 100)
+	bob := newTestUser(t, "bob", 100)
+	state := newAccountState()
+
+	if err := state.checkTransaction(newTestTransaction(1, alice, bob, 1, 0, 0)); err != nil {
+		t.Fatalf("next nonce: got %v, want nil", err)
+	}
+	if err := state.checkTransaction(newTestTransaction(2, alice, bob, 1, 0, 1)); err != errNonceAhead {
+		t.Fatalf("nonce ahead: got %v, want %v", err, errNonceAhead)
+	}
+	if err := state.applyBlock(newTestBlock(newTestGenesis(), "proposer", []Transaction{newTestTransaction(3, alice, bob, 1, 0, 0)})); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.checkTransaction(newTestTransaction(4, alice, bob, 1, 0, 0)); err != errNonceUsed {
+		t.Fatalf("used nonce: got %v, want %v", err, errNonceUsed)
+	}
+	if got := state.nonce(alice.Address); got != 1 {
+		t.Fatalf("nonce after block: got %d, want 1", got)
+	}
+}
+
+func TestApplyBlockRejectsNonceGap(t *testing.T) {
+	alice := newTestUser(t, "alice", 100)
+	bob := newTestUser(t, "bob", 100)
+	state := newAccountState()
+	block := newTestBlock(newTestGenesis(), "proposer", []Transaction{
+		newTestTransaction(1, alice, bob, 1, 0, 0),
+		newTestTransaction(2, alice, bob, 1, 0, 2),
+	})
+	if err := state.applyBlock(block); err == nil {
+		t.Fatal("block skipping a nonce was applied")
+	}
+	if state.height() != 0 || state.nonce(alice.Address) != 0 || state.balance(alice.Address) != 100 {
+		t.Fatal("a rejected block changed the state")
+	}
+}
+
+func TestSelectTransactionsWaitsForMissingNonce(t *testing.T) {
+	alice := newTestUser(t, "alice", 100)
+	bob := newTestUser(t, "bob", 100)
+	state := newAccountState()
+	candidates := orderByFee([]Transaction{
+		newTestTransaction(1, alice, bob, 1, 2, 1),
+		newTestTransaction(2, alice, bob, 1, 1, 0),
+		newTestTransaction(3, alice, bob, 1, 3, 3),
+	}, 0)
+	selected := state.selectTransactions(candidates, "proposer", 0, 10)
+	if len(selected) != 2 || selected[0].Nonce != 0 || selected[1].Nonce != 1 {
+		t.Fatalf("got %v, want nonces 0 and 1 in order", selected)
+	}
+}
+
+// newTestValidator creates a validator whose connection output is discarded
+func newTestValidator(t *testing.T, chain []Block) *Validator {
+	t.Helper()
+	conn, peer := net.Pipe()
+	go io.Copy(io.Discard, peer)
+	t.Cleanup(func() { conn.Close() })
+	state, err := stateFromChain(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Validator{conn: conn, Blockchain: chain, state: state, chainLock: &sync.Mutex{}}
+}
+
+func TestStateFromChainStopsAtInvalidBlock(t *testing.T) {
+	alice := newTestUser(t, "alice", 100)
+	bob := newTestUser(t, "bob", 100)
+	genesis := newTestGenesis()
+	first := newTestBlock(genesis, "proposer", []Transaction{newTestTransaction(1, alice, bob, 1, 0, 0)})
+	replayed := newTestBlock(first, "proposer", []Transaction{newTestTransaction(2, alice, bob, 1, 0, 0)})
+	last := newTestBlock(replayed, "proposer", nil)
+
+	state, err := stateFromChain([]Block{genesis, first, replayed, last})
+	if err == nil {
+		t.Fatal("chain with a replayed nonce executed")
+	}
+	if state.height() != 2 {
+		t.Fatalf("state covers %d blocks, want 2", state.height())
+	}
+}
+
+func TestIsBlockStateValid(t *testing.T) {
+	alice := newTestUser(t, "alice", 100)
+	bob := newTestUser(t, "bob", 100)
+	genesis := newTestGenesis()
+	validator := newTestValidator(t, []Block{genesis})
+
+	block := newTestBlock(genesis, "proposer", []Transaction{newTestTransaction(1, alice, bob, 1, 0, 0)})
+	block.BaseFee = nextBaseFee(genesis)
+	root, err := validator.state.rootAfter(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.StateRoot = root
+	if !isBlockStateValid(block, validator) {
+		t.Fatal("valid block on the validator's head was rejected")
+	}
+
+	//a block whose parent neither the validator nor its proposer holds cannot be executed
+	other := newTestBlock(block, "proposer", nil)
+	other.BaseFee = nextBaseFee(block)
+	if isBlockStateValid(other, validator) {
+		t.Fatal("block on another head was accepted")
+	}
+	if !isBehind(other, validator) {
+		t.Fatal("validator two blocks behind is not behind")
+	}
+}
+
+// withStateRoot sets the block's base fee and state root as its proposer on top of chain would
+func withStateRoot(t *testing.T, chain []Block, block Block) Block {
+	t.Helper()
+	state, err := stateFromChain(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block.BaseFee = nextBaseFee(chain[len(chain)-1])
+	if block.StateRoot, err = state.rootAfter(block); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+func TestIsBlockStateValidOnAnotherBranch(t *testing.T) {
+	previousValidators := validators
+	t.Cleanup(func() { validators = previousValidators })
+	alice := newTestUser(t, "alice", 100)
+	bob := newTestUser(t, "bob", 100)
+	genesis := newTestGenesis()
+	winning := withStateRoot(t, []Block{genesis}, newTestBlock(genesis, "proposer", []Transaction{newTestTransaction(1, alice, bob, 1, 0, 0)}))
+	losing := withStateRoot(t, []Block{genesis}, newTestBlock(genesis, "other", nil))
+	proposer := newTestValidator(t, []Block{genesis, winning})
+	proposer.Address = "proposer"
+	//the voter is on the losing side of a fork and never saw the proposer's parent
+	voter := newTestValidator(t, []Block{genesis, losing})
+	validators = []*Validator{proposer, voter}
+
+	block := withStateRoot(t, []Block{genesis, winning}, newTestBlock(winning, "proposer", []Transaction{newTestTransaction(2, alice, bob, 1, 0, 1)}))
+	if !isBlockStateValid(block, voter) {
+		t.Fatal("valid block on the proposer's head was rejected by a validator on another branch")
+	}
+	//the transition is still checked on the parent's state
+	block.StateRoot = losing.StateRoot
+	if isBlockStateValid(block, voter) {
+		t.Fatal("block with a wrong state root was accepted")
+	}
+
+	//a block on a parent below the voter's head executes on that parent's state
+	sibling := withStateRoot(t, []Block{genesis}, newTestBlock(genesis, "proposer", []Transaction{newTestTransaction(3, alice, bob, 1, 0, 0)}))
+	if !isBlockStateValid(sibling, voter) {
+		t.Fatal("valid block on a parent below the voter's head was rejected")
+	}
+}
@@ -0,0 +1,502 @@
+package pos
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	exprand "golang.org/x/exp/rand"
+)
+
+// Blocks are kept in an append-only log, each record the block's hash followed by its binary
+// encoding, both prefixed with their length. Certified blocks are appended as the certified chain
+// grows, and the blocks validators hold past it when a snapshot refers to them. A snapshot of a
+// round records everything else, with the chains as block hashes into the log, so a simulation can
+// be resumed from it and forked into variants that share the same prefix.
+
+// Directory of the block log and snapshots, nothing is stored when empty
+var storeDir = ""
+
+// Rounds after which a snapshot is written
+var snapshotRounds = make(map[int]bool)
+
+// Snapshot to resume the simulation from
+var resumeFrom = ""
+
+// Seed of the random number generators, reseeded with seed+round at every snapshot and when a
+// simulation resumes from one
+var seed int64 = 0
+
+const blockLogName = "blocks.log"
+
+type blockStore struct {
+	lock sync.Mutex
+	file *os.File
+	//blocks already in the log
+	stored map[string]bool
+	//length of the certified chain prefix already in the log
+	certified int
+	//encoded blocks of the store a simulation resumed from
+	loaded map[string][]byte
+}
+
+var store = &blockStore{
+	stored: make(map[string]bool),
+	loaded: make(map[string][]byte),
+}
+
+type validatorSnapshot struct {
+	Name              string
+	Address           string
+	GenesisStake      float64
+	Stake             float64
+	InitialStake      float64
+	Reputation        float64
+	Earned            float64
+	Withdrawn         float64
+	Malicious         bool
+	Sybil             bool
+	CommitteeCount    int
+	ProposerCount     int
+	BlockSuccessCount int
+	AttestationCount  int
+	Chain             []string
+	Mempool           []pendingSnapshot
+}
+
+// pendingSnapshot is a transaction in a validator's mempool and how long it has been pending
+type pendingSnapshot struct {
+	Transaction []byte
+	Age         float64
+}
+
+type userSnapshot struct {
+	Name           string
+	Address        string
+	GenesisBalance float64
+	Balance        float64
+	Nonce          int
+}
+
+type snapshot struct {
+	Round            int
+	Seed             int64
+	ConsensusCounter int
+	TransactionID    int
+	TotalIssued      float64
+	KeystoreDir      string
+	Certified        []string
+	BalanceFork      []string
+	Validators       []validatorSnapshot
+	Users            []userSnapshot
+}
+
+// Identities of the snapshot being resumed, taken over by the validators and users that join under their names
+var resumedValidators = make(map[string]validatorSnapshot)
+var resumedUsers = make(map[string]*User)
+
+var resumeLock = &sync.Mutex{}
+
+func loadStoreConfig() {
+	storeDir = envString("STORE_DIR", storeDir)
+	resumeFrom = envString("RESUME_FROM", resumeFrom)
+	for _, field := range strings.Split(envString("SNAPSHOT_ROUNDS", ""), ",") {
+		if strings.TrimSpace(field) == "" {
+			continue
+		}
+		round, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || round <= 0 {
+			fmt.Printf("SNAPSHOT_ROUNDS entry %s is not a round number\n", field)
+			continue
+		}
+		snapshotRounds[round] = true
+	}
+	if len(snapshotRounds) > 0 && storeDir == "" {
+		fmt.Println("SNAPSHOT_ROUNDS needs STORE_DIR, no snapshots will be written")
+	}
+	seed = int64(envInt("SEED", 0))
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	//identities must keep their keys for a snapshot to be resumed
+	if keystoreDir == "" && storeDir != "" && resumeFrom == "" {
+		keystoreDir = filepath.Join(storeDir, "keys")
+	}
+}
+
+// seedRandom seeds every random number generator the simulation draws from
+func seedRandom(s int64) {
+	rand.Seed(s)
+	exprand.Seed(uint64(s))
+}
+
+// openStore opens the block log for appending, indexing the blocks already in it
+func openStore() error {
+	if storeDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(storeDir, 0700); err != nil {
+		return err
+	}
+	path := filepath.Join(storeDir, blockLogName)
+	blocks, err := readBlockLog(path)
+	if err != nil {
+		return err
+	}
+	for hash := range blocks {
+		store.stored[hash] = true
+	}
+	store.file, err = os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	return err
+}
+
+// readBlockLog reads the encoded blocks of a log by hash, ignoring a record cut short by a crash
+func readBlockLog(path string) (map[string][]byte, error) {
+	blocks := make(map[string][]byte)
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return blocks, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	for {
+		hash, err := readLogString(reader)
+		if err != nil {
+			break
+		}
+		data, err := readLogString(reader)
+		if err != nil {
+			break
+		}
+		blocks[string(hash)] = data
+	}
+	return blocks, nil
+}
+
+// readLogString reads a length prefixed string as written by encoder.writeString
+func readLogString(reader io.Reader) ([]byte, error) {
+	var length uint32
+	if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// storeChain appends the blocks of a chain that are not in the log yet
+func storeChain(chain []Block) error {
+	for _, block := range chain {
+		if store.stored[block.Hash] {
+			continue
+		}
+		e := &encoder{}
+		e.writeString(block.Hash)
+		e.writeString(string(encodeBlock(block)))
+		if _, err := store.file.Write(e.buf.Bytes()); err != nil {
+			return err
+		}
+		store.stored[block.Hash] = true
+	}
+	return nil
+}
+
+// storeCertified appends the certified blocks added since the last round. After a reorg of the
+// certified chain the cursor moves back to the last block that is in the log, since a stored
+// block's ancestors are always stored before it
+func storeCertified(chain []Block) error {
+	for store.certified > len(chain) || (store.certified > 0 && !store.stored[chain[store.certified-1].Hash]) {
+		store.certified--
+	}
+	if err := storeChain(chain[store.certified:]); err != nil {
+		return err
+	}
+	store.certified = len(chain)
+	return nil
+}
+
+// persistRound stores the newly certified blocks, writes a snapshot if one is due and reseeds the
+// random number generators
+func persistRound() {
+	if storeDir == "" || store.file == nil {
+		return
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	validatorsSliceLock.Lock()
+	certified := CertifiedBlockchain
+	validatorsSliceLock.Unlock()
+	if err := storeCertified(certified); err != nil {
+		fmt.Println("Could not store blocks:", err)
+		return
+	}
+	if !snapshotRounds[roundCount] {
+		return
+	}
+	path := filepath.Join(storeDir, fmt.Sprintf("snapshot-%d.json", roundCount))
+	if err := writeSnapshot(path); err != nil {
+		fmt.Println("Could not write snapshot:", err)
+		return
+	}
+	seedRandom(seed + int64(roundCount))
+	fmt.Printf("Snapshot of round %d written to %s\n", roundCount, path)
+}
+
+func chainHashes(chain []Block) []string {
+	hashes := make([]string, len(chain))
+	for i, block := range chain {
+		hashes[i] = block.Hash
+	}
+	return hashes
+}
+
+// writeSnapshot records the round in a snapshot file, storing the blocks of every chain it refers to
+func writeSnapshot(path string) error {
+	s := snapshot{
+		Round:            roundCount,
+		Seed:             seed,
+		ConsensusCounter: runConsensusCounter,
+		TransactionID:    transactionID,
+		TotalIssued:      totalIssued,
+		KeystoreDir:      keystoreDir,
+		Certified:        chainHashes(CertifiedBlockchain),
+		BalanceFork:      chainHashes(balanceAttackFork),
+	}
+	chains := [][]Block{CertifiedBlockchain, balanceAttackFork}
+	genesisLock.Lock()
+	validatorsSliceLock.Lock()
+	for _, validator := range validators {
+		chain := chainSnapshot(validator)
+		chains = append(chains, chain)
+		s.Validators = append(s.Validators, validatorSnapshot{
+			Name:              validator.name,
+			Address:           validator.Address,
+			GenesisStake:      fromFixedPoint(genesisStakes[validator.Address]),
+			Stake:             validator.Stake,
+			InitialStake:      validator.initialStake,
+			Reputation:        validator.reputation,
+			Earned:            validator.earned,
+			Withdrawn:         validator.withdrawn,
+			Malicious:         validator.IsMalicious,
+			Sybil:             validator.isSybil,
+			CommitteeCount:    validator.committeeCount,
+			ProposerCount:     validator.proposerCount,
+			BlockSuccessCount: validator.blockSuccessCount,
+			AttestationCount:  validator.attestationCount,
+			Chain:             chainHashes(chain),
+			Mempool:           snapshotMempool(validator.pool),
+		})
+	}
+	validatorsSliceLock.Unlock()
+	usersSliceLock.Lock()
+	for _, user := range users {
+		user.userLock.Lock()
+		s.Users = append(s.Users, userSnapshot{
+			Name:           user.Name,
+			Address:        user.Address,
+			GenesisBalance: fromFixedPoint(genesisBalances[user.Address]),
+			Balance:        user.Balance,
+			Nonce:          user.nonce,
+		})
+		user.userLock.Unlock()
+	}
+	usersSliceLock.Unlock()
+	genesisLock.Unlock()
+	for _, chain := range chains {
+		if err := storeChain(chain); err != nil {
+			return err
+		}
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// resumeSimulation restores the chains, counters and identities of a snapshot. Users are restored
+// right away, since blocks can only be decoded once their users are known; validators are restored
+// when they join under their snapshot name.
+func resumeSimulation() error {
+	if resumeFrom == "" {
+		return nil
+	}
+	data, err := os.ReadFile(resumeFrom)
+	if err != nil {
+		return err
+	}
+	s := snapshot{}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("snapshot %s: %v", resumeFrom, err)
+	}
+	if keystoreDir == "" {
+		keystoreDir = s.KeystoreDir
+	}
+	if os.Getenv("SEED") == "" {
+		seed = s.Seed
+	}
+	store.loaded, err = readBlockLog(filepath.Join(filepath.Dir(resumeFrom), blockLogName))
+	if err != nil {
+		return err
+	}
+
+	for _, snap := range s.Users {
+		keys, err := loadOrGenerateKey(snap.Name)
+		if err != nil {
+			return err
+		}
+		if addressFromPublicKey(keys.publicKey()) != snap.Address {
+			return fmt.Errorf("key of %s in %s does not match the snapshot", snap.Name, keystoreDir)
+		}
+		user := &User{
+			Name:      snap.Name,
+			Address:   snap.Address,
+			Balance:   snap.Balance,
+			keys:      keys,
+			PublicKey: keys.publicKey(),
+			nonce:     snap.Nonce,
+		}
+		users[snap.Name] = user
+		resumedUsers[snap.Name] = user
+		registerGenesisBalance(snap.Address, snap.GenesisBalance)
+	}
+	for _, snap := range s.Validators {
+		resumedValidators[snap.Name] = snap
+		registerGenesisStake(snap.Address, snap.GenesisStake)
+	}
+
+	if CertifiedBlockchain, err = loadChain(s.Certified); err != nil {
+		return err
+	}
+	if balanceAttackFork, err = loadChain(s.BalanceFork); err != nil {
+		return err
+	}
+	roundCount = s.Round
+	runConsensusCounter = s.ConsensusCounter
+	transactionID = s.TransactionID
+	totalIssued = s.TotalIssued
+	seedRandom(seed + int64(roundCount))
+	fmt.Printf("Resumed round %d from %s with %d validators and %d users\n", roundCount, resumeFrom, len(s.Validators), len(s.Users))
+	return nil
+}
+
+// loadChain decodes the blocks of a chain from the store the simulation resumed from
+func loadChain(hashes []string) ([]Block, error) {
+	chain := make([]Block, 0, len(hashes))
+	for _, hash := range hashes {
+		data, ok := store.loaded[hash]
+		if !ok {
+			return nil, fmt.Errorf("block %s is missing from the store", hash)
+		}
+		block, err := decodeBlock(data)
+		if err != nil {
+			return nil, fmt.Errorf("block %s: %v", hash, err)
+		}
+		chain = append(chain, block)
+	}
+	return chain, nil
+}
+
+// snapshotMempool encodes a validator's pending transactions
+func snapshotMempool(pool *mempool) []pendingSnapshot {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	now := clock.now()
+	ids := make([]int, 0, len(pool.pending))
+	for id := range pool.pending {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	pending := make([]pendingSnapshot, 0, len(ids))
+	for _, id := range ids {
+		pending = append(pending, pendingSnapshot{
+			Transaction: encodeTransaction(pool.pending[id]),
+			Age:         (now - pool.received[id]).Seconds(),
+		})
+	}
+	return pending
+}
+
+// restoreMempool confirms the transactions of a restored chain and puts the snapshot's pending
+// transactions back, as old as they were when the snapshot was written
+func restoreMempool(pool *mempool, chain []Block, pending []pendingSnapshot) error {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+	for _, block := range chain {
+		pool.confirmLocked(block.Transactions)
+	}
+	now := clock.now()
+	for _, snap := range pending {
+		transaction, err := decodeTransaction(snap.Transaction)
+		if err != nil {
+			return err
+		}
+		if pool.confirmed[transaction.ID] {
+			continue
+		}
+		pool.pending[transaction.ID] = transaction
+		pool.received[transaction.ID] = now - time.Duration(snap.Age*float64(time.Second))
+	}
+	if len(pool.pending) > pool.peak {
+		pool.peak = len(pool.pending)
+	}
+	return nil
+}
+
+// restoreValidator sets a joining validator's stake, reputation and counters from its snapshot
+func restoreValidator(validator *Validator, snap validatorSnapshot) {
+	validator.Stake = snap.Stake
+	validator.initialStake = snap.InitialStake
+	validator.reputation = snap.Reputation
+	validator.earned = snap.Earned
+	validator.withdrawn = snap.Withdrawn
+	validator.IsMalicious = snap.Malicious
+	validator.isSybil = snap.Sybil
+	validator.committeeCount = snap.CommitteeCount
+	validator.proposerCount = snap.ProposerCount
+	validator.blockSuccessCount = snap.BlockSuccessCount
+	validator.attestationCount = snap.AttestationCount
+}
+
+// resumedValidator hands a joining validator its snapshot, once, if the simulation was resumed
+func resumedValidator(name string) (validatorSnapshot, bool) {
+	resumeLock.Lock()
+	defer resumeLock.Unlock()
+	snap, ok := resumedValidators[name]
+	delete(resumedValidators, name)
+	return snap, ok
+}
+
+// awaitingResume reports whether a user name belongs to a restored identity that has not reconnected yet
+func awaitingResume(name string) bool {
+	resumeLock.Lock()
+	defer resumeLock.Unlock()
+	_, ok := resumedUsers[name]
+	return ok
+}
+
+// resumedUser hands a joining user its restored identity, once, if the simulation was resumed
+func resumedUser(name string) *User {
+	resumeLock.Lock()
+	defer resumeLock.Unlock()
+	user := resumedUsers[name]
+	delete(resumedUsers, name)
+	return user
+}
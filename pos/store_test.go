@@ -0,0 +1,157 @@
+package pos
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestStore opens an empty block log in a temporary directory
+func newTestStore(t *testing.T) {
+	t.Helper()
+	previousDir, previousStore := storeDir, store
+	storeDir = t.TempDir()
+	store = &blockStore{stored: make(map[string]bool), loaded: make(map[string][]byte)}
+	if err := openStore(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		store.file.Close()
+		storeDir, store = previousDir, previousStore
+	})
+}
+
+// storedBlocks reads the block log back
+func storedBlocks(t *testing.T) map[string][]byte {
+	t.Helper()
+	blocks, err := readBlockLog(filepath.Join(storeDir, blockLogName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return blocks
+}
+
+func TestBlockLogRoundTrip(t *testing.T) {
+	newTestStore(t)
+	genesis := newTestGenesis()
+	chain := []Block{genesis, newTestBlock(genesis, "proposer", nil)}
+	if err := storeChain(chain); err != nil {
+		t.Fatal(err)
+	}
+	//a record cut short by a crash is ignored
+	if _, err := store.file.Write([]byte{0, 0, 0, 64, 'a'}); err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := storedBlocks(t)
+	if len(blocks) != len(chain) {
+		t.Fatalf("got %d blocks from the log, want %d", len(blocks), len(chain))
+	}
+	for _, block := range chain {
+		if !bytes.Equal(blocks[block.Hash], encodeBlock(block)) {
+			t.Fatalf("block %d does not read back as it was written", block.Index)
+		}
+		decoded, err := decodeBlock(blocks[block.Hash])
+		if err != nil || decoded.Hash != block.Hash {
+			t.Fatalf("block %d does not decode: %v", block.Index, err)
+		}
+	}
+}
+
+func TestPersistRoundAppendsCertifiedBlocks(t *testing.T) {
+	newTestStore(t)
+	previousChain, previousValidators := CertifiedBlockchain, validators
+	t.Cleanup(func() { CertifiedBlockchain, validators = previousChain, previousValidators })
+
+	genesis := newTestGenesis()
+	first := newTestBlock(genesis, "proposer", nil)
+	pending := newTestBlock(first, "proposer", nil)
+	validators = []*Validator{newTestValidator(t, []Block{genesis, first, pending})}
+	CertifiedBlockchain = []Block{genesis, first}
+	persistRound()
+	if blocks := storedBlocks(t); len(blocks) != 2 || blocks[pending.Hash] != nil {
+		t.Fatalf("got %d blocks in the log, want only the 2 certified ones", len(blocks))
+	}
+
+	//blocks of the certified chain already in the log are not written again
+	info, err := store.file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	persistRound()
+	if again, _ := store.file.Stat(); again.Size() != info.Size() {
+		t.Fatal("unchanged certified chain was written again")
+	}
+
+	//after a reorg the new branch is appended from the fork point
+	replaced := newTestBlock(genesis, "other", nil)
+	CertifiedBlockchain = []Block{genesis, replaced}
+	persistRound()
+	if blocks := storedBlocks(t); len(blocks) != 3 || blocks[replaced.Hash] == nil {
+		t.Fatalf("got %d blocks in the log after a reorg, want 3", len(blocks))
+	}
+	if store.certified != 2 {
+		t.Fatalf("cursor at %d, want 2", store.certified)
+	}
+}
+
+func TestSnapshotRestoresMempool(t *testing.T) {
+	newTestStore(t)
+	previousChain, previousValidators, previousUsers := CertifiedBlockchain, validators, users
+	t.Cleanup(func() { CertifiedBlockchain, validators, users = previousChain, previousValidators, previousUsers })
+	alice := newTestUser(t, "alice", 100)
+	bob := newTestUser(t, "bob", 100)
+	users = map[string]*User{"alice": alice, "bob": bob}
+
+	genesis := newTestGenesis()
+	confirmed := newTestTransaction(1, alice, bob, 1, 1, 0)
+	head := newTestBlock(genesis, "proposer", []Transaction{confirmed})
+	validator := newTestValidator(t, []Block{genesis, head})
+	validator.pool = newMempool()
+	if err := validator.pool.add(newTestTransaction(2, alice, bob, 1, 1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	validators = []*Validator{validator}
+	CertifiedBlockchain = []Block{genesis}
+	advanceClock(t, 5*time.Second)
+
+	path := filepath.Join(storeDir, "snapshot.json")
+	if err := writeSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := snapshot{}
+	if err := json.Unmarshal(data, &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Validators) != 1 || len(s.Validators[0].Mempool) != 1 {
+		t.Fatal("snapshot does not hold the validator's pending transaction")
+	}
+	//the validator's uncertified head is stored so the snapshot can be resumed
+	store.loaded = storedBlocks(t)
+	chain, err := loadChain(s.Validators[0].Chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := newMempool()
+	pending := append(s.Validators[0].Mempool, pendingSnapshot{Transaction: encodeTransaction(confirmed)})
+	if err := restoreMempool(pool, chain, pending); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pool.pending[2]; !ok || len(pool.pending) != 1 {
+		t.Fatalf("got %d pending transactions, want only the unconfirmed one", len(pool.pending))
+	}
+	if !pool.isConfirmed(1) {
+		t.Fatal("transaction of the restored chain is not confirmed")
+	}
+	if age := clock.now() - pool.received[2]; age < 5*time.Second || age > 6*time.Second {
+		t.Fatalf("restored transaction is %s old, want about 5s", age)
+	}
+}
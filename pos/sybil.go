@@ -0,0 +1,147 @@
+package pos
+
+import (
+	"fmt"
+	"net"
+	"sort"
+)
+
+// Total stake the sybil adversary splits across its identities
+var sybilStakeBudget = 3000.0
+
+// Number of validator identities the sybil adversary creates
+var sybilIdentities = 30
+
+// Time slots between two sybil identities joining
+var sybilJoinInterval = 1
+
+var sybilJoined = 0
+
+// Running sums used to compare seat share with stake share
+var sybilCommitteeRounds = 0
+var sybilCommitteeSeatShare = 0.0
+var sybilCommitteeStakeShare = 0.0
+var sybilDelegateElections = 0
+var sybilDelegateSeatShare = 0.0
+var sybilDelegateStakeShare = 0.0
+
+func loadSybilConfig() {
+	sybilStakeBudget = envFloat("SYBIL_STAKE_BUDGET", sybilStakeBudget)
+	sybilIdentities = envInt("SYBIL_IDENTITIES", sybilIdentities)
+	sybilJoinInterval = envInt("SYBIL_JOIN_INTERVAL", sybilJoinInterval)
+}
+
+// runSybilAdversary splits the stake budget evenly across identities that join the network over time
+func runSybilAdversary(runType string, address string) {
+	if sybilIdentities <= 0 {
+		return
+	}
+	stakeString := fmt.Sprintf("%f", sybilStakeBudget/float64(sybilIdentities))
+	for i := 0; i < sybilIdentities; i++ {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			fmt.Println("Error connecting:", err)
+			return
+		}
+		go handleConnection(conn, runType, "v", "y", stakeString, true, false)
+		sybilJoined++
+		waitSlots(sybilJoinInterval)
+	}
+}
+
+// waitSlots blocks until the given number of time slots have ended
+func waitSlots(slots int) {
+	roundEnded.L.Lock()
+	defer roundEnded.L.Unlock()
+	until := roundCount + slots
+	for roundCount < until {
+		roundEnded.Wait()
+	}
+}
+
+// sybilStakeShare returns the fraction of all stake held by sybil identities
+func sybilStakeShare(validators []*Validator) float64 {
+	totalStake := 0.0
+	sybilStake := 0.0
+	for _, validator := range validators {
+		totalStake += validator.Stake
+		if validator.isSybil {
+			sybilStake += validator.Stake
+		}
+	}
+	if totalStake == 0 {
+		return 0
+	}
+	return sybilStake / totalStake
+}
+
+// sybilSeatShare returns the fraction of seats in a committee held by sybil identities
+func sybilSeatShare(committee []*Validator) float64 {
+	if len(committee) == 0 {
+		return 0
+	}
+	seats := 0
+	for _, validator := range committee {
+		if validator != nil && validator.isSybil {
+			seats++
+		}
+	}
+	return float64(seats) / float64(len(committee))
+}
+
+func recordSybilCommittee(committee []*Validator) {
+	if len(committee) == 0 {
+		return
+	}
+	sybilCommitteeRounds++
+	sybilCommitteeSeatShare += sybilSeatShare(committee)
+	sybilCommitteeStakeShare += sybilStakeShare(validators)
+}
+
+func recordSybilDelegates(elected []*Validator) {
+	if len(elected) == 0 {
+		return
+	}
+	sybilDelegateElections++
+	sybilDelegateSeatShare += sybilSeatShare(elected)
+	sybilDelegateStakeShare += sybilStakeShare(validators)
+}
+
+// sybilDelegateVotes has sybil identities vote for each other before anyone else
+func sybilDelegateVotes(validators []*Validator, delegateSize int) []*Validator {
+	votes := make([]*Validator, 0, delegateSize)
+	others := make([]*Validator, 0, len(validators))
+	for _, validator := range validators {
+		if validator.isSybil {
+			votes = append(votes, validator)
+		} else {
+			others = append(others, validator)
+		}
+	}
+	sort.Slice(votes, func(i, j int) bool {
+		return votes[i].reputation > votes[j].reputation
+	})
+	sort.Slice(others, func(i, j int) bool {
+		return others[i].reputation > others[j].reputation
+	})
+	votes = append(votes, others...)
+	if len(votes) > delegateSize {
+		votes = votes[:delegateSize]
+	}
+	return votes
+}
+
+func printSybilEvaluation() {
+	fmt.Printf("Sybil identities joined: %d/%d\n", sybilJoined, sybilIdentities)
+	fmt.Printf("Sybil stake share now: %f\n", sybilStakeShare(validators))
+	if sybilCommitteeRounds > 0 {
+		seatShare := sybilCommitteeSeatShare / float64(sybilCommitteeRounds)
+		stakeShare := sybilCommitteeStakeShare / float64(sybilCommitteeRounds)
+		fmt.Printf("Sybil committee seat share: %f (stake share %f, bias %f)\n", seatShare, stakeShare, seatShare-stakeShare)
+	}
+	if sybilDelegateElections > 0 {
+		seatShare := sybilDelegateSeatShare / float64(sybilDelegateElections)
+		stakeShare := sybilDelegateStakeShare / float64(sybilDelegateElections)
+		fmt.Printf("Sybil delegate seat share: %f (stake share %f, bias %f)\n", seatShare, stakeShare, seatShare-stakeShare)
+	}
+}
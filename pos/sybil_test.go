@@ -0,0 +1,67 @@
+package pos
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSybilShares(t *testing.T) {
+	honest := &Validator{Address: "honest", Stake: 60}
+	first := &Validator{Address: "sybil0", Stake: 20, isSybil: true}
+	second := &Validator{Address: "sybil1", Stake: 20, isSybil: true}
+	all := []*Validator{honest, first, second}
+
+	if share := sybilStakeShare(all); share != 0.4 {
+		t.Fatalf("got stake share %f, want 0.4", share)
+	}
+	if share := sybilSeatShare([]*Validator{first, second, honest, nil}); share != 0.5 {
+		t.Fatalf("got seat share %f, want 0.5", share)
+	}
+	if share := sybilStakeShare([]*Validator{{}}); share != 0 {
+		t.Fatalf("got stake share %f without stake, want 0", share)
+	}
+}
+
+func TestSybilDelegateVotesPreferIdentities(t *testing.T) {
+	honest := &Validator{Address: "honest", reputation: 90}
+	strong := &Validator{Address: "sybil0", reputation: 5, isSybil: true}
+	weak := &Validator{Address: "sybil1", reputation: 1, isSybil: true}
+	other := &Validator{Address: "other", reputation: 50}
+
+	votes := sybilDelegateVotes([]*Validator{honest, weak, other, strong}, 3)
+	want := []*Validator{strong, weak, honest}
+	if len(votes) != len(want) {
+		t.Fatalf("got %d votes, want %d", len(votes), len(want))
+	}
+	for i := range want {
+		if votes[i] != want[i] {
+			t.Fatalf("vote %d went to %s, want %s", i, votes[i].Address, want[i].Address)
+		}
+	}
+}
+
+func TestWaitSlotsWakesAfterRounds(t *testing.T) {
+	previousRound := roundCount
+	t.Cleanup(func() { roundCount = previousRound })
+
+	done := make(chan struct{})
+	go func() {
+		waitSlots(2)
+		close(done)
+	}()
+	//the waiter may start before or after the first round ends, so keep ending rounds until it returns
+	for ended := 0; ; ended++ {
+		select {
+		case <-done:
+			if ended < 2 {
+				t.Fatalf("waitSlots returned after %d rounds, want at least 2", ended)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+			if ended > 100 {
+				t.Fatal("waitSlots never returned")
+			}
+			endRound()
+		}
+	}
+}
@@ -0,0 +1,407 @@
+package pos
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Validators that join late or fall behind download the chain from their peers: they ask a few
+// peers for their headers, pick the longest header chain that links up, then fetch its blocks and
+// only adopt them if every block's hash, commit certificate and state transition checks out.
+
+// Peers asked for headers in every sync
+var syncPeers = 3
+
+// Milliseconds a syncing validator waits for its peers to answer
+var syncTimeout = 1000.0
+
+// Whether malicious validators answer header requests with a fabricated longer chain
+var syncBadChain = false
+
+// Number of blocks a fabricated chain extends past the malicious validator's head
+var syncBadChainLength = 5
+
+type syncStats struct {
+	lock          sync.Mutex
+	nextID        int
+	started       int
+	completed     int
+	failed        int
+	headers       int
+	blocks        int
+	badChains     int
+	badHeaders    int
+	durations     []float64
+	joinDurations []float64
+}
+
+var syncs = &syncStats{}
+
+// Verified blocks received by each validator that is catching up, keyed by address, guarded by syncs.lock
+var catchUps = make(map[string][]VerifiedBlockMessage)
+
+func loadSyncConfig() {
+	syncPeers = envInt("SYNC_PEERS", syncPeers)
+	if syncPeers < 1 {
+		fmt.Println("SYNC_PEERS must be at least 1, using 1")
+		syncPeers = 1
+	}
+	syncTimeout = envFloat("SYNC_TIMEOUT", syncTimeout)
+	syncBadChain = envString("SYNC_BAD_CHAIN", "n") == "y"
+	syncBadChainLength = envInt("SYNC_BAD_CHAIN_LENGTH", syncBadChainLength)
+}
+
+// sendSync delivers a sync request or response to one of a validator's sync channels, dropping it
+// if the validator is not keeping up with them
+func sendSync(from *Validator, to *Validator, channel chan interface{}, mailbox string, msg interface{}) {
+	deliver := func(msg interface{}) {
+		select {
+		case channel <- msg:
+		default:
+		}
+	}
+	if !networkEnabled() {
+		deliver(msg)
+		return
+	}
+	simNetwork.send(from.Address, to.Address, mailbox+"/"+to.Address, msg, deliver)
+}
+
+// chainSnapshot copies a validator's chain under its chain lock
+func chainSnapshot(validator *Validator) []Block {
+	validator.chainLock.Lock()
+	defer validator.chainLock.Unlock()
+	chain := make([]Block, len(validator.Blockchain))
+	copy(chain, validator.Blockchain)
+	return chain
+}
+
+// chainHead returns the last block of a validator's chain
+func chainHead(validator *Validator) Block {
+	validator.chainLock.Lock()
+	defer validator.chainLock.Unlock()
+	return validator.Blockchain[len(validator.Blockchain)-1]
+}
+
+// serveSync answers the header and block requests of syncing peers from the validator's chain
+func serveSync(validator *Validator) {
+	for msg := range validator.syncRequestChannel {
+		chain := chainSnapshot(validator)
+		if syncBadChain && validator.IsMalicious {
+			chain = append(chain[:len(chain):len(chain)], fabricateChain(validator, chain[len(chain)-1], syncBadChainLength)...)
+		}
+		switch msg := msg.(type) {
+		case HeadersRequestMessage:
+			headers := make([]BlockHeader, 0)
+			for i := msg.from; i < len(chain); i++ {
+				headers = append(headers, chain[i].header())
+			}
+			sendSync(validator, msg.requester, msg.requester.syncResponseChannel, "sync", HeadersMessage{id: msg.id, responder: validator, headers: headers})
+		case BlocksRequestMessage:
+			byHash := make(map[string]Block)
+			for _, block := range chain {
+				byHash[block.Hash] = block
+			}
+			blocks := make([]Block, 0, len(msg.hashes))
+			for _, hash := range msg.hashes {
+				if block, ok := byHash[hash]; ok {
+					blocks = append(blocks, block)
+				}
+			}
+			sendSync(validator, msg.requester, msg.requester.syncResponseChannel, "sync", BlocksMessage{id: msg.id, responder: validator, blocks: blocks})
+		}
+	}
+}
+
+// fabricateChain builds blocks on top of head that hash correctly but carry no commit certificate
+func fabricateChain(validator *Validator, head Block, length int) []Block {
+	blocks := make([]Block, 0, length)
+	for i := 0; i < length; i++ {
+		block := Block{
+			Index:     head.Index + 1,
+			Timestamp: fmt.Sprintf("fabricated %d", head.Index+1),
+			PrevHash:  head.Hash,
+			Validator: validator.Address,
+			StateRoot: head.StateRoot,
+			TxRoot:    transactionRoot(nil),
+			BaseFee:   head.BaseFee,
+		}
+		block.Hash = calculateBlockHash(block)
+		blocks = append(blocks, block)
+		head = block
+	}
+	return blocks
+}
+
+// awaitSync collects up to count responses to the sync request with the given id until the deadline
+func awaitSync(validator *Validator, id int, count int, deadline time.Duration) []interface{} {
+	responses := make([]interface{}, 0, count)
+	for len(responses) < count {
+		wait := deadline - clock.now()
+		if wait <= 0 {
+			break
+		}
+		select {
+		case msg := <-validator.syncResponseChannel:
+			if syncResponseID(msg) == id {
+				responses = append(responses, msg)
+			}
+		case <-time.After(clock.realDuration(wait)):
+		}
+	}
+	return responses
+}
+
+func syncResponseID(msg interface{}) int {
+	switch msg := msg.(type) {
+	case HeadersMessage:
+		return msg.id
+	case BlocksMessage:
+		return msg.id
+	}
+	return -1
+}
+
+// linksUp checks that headers extend the genesis block one by one and hash correctly
+func linksUp(genesis Block, headers []BlockHeader) bool {
+	prev := genesis.header()
+	for _, header := range headers {
+		if header.PrevHash != prev.Hash || header.Index != prev.Index+1 || calculateHeaderHash(header) != header.Hash {
+			return false
+		}
+		prev = header
+	}
+	return true
+}
+
+// syncCandidate is a peer's header chain the validator could switch to
+type syncCandidate struct {
+	peer    *Validator
+	headers []BlockHeader
+}
+
+// syncChain brings a validator up to the longest valid chain among a few of its peers, returning
+// false if no peer answered or the blocks of a longer chain never arrived
+func syncChain(validator *Validator, peers []*Validator) bool {
+	syncs.lock.Lock()
+	syncs.nextID++
+	id := syncs.nextID
+	syncs.started++
+	syncs.lock.Unlock()
+	start := clock.now()
+
+	candidates := make([]*Validator, 0, len(peers))
+	for _, peer := range peers {
+		if peer != validator {
+			candidates = append(candidates, peer)
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	if len(candidates) > syncPeers {
+		candidates = candidates[:syncPeers]
+	}
+	if len(candidates) == 0 {
+		syncFinished(false, start, 0, 0, 0, 0)
+		return false
+	}
+
+	//ask for every header after genesis, since the validator may be on a fork
+	for _, peer := range candidates {
+		sendSync(validator, peer, peer.syncRequestChannel, "syncreq", HeadersRequestMessage{id: id, requester: validator, from: 1})
+	}
+	responses := awaitSync(validator, id, len(candidates), clock.now()+milliseconds(syncTimeout))
+
+	local := chainSnapshot(validator)
+	genesis := local[0]
+	offered := make([]syncCandidate, 0, len(responses))
+	headerCount, badHeaders := 0, 0
+	for _, response := range responses {
+		msg, ok := response.(HeadersMessage)
+		if !ok {
+			continue
+		}
+		headerCount += len(msg.headers)
+		if !linksUp(genesis, msg.headers) {
+			badHeaders++
+			continue
+		}
+		offered = append(offered, syncCandidate{peer: msg.responder, headers: msg.headers})
+	}
+	//fork choice: the longest chain first
+	sort.SliceStable(offered, func(i, j int) bool { return len(offered[i].headers) > len(offered[j].headers) })
+
+	blockCount, badChains, unanswered := 0, 0, 0
+	for _, candidate := range offered {
+		if len(candidate.headers)+1 <= len(local) {
+			break
+		}
+		common := 1
+		for common < len(local) && local[common].Hash == candidate.headers[common-1].Hash {
+			common++
+		}
+		hashes := make([]string, 0, len(candidate.headers))
+		for _, header := range candidate.headers[common-1:] {
+			hashes = append(hashes, header.Hash)
+		}
+		sendSync(validator, candidate.peer, candidate.peer.syncRequestChannel, "syncreq", BlocksRequestMessage{id: id, requester: validator, hashes: hashes})
+		replies := awaitSync(validator, id, 1, clock.now()+milliseconds(syncTimeout))
+		msg, ok := BlocksMessage{}, false
+		if len(replies) > 0 {
+			msg, ok = replies[0].(BlocksMessage)
+		}
+		if !ok {
+			unanswered++
+			continue
+		}
+		blockCount += len(msg.blocks)
+		if err := checkSyncedBlocks(validator, common, candidate.headers[common-1:], msg.blocks); err != nil {
+			fmt.Printf("Validator %s rejected the chain of %s: %s\n", validator.Address[:3], candidate.peer.Address[:3], err.Error())
+			badChains++
+			continue
+		}
+		chain := make([]Block, 0, common+len(msg.blocks))
+		chain = append(chain, local[:common]...)
+		chain = append(chain, msg.blocks...)
+		adoptChain(validator, chain)
+		syncFinished(true, start, headerCount, blockCount, badHeaders, badChains)
+		return true
+	}
+	//nothing longer was offered, or none of it checked out; the validator is only behind if a
+	//longer chain may have been valid but its blocks never arrived
+	synced := len(responses) > 0 && unanswered == 0
+	syncFinished(synced, start, headerCount, blockCount, badHeaders, badChains)
+	return synced
+}
+
+// checkSyncedBlocks verifies downloaded blocks against the headers they were requested for and
+// executes them on top of the validator's state at the fork point
+func checkSyncedBlocks(validator *Validator, common int, headers []BlockHeader, blocks []Block) error {
+	if len(blocks) != len(headers) {
+		return fmt.Errorf("got %d of %d blocks", len(blocks), len(headers))
+	}
+	validator.chainLock.Lock()
+	state := validator.state.copy()
+	validator.chainLock.Unlock()
+	for state.height() > common {
+		state.revertBlock()
+	}
+	for i, block := range blocks {
+		if block.Hash != headers[i].Hash || calculateBlockHash(block) != block.Hash {
+			return fmt.Errorf("block %d does not match its header", block.Index)
+		}
+		if err := verifyBlockCertificate(block); err != nil {
+			return fmt.Errorf("block %d: %s", block.Index, err.Error())
+		}
+		if err := state.applyBlock(block); err != nil {
+			return fmt.Errorf("block %d is not a valid state transition: %s", block.Index, err.Error())
+		}
+	}
+	return nil
+}
+
+func syncFinished(completed bool, start time.Duration, headers int, blocks int, badHeaders int, badChains int) {
+	syncs.lock.Lock()
+	defer syncs.lock.Unlock()
+	if completed {
+		syncs.completed++
+		syncs.durations = append(syncs.durations, float64((clock.now()-start).Microseconds())/1000)
+	} else {
+		syncs.failed++
+	}
+	syncs.headers += headers
+	syncs.blocks += blocks
+	syncs.badHeaders += badHeaders
+	syncs.badChains += badChains
+}
+
+// livePeers returns the validators that have not crashed
+func livePeers() []*Validator {
+	validatorsSliceLock.Lock()
+	defer validatorsSliceLock.Unlock()
+	peers := make([]*Validator, 0, len(validators))
+	for _, peer := range validators {
+		if !crashed(peer) {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// joinSync syncs a new validator from the validators already running, starting from the genesis block
+func joinSync(validator *Validator) {
+	peers := livePeers()
+	if len(peers) == 0 {
+		return
+	}
+	start := clock.now()
+	syncChain(validator, peers)
+	syncs.lock.Lock()
+	syncs.joinDurations = append(syncs.joinDurations, float64((clock.now()-start).Microseconds())/1000)
+	syncs.lock.Unlock()
+}
+
+// isBehind reports whether block is further ahead than the next block on the validator's head
+// Validators of the balance attack keep their split view and never sync
+func isBehind(block Block, validator *Validator) bool {
+	head := chainHead(validator)
+	return block.Index > head.Index+1 && currAttack != "balance"
+}
+
+// catchUp starts syncing a validator that received a block too far ahead of its head, unless it is
+// catching up already. The sync runs alongside the validator's message loop, which gets a
+// CaughtUpMessage once the sync has finished
+func catchUp(validator *Validator) {
+	syncs.lock.Lock()
+	if _, running := catchUps[validator.Address]; running {
+		syncs.lock.Unlock()
+		return
+	}
+	catchUps[validator.Address] = make([]VerifiedBlockMessage, 0)
+	syncs.lock.Unlock()
+	go func() {
+		syncChain(validator, livePeers())
+		validator.incomingChannel <- CaughtUpMessage{}
+	}()
+}
+
+// bufferWhileCatchingUp holds back a verified block that arrives while its validator is catching up,
+// since the sync may replace the chain the block would be appended to
+func bufferWhileCatchingUp(validator *Validator, msg VerifiedBlockMessage) bool {
+	syncs.lock.Lock()
+	defer syncs.lock.Unlock()
+	buffered, running := catchUps[validator.Address]
+	if !running {
+		return false
+	}
+	catchUps[validator.Address] = append(buffered, msg)
+	return true
+}
+
+// finishCatchUp ends a validator's catch-up and returns the verified blocks it buffered, in the order they arrived
+func finishCatchUp(validator *Validator) []VerifiedBlockMessage {
+	syncs.lock.Lock()
+	defer syncs.lock.Unlock()
+	buffered := catchUps[validator.Address]
+	delete(catchUps, validator.Address)
+	return buffered
+}
+
+func printSyncEvaluation() {
+	syncs.lock.Lock()
+	defer syncs.lock.Unlock()
+	if syncs.started == 0 {
+		return
+	}
+	durations := make([]float64, len(syncs.durations))
+	copy(durations, syncs.durations)
+	sort.Float64s(durations)
+	joins := make([]float64, len(syncs.joinDurations))
+	copy(joins, syncs.joinDurations)
+	sort.Float64s(joins)
+	fmt.Printf("Syncs started: %d, completed: %d, failed: %d, headers received: %d, blocks received: %d\n", syncs.started, syncs.completed, syncs.failed, syncs.headers, syncs.blocks)
+	fmt.Printf("Sync time p50: %f ms, max: %f ms, join sync time p50: %f ms, max: %f ms\n", percentile(durations, 50), percentile(durations, 100), percentile(joins, 50), percentile(joins, 100))
+	fmt.Printf("Header chains that did not link up: %d, chains rejected after download: %d\n", syncs.badHeaders, syncs.badChains)
+}
@@ -0,0 +1,85 @@
+package pos
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestCertifiedChain extends genesis by count blocks proposed and certified by the committee
+func newTestCertifiedChain(genesis Block, committee []*Validator, count int) []Block {
+	chain := []Block{genesis}
+	for i := 0; i < count; i++ {
+		block := newTestBlock(chain[len(chain)-1], committee[0].Address, nil)
+		signBlock(&block, committee[0])
+		block.Certificate = newCommitCertificate(block.Hash, committee, testVotes(block.Hash, committee))
+		chain = append(chain, block)
+	}
+	return chain
+}
+
+// newTestSyncPeer gives a validator the chain and channels it needs to take part in syncs
+func newTestSyncPeer(t *testing.T, validator *Validator, chain []Block) {
+	t.Helper()
+	peer := newTestValidator(t, chain)
+	peer.Address, peer.PublicKey, peer.keys = validator.Address, validator.PublicKey, validator.keys
+	*validator = *peer
+	validator.pool = newMempool()
+	validator.incomingChannel = make(chan interface{}, 1)
+	validator.syncRequestChannel = make(chan interface{}, 64)
+	validator.syncResponseChannel = make(chan interface{}, 64)
+}
+
+func TestSyncChainDownloadsCertifiedBlocks(t *testing.T) {
+	committee := newTestCommittee(t, 3)
+	genesis := newTestGenesis()
+	chain := newTestCertifiedChain(genesis, committee, 3)
+	ahead, behind := committee[1], committee[2]
+	newTestSyncPeer(t, ahead, chain)
+	newTestSyncPeer(t, behind, chain[:1])
+	go serveSync(ahead)
+	defer close(ahead.syncRequestChannel)
+
+	if !syncChain(behind, []*Validator{ahead}) {
+		t.Fatal("sync from a peer with a longer certified chain failed")
+	}
+	synced := chainSnapshot(behind)
+	if len(synced) != len(chain) || synced[len(synced)-1].Hash != chain[len(chain)-1].Hash {
+		t.Fatalf("validator holds %d blocks after sync, want %d", len(synced), len(chain))
+	}
+}
+
+func TestCatchUpBuffersVerifiedBlocks(t *testing.T) {
+	committee := newTestCommittee(t, 1)
+	validator := committee[0]
+	newTestSyncPeer(t, validator, []Block{newTestGenesis()})
+	msg := VerifiedBlockMessage{newBlock: newTestBlock(newTestGenesis(), validator.Address, nil)}
+
+	if bufferWhileCatchingUp(validator, msg) {
+		t.Fatal("block buffered without a catch-up running")
+	}
+	//with no peers to ask the sync ends at once, but only one catch-up runs at a time
+	catchUp(validator)
+	if !bufferWhileCatchingUp(validator, msg) {
+		t.Fatal("block arriving during a catch-up was not buffered")
+	}
+	catchUp(validator)
+	select {
+	case done := <-validator.incomingChannel:
+		if _, ok := done.(CaughtUpMessage); !ok {
+			t.Fatalf("got %T when the catch-up finished", done)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("catch-up never finished")
+	}
+	if buffered := finishCatchUp(validator); len(buffered) != 1 || buffered[0].newBlock.Hash != msg.newBlock.Hash {
+		t.Fatalf("got %d buffered blocks, want the one received during the catch-up", len(buffered))
+	}
+	select {
+	case extra := <-validator.incomingChannel:
+		t.Fatalf("a second catch-up ran and sent %T", extra)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if bufferWhileCatchingUp(validator, msg) {
+		t.Fatal("block buffered after the catch-up finished")
+	}
+}
@@ -2,11 +2,6 @@ package pos
 
 import (
 	"bufio"
-	"crypto"
-	"crypto/rand"
-	"crypto/rsa"
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
 	"io"
 	mathrand "math/rand"
@@ -23,9 +18,10 @@ type User struct {
 	Name        string
 	Address     string
 	Balance     float64
-	PublicKey   *rsa.PublicKey
-	privateKey  *rsa.PrivateKey
+	PublicKey   []byte
+	keys        keyPair
 	userLock    sync.Mutex
+	nonce       int
 }
 
 type Transaction struct {
@@ -35,6 +31,9 @@ type Transaction struct {
 	Signature string
 	Amount    float64
 	Reward    float64
+	Nonce     int
+	Inputs    []OutPoint
+	Outputs   []TxOutput
 }
 
 var transactionID = 0
@@ -48,37 +47,80 @@ var userIDLock = &sync.Mutex{}
 var transactionIDLock = &sync.Mutex{}
 
 func generateTransaction(index int, sender *User, receiver *User, amount float64, reward float64) Transaction {
+	sender.userLock.Lock()
+	nonce := sender.nonce
+	sender.nonce++
+	sender.userLock.Unlock()
+	return generateTransactionWithNonce(index, sender, receiver, amount, reward, nonce)
+}
+
+// resyncNonces rewinds a user's nonce to the next one the certified chain expects when no validator
+// still holds a transaction with that nonce, so a dropped transaction does not leave a gap that
+// blocks every later transaction of its sender
+func resyncNonces() {
+	if ledgerMode == utxoLedgerMode {
+		return
+	}
+	next := make(map[*User]int)
+	for _, block := range CertifiedBlockchain {
+		for _, transaction := range block.Transactions {
+			if transaction.Sender != nil && transaction.Nonce+1 > next[transaction.Sender] {
+				next[transaction.Sender] = transaction.Nonce + 1
+			}
+		}
+	}
+	validatorsSliceLock.Lock()
+	pending := make(map[*User]map[int]bool)
+	for _, validator := range validators {
+		for _, transaction := range validator.pool.candidates(nil) {
+			if pending[transaction.Sender] == nil {
+				pending[transaction.Sender] = make(map[int]bool)
+			}
+			pending[transaction.Sender][transaction.Nonce] = true
+		}
+	}
+	validatorsSliceLock.Unlock()
+
+	usersSliceLock.Lock()
+	defer usersSliceLock.Unlock()
+	for _, user := range users {
+		user.userLock.Lock()
+		if user.nonce > next[user] && !pending[user][next[user]] {
+			user.nonce = next[user]
+		}
+		user.userLock.Unlock()
+	}
+}
+
+// generateTransactionWithNonce signs a transaction with a caller chosen nonce, e.g. to build a conflicting spend
+func generateTransactionWithNonce(index int, sender *User, receiver *User, amount float64, reward float64, nonce int) Transaction {
 	transaction := Transaction{
 		ID:       index,
 		Sender:   sender,
 		Receiver: receiver,
 		Amount:   amount,
 		Reward:   reward,
+		Nonce:    nonce,
 	}
-	signTransaction(&transaction, sender.privateKey)
+	signTransaction(&transaction, sender.keys)
+	recordTransactionSubmitted(index)
 	return transaction
 }
 
-func signTransaction(t *Transaction, privateKey *rsa.PrivateKey) error {
-	// Concatenate the transaction data into a single string
-	data := fmt.Sprintf("%d%p%p%f%f", t.ID, t.Sender, t.Receiver, t.Amount, t.Reward)
-
-	// Hash the data using SHA256
-	hash := sha256.Sum256([]byte(data))
-
-	// Sign the hashed data using the private key
-	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, hash[:])
+func signTransaction(t *Transaction, keys keyPair) error {
+	// Sign the canonical transaction encoding with the sender's key
+	signature, err := keys.sign(transactionSigningData(*t))
 	if err != nil {
 		return err
 	}
 
-	// Encode the signature as a base64 string
-	t.Signature = hex.EncodeToString(signature)
+	// Encode the signature as a hex string
+	t.Signature = signature
 
 	return nil
 }
 
-func handleUserConnection(conn net.Conn, runType string) {
+func handleUserConnection(conn net.Conn, runType string, malString string) {
 	defer conn.Close()
 
 	//Enter initial stake and whether or not validator is malicious
@@ -86,7 +128,6 @@ func handleUserConnection(conn net.Conn, runType string) {
 	scannedBalance := bufio.NewScanner(conn)
 	if runType == "auto" {
 		randomBalance := 0.0
-		mathrand.Seed(time.Now().UnixNano())
 		randomBalance = mathrand.Float64()*1000 + 10
 		randomBalanceString := fmt.Sprintf("%f", randomBalance)
 		scannedBalance = bufio.NewScanner(strings.NewReader(randomBalanceString))
@@ -115,42 +156,75 @@ func handleUserConnection(conn net.Conn, runType string) {
 	}
 	for scannedName.Scan() {
 		name = scannedName.Text()
-		if _, ok := users[name]; ok {
+		if _, ok := users[name]; ok && !awaitingResume(name) {
 			fmt.Printf("Name: %s already taken: \n", name)
 		} else {
 			break
 		}
 	}
 
-	//Calculate address based on time
-	t := time.Now()
-	address := calculateHash(t.String())
-
-	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
-	if err != nil {
-		fmt.Println("Error generating private key:", err)
-		return
+	//Colluding users only exist for the double spend attack
+	isMal := false
+	if currAttack == "double_spend" {
+		io.WriteString(conn, "Is this user malicious (y/n)\n")
+		scannedMal := bufio.NewScanner(conn)
+		if runType == "auto" {
+			scannedMal = bufio.NewScanner(strings.NewReader(malString))
+		}
+		for scannedMal.Scan() {
+			if scannedMal.Text() != "y" && scannedMal.Text() != "n" {
+				io.WriteString(conn, scannedMal.Text()+" is not a valid response\n Please enter 'y' or 'n' ")
+				return
+			}
+			if scannedMal.Text() == "y" {
+				isMal = true
+			}
+			break
+		}
 	}
 
-	publicKey := &privateKey.PublicKey
-
-	//Instantiate new validator
-	curUser := &User{
-		conn:        conn,
-		commChannel: make(chan interface{}),
-		Name:        name,
-		Address:     address,
-		Balance:     float64(balance),
-		privateKey:  privateKey,
-		PublicKey:   publicKey,
-		userLock:    sync.Mutex{},
-	}
+	//a user of a resumed simulation reconnects to its restored identity
+	curUser := resumedUser(name)
+	if curUser != nil {
+		curUser.conn = conn
+		curUser.commChannel = make(chan interface{})
+	} else {
+		keys, err := loadOrGenerateKey(name)
+		if err != nil {
+			fmt.Println("Error loading private key:", err)
+			return
+		}
+
+		//Calculate address based on public key
+		address := addressFromPublicKey(keys.publicKey())
+
+		//Instantiate new validator
+		curUser = &User{
+			conn:        conn,
+			commChannel: make(chan interface{}),
+			Name:        name,
+			Address:     address,
+			Balance:     float64(balance),
+			keys:        keys,
+			PublicKey:   keys.publicKey(),
+			userLock:    sync.Mutex{},
+		}
 
-	users[name] = curUser
+		users[name] = curUser
+		registerGenesisBalance(address, balance)
+	}
 
 	fmt.Printf("new user count: %d\n", len(users))
 
+	transactionCount := 0
 	for {
+		transactionCount++
+		if isMal && transactionCount%doubleSpendInterval == 0 {
+			attemptDoubleSpend(curUser)
+			time.Sleep(1 * time.Second)
+			continue
+		}
+
 		io.WriteString(conn, "Starting new transaction\n")
 		io.WriteString(conn, "Enter receiver name:\n")
 		scannedReceiver := bufio.NewScanner(conn)
@@ -158,7 +232,6 @@ func handleUserConnection(conn net.Conn, runType string) {
 
 		if runType == "auto" {
 			usersSliceLock.Lock()
-			mathrand.Seed(time.Now().UnixNano())
 			randomIndex := 0
 			if len(users)-1 > 0 {
 				randomIndex = mathrand.Intn(len(users) - 1)
@@ -185,7 +258,6 @@ func handleUserConnection(conn net.Conn, runType string) {
 		scannedAmount := bufio.NewScanner(conn)
 		if runType == "auto" {
 			randomAmount := 0.0
-			mathrand.Seed(time.Now().UnixNano())
 			randomAmount = mathrand.Float64()*100 + 1
 			randomAmountString := fmt.Sprintf("%f", randomAmount)
 			scannedAmount = bufio.NewScanner(strings.NewReader(randomAmountString))
@@ -204,7 +276,6 @@ func handleUserConnection(conn net.Conn, runType string) {
 		scannedReward := bufio.NewScanner(conn)
 		if runType == "auto" {
 			randomReward := 0.0
-			mathrand.Seed(time.Now().UnixNano())
 			randomReward = mathrand.Float64()*5 + 0
 			randomRewardString := fmt.Sprintf("%f", randomReward)
 			scannedReward = bufio.NewScanner(strings.NewReader(randomRewardString))
@@ -219,12 +290,51 @@ func handleUserConnection(conn net.Conn, runType string) {
 			break
 		}
 
+		//In the UTXO ledger the user picks which of their coins the transaction spends
+		var spentCoins []coin
+		if ledgerMode == utxoLedgerMode {
+			coins := walletCoins(curUser)
+			io.WriteString(conn, "Your coins:\n")
+			for i, c := range coins {
+				io.WriteString(conn, fmt.Sprintf("%d: %s (%f)\n", i, c.outPoint.key(), c.amount))
+			}
+			io.WriteString(conn, "Enter coins to spend (comma separated numbers, empty for automatic):\n")
+			scannedCoins := bufio.NewScanner(conn)
+			if runType == "auto" {
+				scannedCoins = bufio.NewScanner(strings.NewReader("\n"))
+			}
+			choice := ""
+			for scannedCoins.Scan() {
+				choice = strings.TrimSpace(scannedCoins.Text())
+				break
+			}
+			if choice == "" {
+				spentCoins = selectCoins(coins, amount+reward)
+			} else {
+				spentCoins, err = parseCoinChoice(choice, coins)
+				if err != nil {
+					io.WriteString(conn, err.Error()+"\n")
+					continue
+				}
+			}
+			if spentCoins == nil {
+				io.WriteString(conn, "Not enough unspent coins for this transaction\n")
+				time.Sleep(1 * time.Second)
+				continue
+			}
+		}
+
 		transactionIDLock.Lock()
 		curTransactionID := transactionID
 		transactionID++
 		transactionIDLock.Unlock()
 
-		curTransaction := generateTransaction(curTransactionID, users[curUser.Name], users[receiverName], amount, reward)
+		var curTransaction Transaction
+		if ledgerMode == utxoLedgerMode {
+			curTransaction = generateUTXOTransaction(curTransactionID, users[curUser.Name], users[receiverName], amount, reward, spentCoins)
+		} else {
+			curTransaction = generateTransaction(curTransactionID, users[curUser.Name], users[receiverName], amount, reward)
+		}
 
 		//Broadcast current transaction to all validators
 		validatorsSliceLock.Lock()
@@ -232,12 +342,10 @@ func handleUserConnection(conn net.Conn, runType string) {
 		validatorsSliceLock.Unlock()
 		transactionString := fmt.Sprintf("Sent transaction %d\n", curTransaction.ID)
 		io.WriteString(conn, transactionString)
-		for _, validator := range validatorsCopy {
-			msg := NewTransactionMessage{
-				transaction: curTransaction,
-			}
-			validator.transactionChannel <- msg
+		msg := NewTransactionMessage{
+			transaction: curTransaction,
 		}
+		submitTransaction(curUser.Name, validatorsCopy, msg)
 		time.Sleep(1 * time.Second)
 	}
 
@@ -0,0 +1,332 @@
+package pos
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Ledger models transactions can be executed under, chosen by LEDGER_MODE
+const (
+	accountLedgerMode = "account"
+	utxoLedgerMode    = "utxo"
+)
+
+var ledgerMode = accountLedgerMode
+
+// OutPoint names an output by the hash of the transaction that created it and its position
+type OutPoint struct {
+	Hash  string
+	Index int
+}
+
+// TxOutput is a coin created by a transaction
+type TxOutput struct {
+	Address string
+	Amount  float64
+}
+
+func (o OutPoint) key() string {
+	return o.Hash + ":" + strconv.Itoa(o.Index)
+}
+
+// utxo is an unspent output as kept in the ledger state
+type utxo struct {
+	address string
+	amount  int64
+}
+
+// coin is an unspent output a wallet can spend
+type coin struct {
+	outPoint OutPoint
+	amount   float64
+}
+
+var errNoInputs = errors.New("transaction spends no inputs")
+var errInputSpent = errors.New("input is spent or does not exist")
+var errInputNotOwned = errors.New("input does not belong to the sender")
+var errConflictingInput = errors.New("input is spent twice")
+var errInputsDoNotBalance = errors.New("inputs do not equal outputs plus fee")
+
+// Each user's genesis balance is a single output spendable by its address
+var genesisOutputs = make(map[string]utxo)
+
+func loadLedgerConfig() {
+	ledgerMode = envString("LEDGER_MODE", ledgerMode)
+	if ledgerMode != accountLedgerMode && ledgerMode != utxoLedgerMode {
+		fmt.Printf("Unknown LEDGER_MODE %s, using %s\n", ledgerMode, accountLedgerMode)
+		ledgerMode = accountLedgerMode
+	}
+}
+
+// genesisOutPoint is the output holding an address's genesis balance
+func genesisOutPoint(address string) OutPoint {
+	hashed := sha256.Sum256([]byte("genesis" + address))
+	return OutPoint{Hash: hex.EncodeToString(hashed[:]), Index: 0}
+}
+
+// transactionHash identifies a transaction by its signed content, so a copy under another ID spends the same outputs
+func transactionHash(t Transaction) string {
+	hashed := sha256.Sum256(transactionSigningData(t))
+	return hex.EncodeToString(hashed[:])
+}
+
+// outputLocked returns the unspent output at key, or nil if it is spent or was never created
+func (s *accountState) outputLocked(outPoint OutPoint) *utxo {
+	key := outPoint.key()
+	if output, ok := s.outputs[key]; ok {
+		return output
+	}
+	genesisLock.Lock()
+	defer genesisLock.Unlock()
+	if output, ok := genesisOutputs[key]; ok {
+		return &output
+	}
+	return nil
+}
+
+func (s *accountState) rememberOutput(record stateUndo, key string) {
+	if _, ok := record.outputs[key]; ok {
+		return
+	}
+	output, had := s.outputs[key]
+	record.outputs[key] = outputUndo{output: output, had: had}
+}
+
+// checkInputsLocked checks that a transaction spends unspent outputs of its sender and balances
+func (s *accountState) checkInputsLocked(transaction Transaction) error {
+	if len(transaction.Inputs) == 0 {
+		return errNoInputs
+	}
+	seen := make(map[string]bool)
+	total := int64(0)
+	for _, input := range transaction.Inputs {
+		if seen[input.key()] {
+			return errConflictingInput
+		}
+		seen[input.key()] = true
+		output := s.outputLocked(input)
+		if output == nil {
+			return errInputSpent
+		}
+		if output.address != transaction.Sender.Address {
+			return errInputNotOwned
+		}
+		total += output.amount
+	}
+	spent := toFixedPoint(transaction.Reward)
+	for _, output := range transaction.Outputs {
+		if toFixedPoint(output.Amount) <= 0 {
+			return errors.New("outputs must be positive")
+		}
+		spent += toFixedPoint(output.Amount)
+	}
+	if toFixedPoint(transaction.Reward) < 0 || total != spent {
+		return errInputsDoNotBalance
+	}
+	return nil
+}
+
+// executeOutputsLocked spends a transaction's inputs and creates its outputs, keeping balances in step
+func (s *accountState) executeOutputsLocked(transaction Transaction, record stateUndo) {
+	sender := transaction.Sender.Address
+	for _, input := range transaction.Inputs {
+		key := input.key()
+		output := s.outputLocked(input)
+		s.rememberOutput(record, key)
+		s.outputs[key] = nil
+		s.balances[sender] = s.balanceLocked(sender) - output.amount
+	}
+	hash := transactionHash(transaction)
+	for i, output := range transaction.Outputs {
+		key := OutPoint{Hash: hash, Index: i}.key()
+		s.rememberOutput(record, key)
+		s.remember(record, output.Address)
+		s.outputs[key] = &utxo{address: output.Address, amount: toFixedPoint(output.Amount)}
+		s.balances[output.Address] = s.balanceLocked(output.Address) + toFixedPoint(output.Amount)
+	}
+}
+
+// coins lists the unspent outputs an address owns in this state
+func (s *accountState) coins(address string) []coin {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	coins := make([]coin, 0)
+	genesis := genesisOutPoint(address)
+	if _, touched := s.outputs[genesis.key()]; !touched {
+		if output := s.outputLocked(genesis); output != nil {
+			coins = append(coins, coin{outPoint: genesis, amount: fromFixedPoint(output.amount)})
+		}
+	}
+	for key, output := range s.outputs {
+		if output == nil || output.address != address {
+			continue
+		}
+		separator := strings.LastIndex(key, ":")
+		index, _ := strconv.Atoi(key[separator+1:])
+		coins = append(coins, coin{outPoint: OutPoint{Hash: key[:separator], Index: index}, amount: fromFixedPoint(output.amount)})
+	}
+	sort.Slice(coins, func(i, j int) bool {
+		if coins[i].amount != coins[j].amount {
+			return coins[i].amount > coins[j].amount
+		}
+		return coins[i].outPoint.key() < coins[j].outPoint.key()
+	})
+	return coins
+}
+
+// Coins a user has spent in transactions that may still be pending, keyed by outpoint
+var walletSpent = make(map[string]time.Time)
+var walletSpentLock = &sync.Mutex{}
+
+// walletCoins lists a user's spendable coins as seen by the validator with the longest chain,
+// leaving out coins its own recent transactions already spend
+func walletCoins(user *User) []coin {
+	validatorsSliceLock.Lock()
+	var view *Validator
+	for _, validator := range validators {
+		if view == nil || len(validator.Blockchain) > len(view.Blockchain) {
+			view = validator
+		}
+	}
+	validatorsSliceLock.Unlock()
+	if view == nil {
+		return nil
+	}
+
+	walletSpentLock.Lock()
+	defer walletSpentLock.Unlock()
+	cutoff := time.Now().Add(-time.Duration(mempoolTTL) * time.Second)
+	spendable := make([]coin, 0)
+	for _, c := range view.state.coins(user.Address) {
+		if spentAt, ok := walletSpent[c.outPoint.key()]; ok && spentAt.After(cutoff) {
+			continue
+		}
+		spendable = append(spendable, c)
+	}
+	return spendable
+}
+
+// selectCoins picks the largest coins until they cover total, or returns nil if they cannot
+func selectCoins(coins []coin, total float64) []coin {
+	selected := make([]coin, 0)
+	sum := 0.0
+	for _, c := range coins {
+		if sum >= total {
+			break
+		}
+		selected = append(selected, c)
+		sum += c.amount
+	}
+	if sum < total {
+		return nil
+	}
+	return selected
+}
+
+// generateUTXOTransaction pays amount to receiver from the given coins, returning the change to the sender
+func generateUTXOTransaction(index int, sender *User, receiver *User, amount float64, reward float64, coins []coin) Transaction {
+	sender.userLock.Lock()
+	nonce := sender.nonce
+	sender.nonce++
+	sender.userLock.Unlock()
+
+	total := int64(0)
+	inputs := make([]OutPoint, 0, len(coins))
+	for _, c := range coins {
+		inputs = append(inputs, c.outPoint)
+		total += toFixedPoint(c.amount)
+	}
+	outputs := []TxOutput{{Address: userAddress(receiver), Amount: amount}}
+	change := total - toFixedPoint(amount) - toFixedPoint(reward)
+	if change > 0 {
+		outputs = append(outputs, TxOutput{Address: sender.Address, Amount: fromFixedPoint(change)})
+	}
+
+	transaction := Transaction{
+		ID:       index,
+		Sender:   sender,
+		Receiver: receiver,
+		Amount:   amount,
+		Reward:   reward,
+		Nonce:    nonce,
+		Inputs:   inputs,
+		Outputs:  outputs,
+	}
+	signTransaction(&transaction, sender.keys)
+	recordTransactionSubmitted(index)
+
+	walletSpentLock.Lock()
+	for _, input := range inputs {
+		walletSpent[input.key()] = time.Now()
+	}
+	walletSpentLock.Unlock()
+	return transaction
+}
+
+// parseCoinChoice reads comma separated positions in the coin list a user was shown
+func parseCoinChoice(choice string, coins []coin) ([]coin, error) {
+	selected := make([]coin, 0)
+	chosen := make(map[int]bool)
+	for _, field := range strings.Split(choice, ",") {
+		position, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || position < 0 || position >= len(coins) {
+			return nil, fmt.Errorf("%s is not a listed coin", strings.TrimSpace(field))
+		}
+		if chosen[position] {
+			continue
+		}
+		chosen[position] = true
+		selected = append(selected, coins[position])
+	}
+	return selected, nil
+}
+
+// spendsSameOutput reports whether two transactions share an input
+func spendsSameOutput(a Transaction, b Transaction) bool {
+	for _, x := range a.Inputs {
+		for _, y := range b.Inputs {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Outputs seen spent by different transactions on different validators' chains
+var conflictingOutPoints = make(map[string]bool)
+
+// recordConflictingOutputs finds outputs spent by different transactions on different validators' chains
+func recordConflictingOutputs() {
+	validatorsSliceLock.Lock()
+	validatorsCopy := make([]*Validator, len(validators))
+	copy(validatorsCopy, validators)
+	validatorsSliceLock.Unlock()
+
+	spenders := make(map[string]map[string]bool)
+	for _, validator := range validatorsCopy {
+		for _, block := range validator.Blockchain {
+			for _, transaction := range block.Transactions {
+				hash := transactionHash(transaction)
+				for _, input := range transaction.Inputs {
+					if spenders[input.key()] == nil {
+						spenders[input.key()] = make(map[string]bool)
+					}
+					spenders[input.key()][hash] = true
+				}
+			}
+		}
+	}
+	for key, hashes := range spenders {
+		if len(hashes) > 1 {
+			conflictingOutPoints[key] = true
+		}
+	}
+}
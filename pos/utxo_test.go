@@ -0,0 +1,86 @@
+package pos
+
+import "testing"
+
+// useUTXOLedgerForTest switches the ledger to utxo mode until the test ends
+func useUTXOLedgerForTest(t *testing.T) {
+	t.Helper()
+	previousMode := ledgerMode
+	ledgerMode = utxoLedgerMode
+	t.Cleanup(func() { ledgerMode = previousMode })
+}
+
+// genesisCoin is the single coin holding a user's genesis balance
+func genesisCoin(user *User) coin {
+	return coin{outPoint: genesisOutPoint(user.Address), amount: user.Balance}
+}
+
+func TestCheckInputs(t *testing.T) {
+	useUTXOLedgerForTest(t)
+	alice := newTestUser(t, "alice", 100)
+	bob := newTestUser(t, "bob", 100)
+	state := newAccountState()
+	aliceCoin := genesisOutPoint(alice.Address)
+
+	payment := generateUTXOTransaction(1, alice, bob, 60, 1, []coin{genesisCoin(alice)})
+	if err := state.checkInputsLocked(payment); err != nil {
+		t.Fatalf("valid payment: got %v, want nil", err)
+	}
+
+	for _, test := range []struct {
+		name        string
+		transaction Transaction
+		want        error
+	}{
+		{"no inputs", Transaction{Sender: alice, Outputs: []TxOutput{{Address: bob.Address, Amount: 1}}}, errNoInputs},
+		{"not owned", Transaction{Sender: bob, Inputs: []OutPoint{aliceCoin}, Outputs: []TxOutput{{Address: bob.Address, Amount: 99}}, Reward: 1}, errInputNotOwned},
+		{"duplicate input", Transaction{Sender: alice, Inputs: []OutPoint{aliceCoin, aliceCoin}, Outputs: []TxOutput{{Address: bob.Address, Amount: 199}}, Reward: 1}, errConflictingInput},
+		{"unbalanced", Transaction{Sender: alice, Inputs: []OutPoint{aliceCoin}, Outputs: []TxOutput{{Address: bob.Address, Amount: 100}}, Reward: 1}, errInputsDoNotBalance},
+		{"unknown input", Transaction{Sender: alice, Inputs: []OutPoint{{Hash: "missing"}}, Outputs: []TxOutput{{Address: bob.Address, Amount: 1}}}, errInputSpent},
+	} {
+		if err := state.checkInputsLocked(test.transaction); err != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+	}
+
+	if err := state.applyBlock(newTestBlock(newTestGenesis(), "proposer", []Transaction{payment})); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.checkInputsLocked(payment); err != errInputSpent {
+		t.Fatalf("spent input: got %v, want %v", err, errInputSpent)
+	}
+}
+
+func TestRevertUTXOBlock(t *testing.T) {
+	useUTXOLedgerForTest(t)
+	alice := newTestUser(t, "alice", 100)
+	bob := newTestUser(t, "bob", 100)
+	state := newAccountState()
+
+	payment := generateUTXOTransaction(1, alice, bob, 60, 1, []coin{genesisCoin(alice)})
+	if err := state.applyBlock(newTestBlock(newTestGenesis(), "proposer", []Transaction{payment})); err != nil {
+		t.Fatal(err)
+	}
+	if state.balance(alice.Address) != 39 || state.balance(bob.Address) != 160 {
+		t.Fatalf("got balances %f and %f, want 39 and 160", state.balance(alice.Address), state.balance(bob.Address))
+	}
+	change := state.coins(alice.Address)
+	if len(change) != 1 || change[0].amount != 39 {
+		t.Fatalf("got %d coins for alice, want the change of 39", len(change))
+	}
+
+	state.revertBlock()
+	if state.balance(alice.Address) != 100 || state.balance(bob.Address) != 100 {
+		t.Fatalf("got balances %f and %f after revert, want 100 and 100", state.balance(alice.Address), state.balance(bob.Address))
+	}
+	coins := state.coins(alice.Address)
+	if len(coins) != 1 || coins[0].outPoint != genesisOutPoint(alice.Address) {
+		t.Fatal("genesis coin is not spendable again after revert")
+	}
+	if len(state.coins(bob.Address)) != 1 {
+		t.Fatal("payment output still exists after revert")
+	}
+	if err := state.checkInputsLocked(payment); err != nil {
+		t.Fatalf("payment after revert: got %v, want nil", err)
+	}
+}
@@ -2,10 +2,6 @@ package pos
 
 import (
 	"bufio"
-	"crypto"
-	"crypto/rsa"
-	"crypto/sha256"
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
@@ -25,41 +21,49 @@ type Validator struct {
 	transactionChannel         chan NewTransactionMessage
 	delegateVoteRequestChannel chan DelegateVoteRequestMessage
 	delegateVoteChannel        chan DelegateVoteMessage
+	syncRequestChannel         chan interface{}
+	syncResponseChannel        chan interface{}
+	name                       string
 	Address                    string
+	PublicKey                  []byte
+	keys                       keyPair
 	Stake                      float64
-	unconfirmedTransactions    map[int]Transaction
-	confirmedTransactions      map[int]bool
+	pool                       *mempool
+	state                      *accountState
+	chainLock                  *sync.Mutex
 	IsMalicious                bool
-	validatorLock              sync.Mutex
-	transactionPoolLock        sync.Mutex
+	isSybil                    bool
 	committeeCount             int
 	proposerCount              int
 	blockSuccessCount          int
 	reputation                 float64
+	initialStake               float64
+	earned                     float64
+	withdrawn                  float64
+	attestationCount           int
 	Blockchain                 []Block
 }
 
 // generateBlock creates a new block using previous block's hash
 func generateBlock(proposer *Validator) (Block, error) {
+	return generateBlockFrom(proposer, proposer.Blockchain[len(proposer.Blockchain)-1], proposer.state, nil)
+}
+
+// generateBlockFrom creates a new block on top of oldBlock, whose post-execution state is given,
+// skipping transactions in exclude
+func generateBlockFrom(proposer *Validator, oldBlock Block, state *accountState, exclude map[int]bool) (Block, error) {
 
 	var newBlock Block
 
 	//read transactions from local mempool if there are enough
-	transactions := []Transaction{}
-	if len(proposer.unconfirmedTransactions) > 0 {
-		proposer.validatorLock.Lock()
-		transactionsSize := len(proposer.unconfirmedTransactions)
-		if transactionsSize > 5 {
-			transactionsSize = 5
-		}
-		for id := range proposer.unconfirmedTransactions {
-			transactions = append(transactions, proposer.unconfirmedTransactions[id])
-			if transactionsSize == len(transactions) {
-				break
-			}
-		}
-		proposer.validatorLock.Unlock()
-	} else {
+	candidates := proposer.pool.candidates(exclude)
+
+	//highest fees first, keeping each sender's transactions in nonce order
+	baseFee := nextBaseFee(oldBlock)
+	candidates = orderByFee(candidates, baseFee)
+	//only keep transactions that execute on top of the parent state
+	transactions := state.selectTransactions(candidates, proposer.Address, baseFee, blockCapacity)
+	if len(transactions) == 0 {
 		//else return an error
 		err := errors.New("No transactions to validate")
 		return newBlock, err
@@ -68,18 +72,37 @@ func generateBlock(proposer *Validator) (Block, error) {
 	//set block information
 
 	t := time.Now()
-	oldBlock := proposer.Blockchain[len(proposer.Blockchain)-1]
 	newBlock.Index = oldBlock.Index + 1
 	newBlock.Timestamp = t.String()
 	newBlock.PrevHash = oldBlock.Hash
 	newBlock.Validator = proposer.Address
 	newBlock.Transactions = transactions
+	newBlock.BaseFee = baseFee
+	newBlock.TxRoot = transactionRoot(transactions)
+	stateRoot, err := state.rootAfter(newBlock)
+	if err != nil {
+		return newBlock, err
+	}
+	newBlock.StateRoot = stateRoot
 	newBlock.Hash = calculateBlockHash(newBlock)
+	signBlock(&newBlock, proposer)
 	newBlock.IsMalicious = proposer.IsMalicious
 
 	return newBlock, nil
 }
 
+// validatorByAddress finds a connected validator from the address recorded in a block
+func validatorByAddress(address string) *Validator {
+	validatorsSliceLock.Lock()
+	defer validatorsSliceLock.Unlock()
+	for _, validator := range validators {
+		if validator.Address == address {
+			return validator
+		}
+	}
+	return nil
+}
+
 func balanceAttackIsBlockValid(newBlock Block, malVote bool, malValidator bool) bool {
 	oldBlock := proposer.Blockchain[len(proposer.Blockchain)-1]
 
@@ -98,6 +121,11 @@ func balanceAttackIsBlockValid(newBlock Block, malVote bool, malValidator bool)
 		return false
 	}
 
+	if transactionRoot(newBlock.Transactions) != newBlock.TxRoot {
+		fmt.Println("Transaction root does not match the transactions")
+		return false
+	}
+
 	if calculateBlockHash(newBlock) != newBlock.Hash {
 		fmt.Println("Recomputation of the hash is incorrect")
 		return false
@@ -119,6 +147,11 @@ func isBlockValid(newBlock Block) bool {
 		return false
 	}
 
+	if transactionRoot(newBlock.Transactions) != newBlock.TxRoot {
+		fmt.Println("Transaction root does not match the transactions")
+		return false
+	}
+
 	if calculateBlockHash(newBlock) != newBlock.Hash {
 		fmt.Println("Recomputation of the hash is incorrect")
 		return false
@@ -135,47 +168,88 @@ func isTransactionValid(transaction Transaction, validator *Validator) bool {
 	}
 
 	//Public key verifies transaction
-	signatureBytes, _ := hex.DecodeString(transaction.Signature)
-
-	// Compute the transaction hash
-	data := fmt.Sprintf("%d%p%p%f%f", transaction.ID, transaction.Sender, transaction.Receiver, transaction.Amount, transaction.Reward)
-	hash := sha256.Sum256([]byte(data))
-
-	err := rsa.VerifyPKCS1v15(transaction.Sender.PublicKey, crypto.SHA256, hash[:], signatureBytes)
-	if err != nil {
+	if !verifySignature(transaction.Sender.keys.scheme(), transaction.Sender.PublicKey, transactionSigningData(transaction), transaction.Signature) {
 		io.WriteString(validator.conn, "Transaction could not be verified with public key\n")
 		return false
 	}
 
 	//Transaction was already spent
-	if validator.confirmedTransactions[transaction.ID] == true {
+	if validator.pool.isConfirmed(transaction.ID) {
 		io.WriteString(validator.conn, "Transaction was already spent\n")
 		return false
 	}
-	//User has insufficient funds
-	transaction.Sender.userLock.Lock()
-	if (transaction.Amount + transaction.Reward) > transaction.Sender.Balance {
-		io.WriteString(validator.conn, "Sender has insufficient funds\n")
-		transaction.Sender.userLock.Unlock()
+	//Nonce was already used or user has insufficient funds on the validator's chain
+	//a future nonce waits in the mempool until the sender's earlier transactions execute
+	if err := validator.state.checkTransaction(transaction); err != nil && err != errNonceAhead {
+		if err == errInsufficientFunds {
+			io.WriteString(validator.conn, "Sender has insufficient funds\n")
+		} else if err == errNonceUsed {
+			io.WriteString(validator.conn, "Transaction nonce was already used\n")
+		} else if err == errInputSpent || err == errConflictingInput {
+			io.WriteString(validator.conn, "Transaction spends an output that was already spent\n")
+		} else {
+			io.WriteString(validator.conn, "Transaction cannot be executed: "+err.Error()+"\n")
+		}
 		return false
 	}
-	transaction.Sender.userLock.Unlock()
 	io.WriteString(validator.conn, "Transaction is valid\n")
 	return true
 }
 
-func handleValidatorConnection(conn net.Conn, runType string, malString string, splitView bool) {
+// receiveVerifiedBlock appends a certified block that extends the validator's head, and starts a
+// catch-up when the block is too far ahead of it
+func receiveVerifiedBlock(validator *Validator, msg VerifiedBlockMessage) {
+	head := chainHead(validator)
+	if msg.newBlock.PrevHash != head.Hash || msg.newBlock.Index != head.Index+1 {
+		io.WriteString(validator.conn, "Validator rejected verified block because of different view of chain\n")
+		//blocks were missed, e.g. while crashed or cut off, download them from peers
+		if isBehind(msg.newBlock, validator) {
+			catchUp(validator)
+		}
+		return
+	}
+	if err := verifyBlockCertificate(msg.newBlock); err != nil {
+		io.WriteString(validator.conn, "Validator rejected verified block because its signatures do not verify: "+err.Error()+"\n")
+		return
+	}
+	if err := appendBlock(validator, msg.newBlock, true); err != nil {
+		io.WriteString(validator.conn, "Validator rejected verified block because "+err.Error()+"\n")
+		return
+	}
+	//mark verified transactions confirmed and take them out of the mempool
+	validator.pool.confirm(msg.transactions)
+}
+
+// appendBlock executes a certified block on the validator's state and adds it to its chain. With
+// onHead set the block must still extend the validator's head, which a reorg may have replaced
+func appendBlock(validator *Validator, block Block, onHead bool) error {
+	validator.chainLock.Lock()
+	defer validator.chainLock.Unlock()
+	head := validator.Blockchain[len(validator.Blockchain)-1]
+	if onHead && (block.PrevHash != head.Hash || block.Index != head.Index+1) {
+		return errors.New("it no longer extends the validator's head")
+	}
+	if err := validator.state.applyBlock(block); err != nil {
+		return fmt.Errorf("it is not a valid state transition: %s", err.Error())
+	}
+	validator.Blockchain = append(validator.Blockchain, block)
+	return nil
+}
+
+func handleValidatorConnection(conn net.Conn, runType string, malString string, stakeString string, sybil bool, splitView bool) {
 	defer conn.Close()
 
 	//Enter initial stake and whether or not validator is malicious
 	io.WriteString(conn, "Enter token stake:\n")
 	scannedBalance := bufio.NewScanner(conn)
 	if runType == "auto" {
-		randomStake := 0.0
-		rand.Seed(time.Now().UnixNano())
-		randomStake = rand.Float64()*700 + 300
-		randomStakeString := fmt.Sprintf("%f", randomStake)
-		scannedBalance = bufio.NewScanner(strings.NewReader(randomStakeString))
+		//stake can be fixed by the caller, e.g. a sybil adversary splitting its budget
+		if stakeString == "" {
+			randomStake := 0.0
+			randomStake = rand.Float64()*700 + 300
+			stakeString = fmt.Sprintf("%f", randomStake)
+		}
+		scannedBalance = bufio.NewScanner(strings.NewReader(stakeString))
 	}
 	balance := 0.0
 	var err error
@@ -205,13 +279,18 @@ func handleValidatorConnection(conn net.Conn, runType string, malString string,
 		break
 	}
 
-	//Calculate address based on time
-	t := time.Now()
-	address := calculateHash(t.String())
+	//key the validator signs proposed blocks and committee votes with
+	name := nextValidatorName()
+	keys, err := loadOrGenerateKey(name)
+	if err != nil {
+		io.WriteString(conn, "Could not load validator key: "+err.Error()+"\n")
+		return
+	}
+
+	//Calculate address based on public key
+	address := addressFromPublicKey(keys.publicKey())
 
 	//Instantiate new validator
-	unconfirmedTransactions := make(map[int]Transaction)
-	confirmedTransactions := make(map[int]bool)
 	curValidator := &Validator{
 		conn:                       conn,
 		incomingChannel:            make(chan interface{}),
@@ -219,50 +298,103 @@ func handleValidatorConnection(conn net.Conn, runType string, malString string,
 		transactionChannel:         make(chan NewTransactionMessage),
 		delegateVoteRequestChannel: make(chan DelegateVoteRequestMessage),
 		delegateVoteChannel:        make(chan DelegateVoteMessage),
+		syncRequestChannel:         make(chan interface{}, 64),
+		syncResponseChannel:        make(chan interface{}, 64),
+		name:                       name,
 		Address:                    address,
+		PublicKey:                  keys.publicKey(),
+		keys:                       keys,
 		Stake:                      balance,
-		unconfirmedTransactions:    unconfirmedTransactions,
-		confirmedTransactions:      confirmedTransactions,
+		initialStake:               balance,
+		pool:                       newMempool(),
+		chainLock:                  &sync.Mutex{},
 		IsMalicious:                isMal,
-		validatorLock:              sync.Mutex{},
-		transactionPoolLock:        sync.Mutex{},
+		isSybil:                    sybil,
 		committeeCount:             0,
 		proposerCount:              0,
 		reputation:                 5.0,
 	}
 
+	//a validator of a resumed simulation takes over its snapshot
+	snap, resumed := resumedValidator(name)
+	if resumed {
+		if snap.Address != address {
+			io.WriteString(conn, "Key of "+name+" does not match the snapshot\n")
+			return
+		}
+		restoreValidator(curValidator, snap)
+	}
+
 	//set view of chain to fork if needed for balance attack
-	if splitView{
+	if resumed {
+		chain, err := loadChain(snap.Chain)
+		if err != nil {
+			io.WriteString(conn, "Could not restore chain: "+err.Error()+"\n")
+			return
+		}
+		if err := restoreMempool(curValidator.pool, chain, snap.Mempool); err != nil {
+			io.WriteString(conn, "Could not restore mempool: "+err.Error()+"\n")
+			return
+		}
+		curValidator.Blockchain = chain
+	}else if splitView{
 		curValidator.Blockchain = make([]Block, len(balanceAttackFork))
 		copy(curValidator.Blockchain, balanceAttackFork)
 	}else{
-		curValidator.Blockchain = make([]Block, len(CertifiedBlockchain))
-		copy(curValidator.Blockchain, CertifiedBlockchain)
+		//start from the genesis block and download the rest of the chain from the running validators
+		curValidator.Blockchain = []Block{CertifiedBlockchain[0]}
+	}
+	state, err := stateFromChain(curValidator.Blockchain)
+	if err != nil {
+		//keep the part of the chain that executes, sync downloads the rest
+		io.WriteString(conn, "Chain cut at a block that does not execute: "+err.Error()+"\n")
+		curValidator.Blockchain = curValidator.Blockchain[:state.height()]
+	}
+	curValidator.state = state
+	if !splitView && !resumed {
+		joinSync(curValidator)
 	}
+	go serveSync(curValidator)
 
+	if !resumed {
+		registerGenesisStake(address, balance)
+	}
+	//sybil identities join while the initial validators are still connecting
+	validatorsSliceLock.Lock()
 	validators = append(validators, curValidator)
 
-	ForkedBlockchain[forkedCounter%2][forkedCounter/2] = curValidator
+	//validators joining after the initial set (e.g. sybil identities) grow their group
+	if forkedCounter/2 < len(ForkedBlockchain[forkedCounter%2]) {
+		ForkedBlockchain[forkedCounter%2][forkedCounter/2] = curValidator
+	} else {
+		ForkedBlockchain[forkedCounter%2] = append(ForkedBlockchain[forkedCounter%2], curValidator)
+	}
 	forkedCounter += 1
 
-	if isMal {
+	if curValidator.IsMalicious {
 		malValidators = append(malValidators, curValidator)
 	}
-
 	fmt.Printf("new validator count: %d\n", len(validators))
+	validatorsSliceLock.Unlock()
 
 	//listen for transactions in transaction channel
 	go func() {
 		for {
 			msg := <-curValidator.transactionChannel
+			if droppedWhileDown(curValidator) {
+				continue
+			}
+			if !gossipTransaction(curValidator, msg) {
+				continue
+			}
 			//Receiving unverified transactions
 			io.WriteString(conn, "Received unverified transaction\n")
 			isValid := isTransactionValid(msg.transaction, curValidator)
-			curValidator.transactionPoolLock.Lock()
 			if isValid {
-				curValidator.unconfirmedTransactions[msg.transaction.ID] = msg.transaction
+				if err := curValidator.pool.add(msg.transaction); err != nil {
+					io.WriteString(conn, "Transaction not added to mempool: "+err.Error()+"\n")
+				}
 			}
-			curValidator.transactionPoolLock.Unlock()
 		}
 	}()
 
@@ -280,6 +412,10 @@ func handleValidatorConnection(conn net.Conn, runType string, malString string,
 			voteMsg := DelegateVoteMessage{
 				delegateVotes: validatorsCopy[:msg.delegateSize],
 			}
+			//sybil identities collude and vote for each other first
+			if curValidator.isSybil {
+				voteMsg.delegateVotes = sybilDelegateVotes(validatorsCopy, msg.delegateSize)
+			}
 
 			curValidator.delegateVoteChannel <- voteMsg
 
@@ -289,83 +425,104 @@ func handleValidatorConnection(conn net.Conn, runType string, malString string,
 	//listen for messages in communication channel
 	for {
 		msg := <-curValidator.incomingChannel
+		//a crashed validator neither votes nor extends its chain until it restarts
+		if droppedWhileDown(curValidator) {
+			//a catch-up that was running when it crashed is over, and the blocks it buffered are lost
+			if _, ok := msg.(CaughtUpMessage); ok {
+				finishCatchUp(curValidator)
+			}
+			continue
+		}
 		switch msg := msg.(type) {
 		//Receiving block to validate
 		case ValidateBlockMessage:
 			io.WriteString(conn, "Received a Block to validate\n")
 			isValid := isBlockValid(msg.newBlock)
 			if currAttack == "balance"{
+				//validators of the balance attack judge the block against the proposer's fork, not their own head
 				isValid = balanceAttackIsBlockValid(msg.newBlock, msg.malVote, curValidator.IsMalicious)
-			} 
+			} else {
+				isValid = isValid && isBlockStateValid(msg.newBlock, curValidator)
+			}
+			if currAttack == "adaptive" || currAttack == "light_client" {
+				isValid = adaptiveIsBlockValid(msg.newBlock, isValid, curValidator.IsMalicious)
+			}
 			validationStatusMessage := ValidationStatusMessage{
+				hash:    msg.newBlock.Hash,
 				isValid: isValid,
 			}
-			curValidator.outgoingChannel <- validationStatusMessage
+			if isValid {
+				validationStatusMessage.signature = signCommitVote(curValidator, msg.newBlock.Hash)
+			}
+			replyToServer(curValidator, msg.newBlock.Validator, validationStatusMessage)
+			//a validator that is behind the proposer downloads the missed blocks before the next vote
+			if isBehind(msg.newBlock, curValidator) {
+				catchUp(curValidator)
+			}
 		//Receiving blocks to validate (short attack ed.)
 		case ValidateShortAttackBlockMessage:
 			io.WriteString(conn, "Received both Blocks to validate\n")
-			isValid := isBlockValid(msg.newBlock)
-			isValidTwo := isBlockValid(msg.newBlockTwo)
+			isValid := isBlockValid(msg.newBlock) && isBlockStateValid(msg.newBlock, curValidator)
+			isValidTwo := isBlockValid(msg.newBlockTwo) && isBlockStateValid(msg.newBlockTwo, curValidator)
 			validationShortAttackStatusMessage := ValidationShortAttackStatusMessage{
+				hash:       msg.newBlock.Hash,
+				hashTwo:    msg.newBlockTwo.Hash,
 				isValid:    isValid,
 				isValidTwo: isValidTwo,
 			}
-			curValidator.outgoingChannel <- validationShortAttackStatusMessage
+			if isValid {
+				validationShortAttackStatusMessage.signature = signCommitVote(curValidator, msg.newBlock.Hash)
+			}
+			if isValidTwo {
+				validationShortAttackStatusMessage.signatureTwo = signCommitVote(curValidator, msg.newBlockTwo.Hash)
+			}
+			replyToServer(curValidator, msg.newBlock.Validator, validationShortAttackStatusMessage)
 		//Receiving verified transactions
 		case VerifiedBlockMessage:
+			if !gossipBlock(curValidator, msg) {
+				break
+			}
 			io.WriteString(conn, "Received verified transaction\n")
-			curValidatorLastBlock := curValidator.Blockchain[len(curValidator.Blockchain)-1]
-			if msg.newBlock.PrevHash != curValidatorLastBlock.Hash || msg.newBlock.Index != curValidatorLastBlock.Index + 1 {
-				io.WriteString(conn, "Validator rejected verified block because of different view of chain\n")
-			} else{
-				//put verified transactions into confirmed slice for validator
-				curValidator.transactionPoolLock.Lock()
-				for _, transaction := range msg.transactions {
-					curValidator.confirmedTransactions[transaction.ID] = true
-				}
-
-				//take transactions out of unconfirmed map
-				for _, transaction := range msg.transactions {
-					delete(curValidator.unconfirmedTransactions, transaction.ID)
-				}
-				curValidator.transactionPoolLock.Unlock()
-
-				//add new block
-				curValidator.Blockchain = append(curValidator.Blockchain, msg.newBlock)
+			if bufferWhileCatchingUp(curValidator, msg) {
+				break
+			}
+			receiveVerifiedBlock(curValidator, msg)
+		//blocks that arrived during a catch-up are applied on top of the synced chain
+		case CaughtUpMessage:
+			for _, buffered := range finishCatchUp(curValidator) {
+				receiveVerifiedBlock(curValidator, buffered)
 			}
 
 		case VerifiedShortAttackBlockMessage:
+			if !gossipSideBlock(curValidator, msg.newBlock, msg.side, msg) {
+				break
+			}
 			io.WriteString(conn, "Received verified transaction\n")
-			//put verified transactions into confirmed slice for validator
-			curValidator.validatorLock.Lock()
-			for _, transaction := range msg.transactions {
-				curValidator.confirmedTransactions[transaction.ID] = true
+			if err := verifyBlockCertificate(msg.newBlock); err != nil {
+				io.WriteString(conn, "Validator rejected verified block because its signatures do not verify: "+err.Error()+"\n")
+				break
 			}
-
-			//take transactions out of unconfirmed map
-			for _, transaction := range msg.transactions {
-				delete(curValidator.unconfirmedTransactions, transaction.ID)
+			if err := appendBlock(curValidator, msg.newBlock, false); err != nil {
+				io.WriteString(conn, "Validator rejected verified block because "+err.Error()+"\n")
+				break
 			}
-			curValidator.validatorLock.Unlock()
-
-			//add new block
-			curValidator.Blockchain = append(curValidator.Blockchain, msg.newBlock)
+			//mark verified transactions confirmed and take them out of the mempool
+			curValidator.pool.confirm(msg.transactions)
 		case VerifiedShortAttackBlockTwoMessage:
+			if !gossipSideBlock(curValidator, msg.newBlockTwo, msg.side, msg) {
+				break
+			}
 			io.WriteString(conn, "Received verified transaction\n")
-			//put verified transactions into confirmed slice for validator
-			curValidator.validatorLock.Lock()
-			for _, transaction := range msg.transactions {
-				curValidator.confirmedTransactions[transaction.ID] = true
+			if err := verifyBlockCertificate(msg.newBlockTwo); err != nil {
+				io.WriteString(conn, "Validator rejected verified block because its signatures do not verify: "+err.Error()+"\n")
+				break
 			}
-
-			//take transactions out of unconfirmed map
-			for _, transaction := range msg.transactions {
-				delete(curValidator.unconfirmedTransactions, transaction.ID)
+			if err := appendBlock(curValidator, msg.newBlockTwo, false); err != nil {
+				io.WriteString(conn, "Validator rejected verified block because "+err.Error()+"\n")
+				break
 			}
-			curValidator.validatorLock.Unlock()
-
-			//add new block
-			curValidator.Blockchain = append(curValidator.Blockchain, msg.newBlockTwo)
+			//mark verified transactions confirmed and take them out of the mempool
+			curValidator.pool.confirm(msg.transactions)
 
 		default:
 			io.WriteString(conn, "Received an unknown struct: %+v\n")